@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// accessibleMode reports whether CFOR_ACCESSIBLE asks for the screen-reader
+// friendly mode
+func accessibleMode() bool {
+	value := strings.ToLower(os.Getenv("CFOR_ACCESSIBLE"))
+	return value == "1" || value == "true"
+}
+
+// SelectCmdAccessible is the line-based counterpart of SelectCmd for screen
+// readers: suggestions are printed once, numbered, and every state change is
+// announced as a full sentence instead of redrawing the screen
+func SelectCmdAccessible(cmds []CmdEntry, opts SelectOptions) (string, error) {
+	fmt.Printf("%d suggestions.\n", len(cmds))
+	for i, cmd := range cmds {
+		fmt.Printf("%d: %s\n", i+1, cmd.Cmd)
+		description := cmd.Comment
+		if cmd.Tradeoff != "" {
+			description = cmd.Tradeoff + ". " + description
+		}
+		if description != "" {
+			fmt.Printf("   %s\n", description)
+		}
+		if cmd.Workdir != "" {
+			fmt.Printf("   Run from: %s\n", cmd.Workdir)
+		}
+		for _, warning := range cmd.Warnings {
+			fmt.Printf("   Warning: %s\n", warning)
+		}
+	}
+
+	explained := map[int]bool{}
+	pinned := map[int]bool{}
+	for i, cmd := range cmds {
+		pinned[i] = cmd.Pinned
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Printf("Enter a number from 1 to %d to select, x and a number to explain, i and a number to preview package changes, p and a number to keep it when rerunning, r to rerun, m to ask another model or q to quit: ", len(cmds))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return "", QuitError{}
+		}
+
+		input := strings.ToLower(strings.TrimSpace(line))
+		switch {
+		case input == "q":
+			fmt.Println("Quit without selecting a command.")
+			return "", QuitError{}
+		case input == "r":
+			kept := keptCmds(cmds, pinned)
+			if len(kept) > 0 {
+				fmt.Printf("Keeping %d suggestions and asking for different ones.\n", len(kept))
+			} else {
+				fmt.Println("Asking for fresh suggestions.")
+			}
+			return "", RerunError{Pinned: kept}
+		case input == "m":
+			model, ok := pickModelAccessible(reader, opts.Model)
+			if !ok {
+				continue
+			}
+			fmt.Printf("Asking %s instead.\n", model)
+			return "", RerunError{Pinned: keptCmds(cmds, pinned), Model: model}
+		case strings.HasPrefix(input, "p"):
+			index, ok := parseChoice(strings.TrimSpace(strings.TrimPrefix(input, "p")), len(cmds))
+			if !ok {
+				fmt.Printf("Not a suggestion number: %s\n", strings.TrimSpace(line))
+				continue
+			}
+			pinned[index] = !pinned[index]
+			if pinned[index] {
+				fmt.Printf("Suggestion %d will be kept when rerunning.\n", index+1)
+			} else {
+				fmt.Printf("Suggestion %d will no longer be kept.\n", index+1)
+			}
+		case strings.HasPrefix(input, "x"):
+			index, ok := parseChoice(strings.TrimSpace(strings.TrimPrefix(input, "x")), len(cmds))
+			if !ok {
+				fmt.Printf("Not a suggestion number: %s\n", strings.TrimSpace(line))
+				continue
+			}
+			if printExplanation(cmds[index].Cmd) {
+				explained[index] = true
+			}
+		case strings.HasPrefix(input, "i"):
+			index, ok := parseChoice(strings.TrimSpace(strings.TrimPrefix(input, "i")), len(cmds))
+			if !ok {
+				fmt.Printf("Not a suggestion number: %s\n", strings.TrimSpace(line))
+				continue
+			}
+			output, err := PreviewPackageCmd(cmds[index].Cmd)
+			if err != nil {
+				fmt.Printf("No preview of suggestion %d: %v.\n", index+1, err)
+				continue
+			}
+			fmt.Println(output)
+		default:
+			index, ok := parseChoice(input, len(cmds))
+			if !ok {
+				fmt.Printf("Not a suggestion number: %s\n", strings.TrimSpace(line))
+				continue
+			}
+			if opts.RequireExplanation && !explained[index] {
+				fmt.Printf("Explain suggestion %d first by entering x %d.\n", index+1, index+1)
+				continue
+			}
+			fmt.Printf("Selected suggestion %d: %s\n", index+1, cmds[index].Cmd)
+			return cmds[index].Cmd, nil
+		}
+	}
+}
+
+// keptCmds returns the suggestions pinned to be kept across a rerun
+func keptCmds(cmds []CmdEntry, pinned map[int]bool) []CmdEntry {
+	kept := []CmdEntry{}
+	for i, cmd := range cmds {
+		if pinned[i] {
+			cmd.Pinned = true
+			kept = append(kept, cmd)
+		}
+	}
+	return kept
+}
+
+// pickModelAccessible lists the models to ask again with and reads the choice
+func pickModelAccessible(reader *bufio.Reader, current string) (string, bool) {
+	models := SelectableModels()
+	if len(models) == 0 {
+		fmt.Println("No other models are available.")
+		return "", false
+	}
+
+	fmt.Printf("%d models.\n", len(models))
+	for i, model := range models {
+		if model == current {
+			fmt.Printf("%d: %s, current\n", i+1, model)
+		} else {
+			fmt.Printf("%d: %s\n", i+1, model)
+		}
+	}
+	fmt.Printf("Enter a number from 1 to %d to ask again with that model, or nothing to go back: ", len(models))
+	line, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) == "" {
+		fmt.Println("Back to the suggestions.")
+		return "", false
+	}
+
+	index, ok := parseChoice(strings.TrimSpace(line), len(models))
+	if !ok {
+		fmt.Printf("Not a model number: %s\n", strings.TrimSpace(line))
+		return "", false
+	}
+	return models[index], true
+}
+
+// parseChoice turns a 1-based suggestion number into an index
+func parseChoice(input string, count int) (int, bool) {
+	n, err := strconv.Atoi(input)
+	if err != nil || n < 1 || n > count {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// printExplanation explains the command and reports whether it succeeded
+func printExplanation(cmd string) bool {
+	for _, v := range ReferencedEnvVars(cmd) {
+		fmt.Printf("Variable %s is %s.\n", v.Name, v.Describe())
+	}
+
+	fmt.Println("Explaining the command.")
+	result, err := ExplainCmd(cmd)
+	RecordUsage(result)
+	if err != nil {
+		fmt.Println("Could not explain this command.")
+		return false
+	}
+
+	fmt.Println(result.Message.Summary)
+	for _, part := range result.Message.Parts {
+		fmt.Printf("   %s: %s\n", part.Part, part.Description)
+	}
+	return true
+}
+
+// AskGuideQuestionsAccessible asks the clarifying questions one line at a time
+func AskGuideQuestionsAccessible(tool string, questions []GuideQuestion) ([]string, error) {
+	fmt.Printf("%d questions about the %s command. Press Enter to skip one.\n", len(questions), tool)
+
+	reader := bufio.NewReader(os.Stdin)
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		fmt.Printf("%s (for example %s): ", question.Label, question.Placeholder)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Println()
+			return nil, QuitError{}
+		}
+		answers[i] = strings.TrimSpace(line)
+	}
+	return answers, nil
+}
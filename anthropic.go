@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// Anthropic Messages API configuration
+const (
+	anthropicBaseURL = "https://api.anthropic.com/v1/"
+	anthropicVersion = "2023-06-01"
+)
+
+const (
+	AnthropicModelClaude35Sonnet openai.ChatModel = "claude-3-5-sonnet-latest"
+	AnthropicModelClaude35Haiku  openai.ChatModel = "claude-3-5-haiku-latest"
+	AnthropicModelClaude37Sonnet openai.ChatModel = "claude-3-7-sonnet-latest"
+)
+
+// https://www.anthropic.com/pricing#api
+const (
+	// Claude 3.5 and 3.7 Sonnet
+	AnthropicModelSonnetInputCostPerToken       Cost = 3.00 * 1e-6
+	AnthropicModelSonnetCachedInputCostPerToken Cost = 0.30 * 1e-6
+	AnthropicModelSonnetOutputCostPerToken      Cost = 15.00 * 1e-6
+	// Claude 3.5 Haiku
+	AnthropicModelHaikuInputCostPerToken       Cost = 0.80 * 1e-6
+	AnthropicModelHaikuCachedInputCostPerToken Cost = 0.08 * 1e-6
+	AnthropicModelHaikuOutputCostPerToken      Cost = 4.00 * 1e-6
+)
+
+var anthropicSonnetCosts = CostPerToken{
+	Input:       AnthropicModelSonnetInputCostPerToken,
+	CachedInput: AnthropicModelSonnetCachedInputCostPerToken,
+	Output:      AnthropicModelSonnetOutputCostPerToken,
+}
+
+var AnthropicModelCosts = map[openai.ChatModel]CostPerToken{
+	AnthropicModelClaude35Sonnet: anthropicSonnetCosts,
+	AnthropicModelClaude37Sonnet: anthropicSonnetCosts,
+	AnthropicModelClaude35Haiku: {
+		Input:       AnthropicModelHaikuInputCostPerToken,
+		CachedInput: AnthropicModelHaikuCachedInputCostPerToken,
+		Output:      AnthropicModelHaikuOutputCostPerToken,
+	},
+}
+
+var AnthropicSupportedModels = []openai.ChatModel{
+	AnthropicModelClaude35Haiku,
+	AnthropicModelClaude35Sonnet,
+	AnthropicModelClaude37Sonnet,
+}
+
+// Models that accept images as input
+var AnthropicVisionModels = []openai.ChatModel{
+	AnthropicModelClaude35Sonnet,
+	AnthropicModelClaude37Sonnet,
+}
+
+// anthropicAPIKey returns the key set through CFOR_ANTHROPIC_API_KEY, which
+// takes precedence, or ANTHROPIC_API_KEY
+func anthropicAPIKey() string {
+	if key := os.Getenv("CFOR_ANTHROPIC_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("ANTHROPIC_API_KEY")
+}
+
+// anthropicProvider sends requests to Anthropic's Messages API. Structured
+// output is obtained by forcing the model to call a tool whose input schema is
+// the response schema.
+type anthropicProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newAnthropicProvider() (Provider, error) {
+	apiKey, err := apiKeyOrCommand(ProviderAnthropic, anthropicAPIKey())
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, &APIKeyMissingError{Provider: ProviderAnthropic}
+	}
+
+	// CFOR_ANTHROPIC_BASE_URL points at a gateway in front of the API
+	baseURL := os.Getenv("CFOR_ANTHROPIC_BASE_URL")
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	return anthropicProvider{apiKey: apiKey, baseURL: baseURL, client: newHTTPClient()}, nil
+}
+
+type anthropicRequest struct {
+	Model       string              `json:"model"`
+	MaxTokens   int64               `json:"max_tokens"`
+	System      string              `json:"system"`
+	Temperature float64             `json:"temperature"`
+	Messages    []anthropicMessage  `json:"messages"`
+	Tools       []anthropicTool     `json:"tools"`
+	ToolChoice  anthropicToolChoice `json:"tool_choice"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+// anthropicContent is a content block of a message: text, an image, or the
+// tool call carrying the structured response
+type anthropicContent struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+	Name   string                `json:"name,omitempty"`
+	Input  json.RawMessage       `json:"input,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicContent `json:"content"`
+	StopReason string             `json:"stop_reason"`
+	Usage      struct {
+		InputTokens          int64 `json:"input_tokens"`
+		OutputTokens         int64 `json:"output_tokens"`
+		CacheReadInputTokens int64 `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+}
+
+type anthropicErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p anthropicProvider) Complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error) {
+	content, err := anthropicUserContent(prompt, opts.Images)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	// The API has no seed, so deterministic options only remove the
+	// temperature
+	body, err := json.Marshal(anthropicRequest{
+		Model:       model,
+		MaxTokens:   limit,
+		System:      systemPrompt,
+		Temperature: opts.Temperature,
+		Messages:    []anthropicMessage{{Role: "user", Content: content}},
+		Tools: []anthropicTool{{
+			Name:        schema.Name.Value,
+			Description: schema.Description.Value,
+			InputSchema: schema.Schema.Value,
+		}},
+		ToolChoice: anthropicToolChoice{Type: "tool", Name: schema.Name.Value},
+	})
+	if err != nil {
+		return Completion{}, err
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", p.apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+	httpResp, data, err := postJSON(ctx, p.client, p.baseURL+"messages", header, body)
+	id := requestID(httpResp)
+	if err != nil {
+		return Completion{}, &OpenAIRequestError{Provider: "Anthropic", Err: err, RequestID: id}
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr anthropicErrorResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			err = fmt.Errorf("%s: %s: %s", httpResp.Status, apiErr.Error.Type, apiErr.Error.Message)
+		} else {
+			err = fmt.Errorf("%s", httpResp.Status)
+		}
+		return Completion{}, &OpenAIRequestError{Provider: "Anthropic", Err: err, RequestID: id, Status: httpResp.StatusCode, RetryAfter: retryAfter(httpResp.Header)}
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Completion{}, &JSONParseError{Err: err, RequestID: id}
+	}
+
+	completion := Completion{
+		Usage: openai.CompletionUsage{
+			PromptTokens:        resp.Usage.InputTokens,
+			CompletionTokens:    resp.Usage.OutputTokens,
+			TotalTokens:         resp.Usage.InputTokens + resp.Usage.CacheReadInputTokens + resp.Usage.OutputTokens,
+			PromptTokensDetails: openai.CompletionUsagePromptTokensDetails{CachedTokens: resp.Usage.CacheReadInputTokens},
+		},
+		Truncated: resp.StopReason == "max_tokens",
+		RequestID: id,
+	}
+	for _, block := range resp.Content {
+		if block.Type == "tool_use" && block.Name == schema.Name.Value {
+			completion.Content = string(block.Input)
+		}
+	}
+	return completion, nil
+}
+
+// anthropicUserContent builds the content of the user message, attaching any
+// images as base64 blocks
+func anthropicUserContent(prompt string, images []string) ([]anthropicContent, error) {
+	content := []anthropicContent{{Type: "text", Text: prompt}}
+	for _, path := range images {
+		url, err := imageDataURL(path)
+		if err != nil {
+			return nil, err
+		}
+		mediaType, data, _ := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+		content = append(content, anthropicContent{
+			Type:   "image",
+			Source: &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data},
+		})
+	}
+	return content, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const projectAPIKeysURL = "https://api.openai.com/v1/organization/projects/%s/api_keys"
+
+// credentialsFilepath is where `cfor auth rotate` stores the API key, next to
+// the config file and readable only by the user
+func credentialsFilepath() string {
+	configFilePath := configFilepath()
+	if configFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(configFilePath), "credentials")
+}
+
+// storedAPIKey returns the API key saved by `cfor auth rotate`, if any
+func storedAPIKey() string {
+	path := credentialsFilepath()
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// envAPIKey returns the API key set in the environment, which takes
+// precedence over the stored one
+func envAPIKey() string {
+	if key := os.Getenv("CFOR_OPENAI_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("OPENAI_API_KEY")
+}
+
+// StoreAPIKey replaces the stored API key atomically, so an interrupted
+// rotation never leaves a truncated key behind
+func StoreAPIKey(key string) error {
+	path := credentialsFilepath()
+	if path == "" {
+		return fmt.Errorf("could not determine credentials file path")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-")
+	if err != nil {
+		return fmt.Errorf("failed to create credentials file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.WriteString(key + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ValidateAPIKey checks the key with a free request for the configured model
+func ValidateAPIKey(key string) error {
+	model, err := openAIModel()
+	if err != nil {
+		return err
+	}
+
+	opts := []option.RequestOption{
+		option.WithAPIKey(key),
+		option.WithHTTPClient(newHTTPClient()),
+		option.WithMiddleware(loggingMiddleware),
+	}
+	if url := openAIBaseURL(); url != "" {
+		opts = append(opts, option.WithBaseURL(url))
+	}
+	opts = append(opts, openAIAccountOptions()...)
+	client := openai.NewClient(opts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout())
+	defer cancel()
+	if _, err := client.Models.Get(ctx, model); err != nil {
+		return &OpenAIRequestError{Err: err}
+	}
+	return nil
+}
+
+// RevokeAPIKey deletes the key from the OpenAI project through the admin API.
+// The API only lists keys in redacted form ("sk-abc...xyz"), which is matched
+// against the old key's start and end.
+func RevokeAPIKey(oldKey, project string) error {
+	if err := checkOnline("Revoking the old key"); err != nil {
+		return err
+	}
+	admin, err := adminKey()
+	if err != nil {
+		return err
+	}
+	if admin == "" {
+		return &AdminKeyMissingError{}
+	}
+
+	keysURL := fmt.Sprintf(projectAPIKeysURL, url.PathEscape(project))
+	var keys struct {
+		Data []struct {
+			ID            string `json:"id"`
+			RedactedValue string `json:"redacted_value"`
+		} `json:"data"`
+	}
+	if err := adminRequest(http.MethodGet, keysURL+"?limit=100", admin, &keys); err != nil {
+		return err
+	}
+
+	for _, key := range keys.Data {
+		prefix, suffix, ok := strings.Cut(key.RedactedValue, "...")
+		if ok && strings.HasPrefix(oldKey, prefix) && strings.HasSuffix(oldKey, suffix) {
+			return adminRequest(http.MethodDelete, keysURL+"/"+url.PathEscape(key.ID), admin, nil)
+		}
+	}
+	return fmt.Errorf("the old key was not found in project %s", project)
+}
+
+func adminRequest(method, url, key string, result any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return &OpenAIRequestError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &OpenAIRequestError{Err: fmt.Errorf("%s %s returned %s", method, url, resp.Status), RequestID: requestID(resp)}
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return &JSONParseError{Err: err, RequestID: requestID(resp)}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	// Questions asked at once when not using the batch API
+	batchConcurrency = 4
+	// How often the batch API is polled for completion
+	batchPollInterval = 30 * time.Second
+	// The batch API bills at half the regular price
+	batchDiscount = 0.5
+)
+
+type BatchResult struct {
+	Question string     `json:"question"`
+	Cmds     []CmdEntry `json:"cmds"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// ReadQuestions reads one question per line, skipping blank lines and lines
+// starting with #
+func ReadQuestions(r io.Reader) ([]string, error) {
+	questions := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		questions = append(questions, line)
+	}
+	return questions, scanner.Err()
+}
+
+// GenerateBatch answers the questions a few at a time, using the answer cache
+func GenerateBatch(questions []string, opts GenerateOptions) []BatchResult {
+	results := make([]BatchResult, len(questions))
+	usage := make([]ChatResult[Cmds], len(questions))
+	sem := make(chan struct{}, batchConcurrency)
+
+	var wg sync.WaitGroup
+	for i, question := range questions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, err := newGenerator(opts, false).Generate(context.Background(), question)
+			usage[i] = result
+			results[i] = BatchResult{Question: question, Cmds: SanitizeCmds(result.Message.Cmds)}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The cost file isn't safe for concurrent updates
+	for _, result := range usage {
+		RecordUsage(result)
+	}
+
+	return results
+}
+
+type batchRequest struct {
+	CustomID string                         `json:"custom_id"`
+	Method   string                         `json:"method"`
+	URL      string                         `json:"url"`
+	Body     openai.ChatCompletionNewParams `json:"body"`
+}
+
+type batchResponse struct {
+	CustomID string `json:"custom_id"`
+	Response struct {
+		StatusCode int                   `json:"status_code"`
+		RequestID  string                `json:"request_id"`
+		Body       openai.ChatCompletion `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// GenerateBatchAPI answers the questions through the provider's batch API,
+// which is cheaper but may take up to a day. Progress is reported with the
+// batch's status while waiting.
+func GenerateBatchAPI(questions []string, opts GenerateOptions, progress func(status string)) ([]BatchResult, error) {
+	prompts := make([]Prompt, len(questions))
+	for i, question := range questions {
+		prompt, err := BuildCmdsPrompt(question, opts)
+		if err != nil {
+			return nil, err
+		}
+		prompts[i] = prompt
+	}
+	return sendBatch(questions, prompts, opts, progress)
+}
+
+// sendBatch sends the prompts for the questions as a single batch and waits
+// for the answers
+func sendBatch(questions []string, prompts []Prompt, opts GenerateOptions, progress func(status string)) ([]BatchResult, error) {
+	client, err := sharedClient()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := checkPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	var input bytes.Buffer
+	for i, prompt := range prompts {
+		line, err := json.Marshal(batchRequest{
+			CustomID: fmt.Sprint(i),
+			Method:   "POST",
+			URL:      "/v1/chat/completions",
+			Body:     chatParams(prompt.Model, openai.UserMessage(policy.RedactText(prompt.User)), cmdsSchemaParam(), opts),
+		})
+		if err != nil {
+			return nil, err
+		}
+		input.Write(line)
+		input.WriteByte('\n')
+	}
+
+	ctx := context.Background()
+	file, err := client.Files.New(ctx, openai.FileNewParams{
+		File:    openai.FileParam(&input, "cfor-batch.jsonl", "application/jsonl"),
+		Purpose: openai.F(openai.FilePurposeBatch),
+	})
+	if err != nil {
+		return nil, &OpenAIRequestError{Err: err}
+	}
+
+	batch, err := client.Batches.New(ctx, openai.BatchNewParams{
+		CompletionWindow: openai.F(openai.BatchNewParamsCompletionWindow24h),
+		Endpoint:         openai.F(openai.BatchNewParamsEndpointV1ChatCompletions),
+		InputFileID:      openai.F(file.ID),
+	})
+	if err != nil {
+		return nil, &OpenAIRequestError{Err: err}
+	}
+
+	for batch.Status != openai.BatchStatusCompleted {
+		switch batch.Status {
+		case openai.BatchStatusFailed, openai.BatchStatusExpired, openai.BatchStatusCancelled:
+			return nil, &OpenAIRequestError{Err: fmt.Errorf("batch %s %s", batch.ID, batch.Status)}
+		}
+		progress(fmt.Sprintf("batch %s %s: %d of %d done", batch.ID, batch.Status, batch.RequestCounts.Completed, len(questions)))
+
+		time.Sleep(batchPollInterval)
+		if batch, err = client.Batches.Get(ctx, batch.ID); err != nil {
+			return nil, &OpenAIRequestError{Err: err}
+		}
+	}
+
+	content, err := client.Files.Content(ctx, batch.OutputFileID)
+	if err != nil {
+		return nil, &OpenAIRequestError{Err: err}
+	}
+	defer content.Body.Close()
+
+	results := make([]BatchResult, len(questions))
+	for i, question := range questions {
+		results[i] = BatchResult{Question: question, Error: "no response"}
+	}
+
+	scanner := bufio.NewScanner(content.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var response batchResponse
+		if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+			return nil, &JSONParseError{Err: err}
+		}
+
+		var i int
+		if _, err := fmt.Sscan(response.CustomID, &i); err != nil || i < 0 || i >= len(results) {
+			continue
+		}
+		results[i] = batchResult(questions[i], prompts[i].Model, response)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// batchResult parses a batch response and records its discounted cost
+func batchResult(question, model string, response batchResponse) BatchResult {
+	result := BatchResult{Question: question}
+	if response.Error != nil {
+		result.Error = response.Error.Message
+		return result
+	}
+
+	completion := response.Response.Body
+	cost := EstimateCost(model, completion.Usage) * batchDiscount
+	UpdateUsage(model, response.Response.RequestID, completion.Usage.TotalTokens, float64(cost))
+
+	if len(completion.Choices) == 0 {
+		result.Error = "empty response"
+		return result
+	}
+
+	var cmds Cmds
+	if err := json.Unmarshal([]byte(completion.Choices[0].Message.Content), &cmds); err != nil {
+		result.Error = (&JSONParseError{Err: err}).Error()
+		return result
+	}
+	result.Cmds = cmds.Cmds
+	return result
+}
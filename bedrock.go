@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/openai/openai-go"
+)
+
+// Region used when neither cfor nor the AWS configuration sets one
+const defaultBedrockRegion = "us-east-1"
+
+const (
+	BedrockModelClaude35Sonnet   openai.ChatModel = "anthropic.claude-3-5-sonnet-20240620-v1:0"
+	BedrockModelClaude35SonnetV2 openai.ChatModel = "anthropic.claude-3-5-sonnet-20241022-v2:0"
+	BedrockModelClaude35Haiku    openai.ChatModel = "anthropic.claude-3-5-haiku-20241022-v1:0"
+	BedrockModelTitanPremier     openai.ChatModel = "amazon.titan-text-premier-v1:0"
+	BedrockModelTitanExpress     openai.ChatModel = "amazon.titan-text-express-v1"
+)
+
+// https://aws.amazon.com/bedrock/pricing/, on demand in us-east-1. Claude is
+// priced as through Anthropic's API.
+const (
+	// Titan Text Premier
+	BedrockModelTitanPremierInputCostPerToken  Cost = 0.50 * 1e-6
+	BedrockModelTitanPremierOutputCostPerToken Cost = 1.50 * 1e-6
+	// Titan Text Express
+	BedrockModelTitanExpressInputCostPerToken  Cost = 0.20 * 1e-6
+	BedrockModelTitanExpressOutputCostPerToken Cost = 0.60 * 1e-6
+)
+
+var BedrockModelCosts = map[openai.ChatModel]CostPerToken{
+	BedrockModelClaude35Sonnet:   anthropicSonnetCosts,
+	BedrockModelClaude35SonnetV2: anthropicSonnetCosts,
+	BedrockModelClaude35Haiku:    AnthropicModelCosts[AnthropicModelClaude35Haiku],
+	BedrockModelTitanPremier: {
+		Input:  BedrockModelTitanPremierInputCostPerToken,
+		Output: BedrockModelTitanPremierOutputCostPerToken,
+	},
+	BedrockModelTitanExpress: {
+		Input:  BedrockModelTitanExpressInputCostPerToken,
+		Output: BedrockModelTitanExpressOutputCostPerToken,
+	},
+}
+
+var BedrockSupportedModels = []openai.ChatModel{
+	BedrockModelClaude35Haiku,
+	BedrockModelClaude35Sonnet,
+	BedrockModelClaude35SonnetV2,
+	BedrockModelTitanExpress,
+	BedrockModelTitanPremier,
+}
+
+// Models that accept images as input
+var BedrockVisionModels = []openai.ChatModel{
+	BedrockModelClaude35Sonnet,
+	BedrockModelClaude35SonnetV2,
+}
+
+// Cross-region inference profiles prefix the model ID with a geography, e.g.
+// us.anthropic.claude-3-5-sonnet-20241022-v2:0
+var inferenceProfilePattern = regexp.MustCompile(`^(us|eu|apac|us-gov)\.`)
+
+// bedrockBaseModel returns the model an inference profile routes to
+func bedrockBaseModel(model openai.ChatModel) openai.ChatModel {
+	return inferenceProfilePattern.ReplaceAllString(model, "")
+}
+
+// bedrockRegion returns the region set through CFOR_BEDROCK_REGION or
+// bedrock_region in the config file, if any. Otherwise the AWS configuration's
+// applies.
+func bedrockRegion() string {
+	if region := os.Getenv("CFOR_BEDROCK_REGION"); region != "" {
+		return region
+	}
+	config, _ := LoadConfig()
+	return config.BedrockRegion
+}
+
+// bedrockProvider sends requests to Bedrock's Converse API, with credentials
+// from the AWS SDK's chain: environment variables, the shared config and
+// credentials files (AWS_PROFILE, SSO), or the instance's role. Claude's
+// structured output is obtained by forcing a tool call, as through
+// Anthropic's API; Titan has no tools, so it's asked for JSON in the prompt.
+type bedrockProvider struct {
+	client *bedrockruntime.Client
+}
+
+// bedrockHTTPClient passes the SDK's requests through the same middlewares as
+// the other providers'. It wraps the SDK's own client, which honors
+// AWS_CA_BUNDLE.
+type bedrockHTTPClient struct {
+	client aws.HTTPClient
+}
+
+func (c bedrockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return statusMiddleware(req, func(req *http.Request) (*http.Response, error) {
+		return loggingMiddleware(req, c.client.Do)
+	})
+}
+
+func newBedrockProvider() (Provider, error) {
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithHTTPClient(newHTTPClient())}
+	if region := bedrockRegion(); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultBedrockRegion
+	}
+	client := bedrockruntime.NewFromConfig(cfg, func(o *bedrockruntime.Options) {
+		o.HTTPClient = bedrockHTTPClient{client: o.HTTPClient}
+	})
+	return bedrockProvider{client: client}, nil
+}
+
+func (p bedrockProvider) Complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error) {
+	tools := strings.HasPrefix(bedrockBaseModel(model), "anthropic.")
+
+	input := &bedrockruntime.ConverseInput{
+		ModelId: aws.String(model),
+		InferenceConfig: &types.InferenceConfiguration{
+			MaxTokens:   aws.Int32(int32(limit)),
+			Temperature: aws.Float32(float32(opts.Temperature)),
+		},
+	}
+	if tools {
+		inputSchema, err := bedrockSchema(schema.Schema.Value)
+		if err != nil {
+			return Completion{}, err
+		}
+		input.System = []types.SystemContentBlock{&types.SystemContentBlockMemberText{Value: systemPrompt}}
+		input.ToolConfig = &types.ToolConfiguration{
+			Tools: []types.Tool{&types.ToolMemberToolSpec{Value: types.ToolSpecification{
+				Name:        aws.String(schema.Name.Value),
+				Description: aws.String(schema.Description.Value),
+				InputSchema: &types.ToolInputSchemaMemberJson{Value: inputSchema},
+			}}},
+			ToolChoice: &types.ToolChoiceMemberTool{Value: types.SpecificToolChoice{Name: aws.String(schema.Name.Value)}},
+		}
+	} else {
+		// Titan takes neither a system prompt nor a schema
+		instructions, err := jsonInstructions(schema.Schema.Value)
+		if err != nil {
+			return Completion{}, err
+		}
+		prompt = systemPrompt + "\n\n" + prompt + "\n\n" + instructions
+	}
+	content, err := bedrockUserContent(prompt, opts.Images)
+	if err != nil {
+		return Completion{}, err
+	}
+	input.Messages = []types.Message{{Role: types.ConversationRoleUser, Content: content}}
+
+	resp, err := p.client.Converse(ctx, input)
+	if err != nil {
+		var id string
+		var withID interface{ ServiceRequestID() string }
+		if errors.As(err, &withID) {
+			id = withID.ServiceRequestID()
+		}
+		return Completion{}, &OpenAIRequestError{Provider: "Bedrock", Err: err, RequestID: id}
+	}
+
+	id, _ := awsmiddleware.GetRequestIDMetadata(resp.ResultMetadata)
+	completion := Completion{
+		Truncated: resp.StopReason == types.StopReasonMaxTokens,
+		RequestID: id,
+	}
+	if usage := resp.Usage; usage != nil {
+		cached := int64(aws.ToInt32(usage.CacheReadInputTokens))
+		completion.Usage = openai.CompletionUsage{
+			PromptTokens:        int64(aws.ToInt32(usage.InputTokens)),
+			CompletionTokens:    int64(aws.ToInt32(usage.OutputTokens)),
+			TotalTokens:         int64(aws.ToInt32(usage.TotalTokens)),
+			PromptTokensDetails: openai.CompletionUsagePromptTokensDetails{CachedTokens: cached},
+		}
+	}
+
+	message, ok := resp.Output.(*types.ConverseOutputMemberMessage)
+	if !ok {
+		return completion, nil
+	}
+	for _, block := range message.Value.Content {
+		switch block := block.(type) {
+		case *types.ContentBlockMemberToolUse:
+			if aws.ToString(block.Value.Name) == schema.Name.Value && block.Value.Input != nil {
+				data, err := block.Value.Input.MarshalSmithyDocument()
+				if err != nil {
+					return Completion{}, &JSONParseError{Err: err, RequestID: id}
+				}
+				completion.Content = string(data)
+			}
+		case *types.ContentBlockMemberText:
+			if !tools {
+				completion.Content += block.Value
+			}
+		}
+	}
+	if !tools {
+		completion.Content = extractJSONObject(completion.Content)
+	}
+	return completion, nil
+}
+
+// bedrockSchema converts the schema to a document, through JSON so that its
+// field names are kept
+func bedrockSchema(schema any) (document.Interface, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return document.NewLazyDocument(value), nil
+}
+
+// jsonInstructions asks for a response following the schema, for models
+// without structured output
+func jsonInstructions(schema any) (string, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	return "Respond with only a JSON object following this JSON schema, without any other text:\n" + string(data), nil
+}
+
+// extractJSONObject returns the outermost JSON object of the text, which
+// models asked for JSON in the prompt may wrap in a code fence or prose
+func extractJSONObject(text string) string {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return text
+	}
+	return text[start : end+1]
+}
+
+// bedrockUserContent builds the content of the user message, attaching any
+// images as bytes
+func bedrockUserContent(prompt string, images []string) ([]types.ContentBlock, error) {
+	content := []types.ContentBlock{&types.ContentBlockMemberText{Value: prompt}}
+	for _, path := range images {
+		url, err := imageDataURL(path)
+		if err != nil {
+			return nil, err
+		}
+		mediaType, encoded, _ := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, ImageError{Path: path, Err: err}
+		}
+		content = append(content, &types.ContentBlockMemberImage{Value: types.ImageBlock{
+			Format: types.ImageFormat(strings.TrimPrefix(mediaType, "image/")),
+			Source: &types.ImageSourceMemberBytes{Value: data},
+		}})
+	}
+	return content, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cached answers older than this are regenerated
+const answerCacheTTL = 7 * 24 * time.Hour
+
+type cachedAnswer struct {
+	Cmds      Cmds      `json:"cmds"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func answerCacheFilepath(key string) string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "answers", key+".json")
+}
+
+// EnvFingerprint identifies the environment an answer was generated for: the
+// OS, architecture, shell and the installed versions of the tools mentioned in
+// the question. Tools are identified by their resolved path, size and
+// modification time rather than by running them, which changes whenever a
+// tool is upgraded or differs between machines.
+func EnvFingerprint(question string) string {
+	parts := []string{runtime.GOOS, runtime.GOARCH, filepath.Base(userShell())}
+
+	tools := map[string]bool{}
+	for _, word := range strings.FieldsFunc(question, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) {
+		tools[word] = true
+	}
+
+	toolParts := []string{}
+	for tool := range tools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		toolParts = append(toolParts, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().Unix()))
+	}
+	sort.Strings(toolParts)
+
+	return strings.Join(append(parts, toolParts...), "|")
+}
+
+// answerCacheKey hashes everything that shapes an answer: the model, the full
+// prompt and the environment fingerprint
+func answerCacheKey(prompt Prompt, question string) string {
+	hash := sha256.New()
+	for _, part := range []string{prompt.Model, prompt.System, prompt.User, EnvFingerprint(question)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// CachedGenerateCmds answers from the local cache when the same question was
+// asked in the same environment, and caches fresh answers otherwise. Refresh
+// skips the lookup but still updates the cache.
+func CachedGenerateCmds(ctx context.Context, question string, opts GenerateOptions, refresh bool) (ChatResult[Cmds], error) {
+	prompt, err := BuildCmdsPrompt(question, opts)
+	if err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	// Answers about attached images can't be keyed by the question alone
+	if len(opts.Images) > 0 {
+		return GenerateCmds(ctx, question, opts)
+	}
+
+	cachePath := answerCacheFilepath(answerCacheKey(prompt, question))
+	if !refresh && cachePath != "" {
+		if cached, err := readAnswerCache(cachePath); err == nil {
+			return ChatResult[Cmds]{Message: cached, Model: prompt.Model, Cached: true}, nil
+		}
+	}
+
+	result, err := GenerateCmds(ctx, question, opts)
+	if err != nil {
+		return result, err
+	}
+
+	if cachePath != "" {
+		writeAnswerCache(cachePath, result.Message)
+	}
+
+	return result, nil
+}
+
+// Generator answers a question with suggestions. The commands that ask
+// questions go through it rather than a provider, so that the path from a
+// question to the suggestions shown can be exercised without the network.
+type Generator interface {
+	Generate(ctx context.Context, question string) (ChatResult[Cmds], error)
+}
+
+// cachedGenerator asks the configured provider through the answer cache
+type cachedGenerator struct {
+	opts GenerateOptions
+	// Skip the cache lookup, as for reruns
+	refresh bool
+}
+
+func (g cachedGenerator) Generate(ctx context.Context, question string) (ChatResult[Cmds], error) {
+	return CachedGenerateCmds(ctx, question, g.opts, g.refresh)
+}
+
+// newGenerator returns the generator for the options. Replaced with a stub
+// to run the commands without a provider.
+var newGenerator = func(opts GenerateOptions, refresh bool) Generator {
+	return cachedGenerator{opts: opts, refresh: refresh}
+}
+
+// IsAnswerCached reports whether CachedGenerateCmds would answer the question
+// from the cache
+func IsAnswerCached(question string, opts GenerateOptions) bool {
+	if len(opts.Images) > 0 {
+		return false
+	}
+
+	prompt, err := BuildCmdsPrompt(question, opts)
+	if err != nil {
+		return false
+	}
+
+	cachePath := answerCacheFilepath(answerCacheKey(prompt, question))
+	if cachePath == "" {
+		return false
+	}
+	_, err = readAnswerCache(cachePath)
+	return err == nil
+}
+
+func readAnswerCache(path string) (Cmds, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Cmds{}, err
+	}
+
+	var answer cachedAnswer
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return Cmds{}, err
+	}
+
+	if time.Since(answer.CreatedAt) > answerCacheTTL {
+		return Cmds{}, fmt.Errorf("cached answer expired")
+	}
+
+	return answer.Cmds, nil
+}
+
+func writeAnswerCache(path string, cmds Cmds) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cachedAnswer{Cmds: cmds, CreatedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal answer: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Clipboard backends selectable through CFOR_CLIPBOARD or clipboard in the
+// config file
+const (
+	ClipboardAuto   = "auto"
+	ClipboardWlCopy = "wl-copy"
+	ClipboardXclip  = "xclip"
+	ClipboardXsel   = "xsel"
+	ClipboardOSC52  = "osc52"
+)
+
+// The clipboard tools fork to keep serving the selection, so this only
+// bounds how long they take to read the text
+const clipboardTimeout = 2 * time.Second
+
+// Arguments copying standard input to the clipboard, rather than to the
+// primary selection xclip and xsel default to
+var clipboardArgs = map[string][]string{
+	ClipboardWlCopy: nil,
+	ClipboardXclip:  {"-selection", "clipboard", "-in"},
+	ClipboardXsel:   {"--clipboard", "--input"},
+}
+
+// clipboardBackend returns the backend selected through CFOR_CLIPBOARD or
+// clipboard in the config file, auto otherwise
+func clipboardBackend() string {
+	backend := os.Getenv("CFOR_CLIPBOARD")
+	if backend == "" {
+		config, _ := LoadConfig()
+		backend = config.Clipboard
+	}
+	if backend == "" {
+		return ClipboardAuto
+	}
+	return strings.ToLower(backend)
+}
+
+// detectClipboardTool returns the clipboard tool of the display server the
+// session runs under on Linux: wl-copy under Wayland, xclip or xsel under X11,
+// which XWayland also provides. Without a display, e.g. over SSH, none applies.
+func detectClipboardTool() (string, bool) {
+	if runtime.GOOS != "linux" {
+		return "", false
+	}
+	candidates := []string{}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append(candidates, ClipboardWlCopy)
+	}
+	if os.Getenv("DISPLAY") != "" {
+		candidates = append(candidates, ClipboardXclip, ClipboardXsel)
+	}
+	for _, tool := range candidates {
+		if _, err := exec.LookPath(tool); err == nil {
+			return tool, true
+		}
+	}
+	return "", false
+}
+
+// clipboardTool returns the tool copying to the clipboard, or false when the
+// terminal is asked to through OSC 52
+func clipboardTool() (string, bool) {
+	switch backend := clipboardBackend(); backend {
+	case ClipboardAuto:
+		return detectClipboardTool()
+	case ClipboardWlCopy, ClipboardXclip, ClipboardXsel:
+		return backend, true
+	case ClipboardOSC52:
+		return "", false
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: unknown clipboard %q (expected %s, %s, %s, %s or %s); detecting it instead.\n", backend, ClipboardAuto, ClipboardWlCopy, ClipboardXclip, ClipboardXsel, ClipboardOSC52)
+		return detectClipboardTool()
+	}
+}
+
+// copyToClipboard copies the text with the clipboard tool of the session,
+// falling back to asking the terminal to set the clipboard (OSC 52), which
+// also works over SSH. Terminals without support ignore the sequence.
+func copyToClipboard(text string) {
+	if tool, ok := clipboardTool(); ok {
+		err := runClipboardTool(tool, text)
+		if err == nil {
+			return
+		}
+		logVerbose("failed to copy with %s, falling back to OSC 52: %v", tool, err)
+	}
+	fmt.Fprintf(os.Stdout, "\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+}
+
+// runClipboardTool pipes the text to the tool. Its output isn't captured,
+// since the copy it forks to serve the selection would hold the pipe open.
+func runClipboardTool(tool, text string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clipboardTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, tool, clipboardArgs[tool]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
-	"runtime"
+	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
-	"unsafe"
 
-	"github.com/briandowns/spinner"
 	"github.com/spf13/cobra"
-	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
+// Asked when images are attached without a question
+const defaultImageQuestion = "fixing the error shown in the image"
+
 var rootCmd = &cobra.Command{
 	Use:   "cfor [question]",
 	Short: "What's the command for...? AI-powered terminal assistant for command lookups",
@@ -36,8 +41,18 @@ $ cfor "listing all files in the current directory with creation timestamps"
 $ cfor "installing a new package for a pnpm workspace"
 $ cfor "applying terraform changes to a specific resource"
 $ cfor "running tests in a go project"`,
-	Args: cobra.MaximumNArgs(1),
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeQuestion,
+	// Runs before every command, which don't define their own
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		RecordCommandMetric(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		images, _ := cmd.Flags().GetStringSlice("image")
+		if len(args) == 0 && len(images) > 0 {
+			args = []string{defaultImageQuestion}
+		}
+
 		if len(args) == 0 {
 			versionFlag, _ := cmd.Flags().GetBool("version")
 			if versionFlag {
@@ -48,110 +63,716 @@ $ cfor "running tests in a go project"`,
 			os.Exit(0)
 		}
 
-		for {
-			fmt.Print("\033[s") // Save cursor position
+		opts := generateOptionsFromFlags(cmd)
 
-			s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-			s.Suffix += " "
-			s.Color("fgGreen")
-			s.Start()
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun {
+			prompt, err := BuildCmdsPrompt(args[0], opts)
+			if err != nil {
+				handleGenerateError(err)
+			}
+			PrintDryRun(prompt)
+			return
+		}
+
+		config, err := LoadConfig()
+		if err != nil {
+			if jsonErrors {
+				PrintJSONError(err)
+			}
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		accessible, _ := cmd.Flags().GetBool("accessible")
+		accessible = accessible || config.Accessible || accessibleMode()
+		plain, _ := cmd.Flags().GetBool("plain")
+		jsonOutput := jsonErrors
+		// Builds without the TUI can only print the suggestions, unless
+		// selecting line by line
+		if !tuiAvailable && !jsonOutput && !accessible {
+			plain = true
+		}
+		interactive := !plain && !jsonOutput
+		// Screen readers get announcements instead of cursor-addressed output
+		tui := interactive && !accessible
+		execute, _ := cmd.Flags().GetBool("exec")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		if config.WeeklySummary && interactive {
+			PrintWeeklySummary()
+		}
+		requireExplanation := config.ExecRequiresExplanation && !yes
+
+		maxRisk := RiskHigh
+		if cmd.Flags().Changed("max-risk") {
+			value, _ := cmd.Flags().GetString("max-risk")
+			risk, err := ParseRisk(value)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			maxRisk = risk
+		}
+
+		order := config.Order
+		if cmd.Flags().Changed("order") || order == "" {
+			order, _ = cmd.Flags().GetString("order")
+		}
+		rank, err := ParseRanker(order)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		preferShared, _ := cmd.Flags().GetBool("prefer-snippets")
+		preferShared = preferShared || preferSnippets()
+		noCache, _ := cmd.Flags().GetBool("no-cache")
+		noVerify, _ := cmd.Flags().GetBool("no-verify")
+		test, _ := cmd.Flags().GetBool("test")
+		compare, _ := cmd.Flags().GetStringSlice("compare")
+		rerun := false
+		var pinned []CmdEntry
+		var selectorState SelectorState
+
+		// The clarifying answers only shape the request; history and snippets
+		// still see the question as asked
+		request := args[0]
+		guide, _ := cmd.Flags().GetBool("guide")
+		if guide {
+			if !interactive {
+				fmt.Println("--guide needs the interactive selector; it can't be used with --plain or --json.")
+				os.Exit(1)
+			}
+
+			tool := GuidedTool(args[0])
+			if tool == "" {
+				fmt.Printf("No clarifying questions for this question; --guide supports %s.\n", strings.Join(GuidedTools(), ", "))
+			} else {
+				questions := guideQuestions[tool]
+				ask := AskGuideQuestions
+				if accessible {
+					ask = AskGuideQuestionsAccessible
+				}
+				answers, err := ask(tool, questions)
+				if err != nil {
+					HandleQuitError(err)
+					fmt.Println("Error asking clarifying questions.")
+					os.Exit(1)
+				}
+				request = GuidedQuestion(args[0], questions, answers)
+			}
+		}
+
+		for {
+			if tui {
+				fmt.Print("\033[s") // Save cursor position
+			}
 
 			question := args[0]
-			result, err := GenerateCmds(question)
-			UpdateCost(float64(result.Cost))
-			if err != nil {
-				if errors.Is(err, &APIKeyMissingError{}) {
-					fmt.Println("\nHave you set up your OpenAI API key? Try one of these:")
-					fmt.Println("  export OPENAI_API_KEY=\"sk-...\"")
-					fmt.Println("  export CFOR_OPENAI_API_KEY=\"sk-...\"    # For a dedicated key")
-				} else if errors.Is(err, &UnsupportedModelError{}) {
-					fmt.Println("Unsupported model is specified. Supported models are:")
-					fmt.Printf("  %s\n", strings.Join(OpenAISupportedModels, ", "))
+			snippets := findSnippets(question)
+			snippetCmds := SnippetCmds(snippets)
+			historyCmds := findHistoryCmds(question)
+
+			var result ChatResult[Cmds]
+			// Rerunning always asks for fresh suggestions
+			generate := !preferShared || rerun || !hasSharedSnippet(snippets)
+			refresh := noCache || rerun
+			// The selector opens right away and fills in as the suggestions
+			// arrive
+			stream := generate && tui && len(compare) == 0 && streamingAvailable() && (refresh || !IsAnswerCached(request, opts))
+			models := []string{opts.Model}
+			if len(compare) > 0 {
+				models = comparedModels(opts, compare)
+			}
+			if generate {
+				for _, model := range models {
+					modelOpts := opts
+					modelOpts.Model = model
+					if refresh || !IsAnswerCached(request, modelOpts) {
+						previewSpend(request, modelOpts, interactive, config.ConfirmCostAbove, yes)
+					}
+				}
+			}
+			if generate && !stream {
+				s := NewStatusSpinner()
+				if tui {
+					s.Start()
+				}
+				if accessible && interactive {
+					fmt.Println("Generating suggestions.")
+				}
+
+				var err error
+				if len(compare) > 0 {
+					var answers []ComparedAnswer
+					answers, err = CompareModels(context.Background(), request, opts, models, refresh)
+					s.Stop()
+					for _, answer := range answers {
+						RecordUsage(answer.Result)
+					}
+					if err == nil {
+						if interactive {
+							PrintComparison(os.Stderr, answers)
+						}
+						result = MergeComparedAnswers(answers)
+					}
 				} else {
-					fmt.Println("Error generating commands.")
+					result, err = newGenerator(opts, refresh).Generate(context.Background(), request)
+					s.Stop()
+					RecordUsage(result)
+				}
+				if err != nil {
+					result = fallbackCmdsOrExit(request, mergeCmds(snippetCmds, historyCmds), err)
 				}
+			}
 
-				os.Exit(1)
+			paths := contextPaths(request)
+			// Streamed suggestions are shown before their flags are verified
+			// and they're ranked. They're only rebuilt once another is
+			// complete, so post-processors don't run for every chunk.
+			partialCount := -1
+			var partialCmds []CmdEntry
+			partial := func(generated []CmdEntry) []CmdEntry {
+				if len(generated) == partialCount {
+					return partialCmds
+				}
+				partialCount = len(generated)
+				cmds := mergeCmds(snippetCmds, mergeCmds(historyCmds, labelSource(generated, "["+requestedModel(opts)+"]")))
+				cmds = FlagSmuggledCmds(FixQuoting(SanitizeCmds(cmds), paths, currentShell()), GatherContext(request), request)
+				cmds = PostProcessCmds(StructureCmds(PairDownloadVerification(AdaptCmdsToShell(cmds, currentShell()))), request)
+				partialCmds = mergeCmds(pinned, FilterByRisk(FilterDenied(SanitizeCmds(cmds)), maxRisk))
+				return partialCmds
+			}
+			build := func(result ChatResult[Cmds]) ([]CmdEntry, error) {
+				generated := result.Message.Cmds
+				if result.Cached {
+					generated = labelSource(generated, cacheBadge)
+				} else if result.Model != "" {
+					generated = labelSource(generated, "["+result.Model+"]")
+				}
+				cmds := mergeCmds(snippetCmds, mergeCmds(historyCmds, generated))
+				cmds = FlagSmuggledCmds(FixQuoting(SanitizeCmds(cmds), paths, currentShell()), GatherContext(request), request)
+				cmds = PostProcessCmds(StructureCmds(PairDownloadVerification(AdaptCmdsToShell(cmds, currentShell()))), request)
+				if cmds = FilterDenied(SanitizeCmds(cmds)); len(cmds) == 0 {
+					return nil, NoSuggestionsError{Reason: "are denied by the org policy"}
+				}
+				cmds = FilterByRisk(cmds, maxRisk)
+				if !noVerify {
+					cmds = VerifyFlags(cmds)
+				}
+				cmds = mergeCmds(pinned, rank(cmds))
+				if len(cmds) == 0 {
+					return nil, NoSuggestionsError{Reason: fmt.Sprintf("exceed the maximum risk level (%s)", maxRisk)}
+				}
+				return cmds, nil
 			}
-			s.Stop()
 
-			selectedCmd, err := SelectCmd(result.Message.Cmds)
-			if err != nil {
-				if errors.Is(err, RerunError{}) {
+			historyEntry := func(result ChatResult[Cmds], cmds []CmdEntry) HistoryEntry {
+				entry := HistoryEntry{
+					Question:  question,
+					Model:     result.Model,
+					RequestID: result.RequestID,
+					Tokens:    result.Tokens,
+					Cached:    result.Cached,
+					Cmds:      cmds,
+				}
+				if result.Model != "" {
+					if prompt, err := BuildCmdsPrompt(request, opts); err == nil {
+						options := opts
+						entry.Prompt = prompt.User
+						entry.Options = &options
+					}
+				}
+				return entry
+			}
+
+			selectOpts := SelectOptions{RequireExplanation: execute && requireExplanation, Model: result.Model, State: selectorState}
+			var cmds []CmdEntry
+			var selectedCmd string
+			if stream {
+				selectOpts.Model = requestedModel(opts)
+				streamed := SelectStreamed(request, opts, refresh, selectOpts, partial, build)
+				RecordUsage(streamed.Result)
+				if streamed.GenerateErr != nil {
 					fmt.Print("\033[u") // Restore cursor to saved position
 					fmt.Print("\033[J") // Clear from cursor to end of screen
+					if noSuggestions := (NoSuggestionsError{}); errors.As(streamed.GenerateErr, &noSuggestions) {
+						handleGenerateError(streamed.GenerateErr)
+					}
+					result = fallbackCmdsOrExit(request, mergeCmds(snippetCmds, historyCmds), streamed.GenerateErr)
+					stream = false
+				} else {
+					result, cmds, selectedCmd, err = streamed.Result, streamed.Cmds, streamed.Selected, streamed.SelectErr
+				}
+			}
+			if !stream {
+				cmds, err = build(result)
+				if err != nil {
+					handleGenerateError(err)
+				}
+				entry := historyEntry(result, cmds)
+
+				// Without a selector, the simplest suggestion is run
+				if execute && !interactive {
+					if requireExplanation {
+						fmt.Println("Refusing to run a command without viewing its explanation; pass --yes to run it anyway.")
+						os.Exit(1)
+					}
+					entry.Selected = cmds[0].Cmd
+					AppendHistory(entry)
+					os.Exit(runCmd(cmdInWorkdir(cmds, cmds[0].Cmd, yes), request, accessible))
+				}
+
+				if jsonOutput {
+					AppendHistory(entry)
+					if err := PrintJSON(cmds); err != nil {
+						fmt.Println("Error printing commands.")
+						os.Exit(1)
+					}
+					break
+				}
+
+				if plain {
+					AppendHistory(entry)
+					PrintPlain(cmds)
+					break
+				}
+
+				if accessible {
+					selectedCmd, err = SelectCmdAccessible(cmds, selectOpts)
+				} else {
+					selectedCmd, err = SelectCmd(cmds, selectOpts)
+				}
+			}
+			entry := historyEntry(result, cmds)
+			if err != nil {
+				if rerunErr := (RerunError{}); errors.As(err, &rerunErr) {
+					rerun = true
+					// Kept suggestions stay on top and the model is asked
+					// for different ones
+					pinned = rerunErr.Pinned
+					selectorState = rerunErr.State
+					if rerunErr.Model != "" {
+						opts.Model = rerunErr.Model
+					}
+					opts.Pinned = nil
+					for _, entry := range pinned {
+						opts.Pinned = append(opts.Pinned, entry.Cmd)
+					}
+					if tui {
+						fmt.Print("\033[u") // Restore cursor to saved position
+						fmt.Print("\033[J") // Clear from cursor to end of screen
+					}
 					continue
 				}
 
+				AppendHistory(entry)
 				HandleQuitError(err)
 				fmt.Println("Error selecting command")
 				os.Exit(1)
 			}
 
-			err = injectToPrompt(selectedCmd)
-			if err != nil {
-				fmt.Println("Error injecting command into prompt")
-				os.Exit(1)
+			entry.Selected = selectedCmd
+			AppendHistory(entry)
+
+			if test && ModifiesFiles(selectedCmd) && !testCmd(selectedCmd) {
+				os.Exit(0)
 			}
 
+			if execute {
+				os.Exit(runCmd(cmdInWorkdir(cmds, selectedCmd, yes), request, accessible))
+			}
+
+			insertCmd(selectedCmd)
+
 			break
 		}
 	},
 }
 
-func injectToPrompt(cmd string) error {
-	var getTermios, setTermios uint
-	var tiocsti, sysIoctl uintptr
+// completeQuestion offers previously asked questions as shell completions,
+// so repeating or refining past lookups takes fewer keystrokes
+func completeQuestion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	history, err := GetHistory()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return CompleteQuestion(history, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
+
+// findSnippets returns the saved snippets matching the question. Snippets are
+// a convenience, so a broken store doesn't stop the lookup.
+func findSnippets(question string) []Snippet {
+	snippets, err := GetSnippets()
+	if err != nil {
+		return nil
+	}
+	return MatchSnippets(snippets, question)
+}
+
+// findHistoryCmds returns the commands selected before for the question. Like
+// snippets, they're a convenience, so a broken history doesn't stop the lookup.
+func findHistoryCmds(question string) []CmdEntry {
+	history, err := GetHistory()
+	if err != nil {
+		return nil
+	}
+	return HistoryCmds(history, question)
+}
+
+func hasSharedSnippet(snippets []Snippet) bool {
+	for _, snippet := range snippets {
+		if snippet.Shared {
+			return true
+		}
+	}
+	return false
+}
+
+// runCmd executes the command in place of injecting it, echoing it first the
+// way `set -x` does, and returns the exit code to exit with. The run is kept
+// in the transcript, and when it fails on a terminal, cfor offers to look into
+// its output.
+func runCmd(command, question string, accessible bool) int {
+	fmt.Fprintf(os.Stderr, "+ %s\n", command)
+	result, err := execCmd(command)
+	if err != nil {
+		fmt.Println("Error executing command")
+		return 1
+	}
+
+	if err := AppendTranscript(TranscriptEntry{
+		Time:        time.Now(),
+		Question:    question,
+		Cmd:         command,
+		ExitCode:    result.ExitCode,
+		Duration:    result.Duration,
+		Interrupted: result.Interrupted,
+		Output:      result.Output,
+	}); err != nil {
+		logVerbose("failed to update the transcript: %v", err)
+	}
+
+	if result.ExitCode != 0 || result.Duration >= longRunningCmd {
+		fmt.Fprintf(os.Stderr, "+ exited with %d after %s\n", result.ExitCode, formatElapsed(result.Duration))
+	}
+
+	if result.ExitCode != 0 && !result.Interrupted && interactiveTerminal() && confirm("Ask cfor about this output?") {
+		diagnoseCmd(command, result, accessible)
+	}
+	return result.ExitCode
+}
+
+// interactiveTerminal reports whether both stdin and stdout are a terminal
+func interactiveTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// diagnoseCmd explains why the command failed and offers the suggested fixes
+// for insertion into the prompt
+func diagnoseCmd(command string, result ExecResult, accessible bool) {
+	s := NewStatusSpinner()
+	s.Start()
+	diagnosis, err := DiagnoseFailure(command, result.ExitCode, result.Output)
+	s.Stop()
+	RecordUsage(diagnosis)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not look into the output: %v\n", err)
+		return
+	}
+
+	fmt.Printf("\n%s\n\n", diagnosis.Message.Cause)
+	cmds := SanitizeCmds(diagnosis.Message.Cmds)
+	if len(cmds) == 0 {
+		return
+	}
+
+	var selected string
+	switch {
+	case accessible:
+		selected, err = SelectCmdAccessible(cmds, SelectOptions{Model: diagnosis.Model})
+	case tuiAvailable:
+		selected, err = SelectCmd(cmds, SelectOptions{Model: diagnosis.Model})
+	default:
+		PrintPlain(cmds)
+		return
+	}
+	// Rerunning isn't offered here, so a rerun is taken as quitting
+	if err != nil {
+		return
+	}
+	insertCmd(selected)
+}
+
+// testCmd runs the command in a fixture of the current directory, shows what
+// it changed and asks whether to go ahead with the real thing
+func testCmd(command string) bool {
+	dir, err := os.Getwd()
+	if err != nil {
+		fmt.Println("Error reading the current directory")
+		os.Exit(1)
+	}
 
-	switch runtime.GOOS {
-	case "linux":
-		getTermios = 0x5401 // unix.TCGETS
-		setTermios = 0x5402 // unix.TCSETS
-		tiocsti = 0x5412    // syscall.TIOCSTI
-		sysIoctl = 16       // syscall.SYS_IOCTL
-	case "darwin":
-		getTermios = 0x40487413 // unix.TIOCGETA
-		setTermios = 0x80487414 // unix.TIOCSETA
-		tiocsti = 0x80017472    // syscall.TIOCSTI
-		sysIoctl = 54           // syscall.SYS_IOCTL
+	result, err := TestCmd(command, dir)
+	if unsafe := (&UnsafeTestError{}); errors.As(err, &unsafe) {
+		fmt.Println("Not tested: the command refers to paths outside the current directory.")
+	} else if err != nil {
+		fmt.Printf("Not tested: %v\n", err)
+	} else {
+		PrintFixtureResult(result)
 	}
 
-	// Get the current terminal settings
-	termios, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), getTermios)
+	return confirm("Continue with this command?")
+}
+
+// previewSpend shows the estimated cost of the request before it is sent and
+// asks for confirmation when it exceeds the configured threshold
+func previewSpend(question string, opts GenerateOptions, interactive bool, confirmAbove float64, yes bool) {
+	prompt, err := BuildCmdsPrompt(question, opts)
 	if err != nil {
-		return fmt.Errorf("failed to get terminal settings: %w", err)
+		// Reported when the request is made
+		return
+	}
+
+	cost, ok := EstimatePromptCost(prompt)
+	if !ok {
+		return
+	}
+	if interactive {
+		fmt.Fprintf(os.Stderr, "≈$%.4f with %s\n", cost, prompt.Model)
+	}
+
+	if confirmAbove <= 0 || float64(cost) <= confirmAbove || yes {
+		return
+	}
+	if !interactive {
+		fmt.Printf("The request is estimated at $%.4f, above the confirmation threshold of $%.4f; pass --yes to send it anyway.\n", cost, confirmAbove)
+		os.Exit(1)
+	}
+	if !confirm(fmt.Sprintf("The request is estimated at $%.4f. Send it?", cost)) {
+		os.Exit(0)
 	}
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
 
-	// Save original settings to restore later
-	originalTermios := *termios
+// fallbackCmdsOrExit answers from the embedded corpus when the API couldn't be
+// reached, or when no answer arrived within the budget of --fast and there's
+// something else to show, the corpus's answer or the local suggestions from
+// snippets and history. It prints the error's hint and exits otherwise.
+func fallbackCmdsOrExit(request string, local []CmdEntry, err error) ChatResult[Cmds] {
+	fallback, ok := FallbackCmds(request)
+	if budgetErr := (&BudgetExceededError{}); errors.As(err, &budgetErr) && (ok || len(local) > 0) {
+		fmt.Fprintf(os.Stderr, "No answer within %s; showing local suggestions instead\n", budgetErr.Budget)
+		return ChatResult[Cmds]{Message: Cmds{Cmds: fallback}}
+	}
+	if !ok || !IsConnectivityError(err) {
+		handleGenerateError(err)
+	}
+	fmt.Fprintf(os.Stderr, "Could not reach the API; showing built-in suggestions (%v)\n", err)
+	return ChatResult[Cmds]{Message: Cmds{Cmds: fallback}}
+}
 
-	// Disable echo
-	termios.Lflag &^= unix.ECHO
-	if err := unix.IoctlSetTermios(int(os.Stdin.Fd()), setTermios, termios); err != nil {
-		return fmt.Errorf("failed to disable terminal echo: %w", err)
+// handleGenerateError prints a hint for the given request error and exits
+func handleGenerateError(err error) {
+	if jsonErrors {
+		PrintJSONError(err)
 	}
 
-	// Inject the command
-	for _, char := range cmd {
-		_, _, err := syscall.Syscall(
-			sysIoctl,
-			os.Stdin.Fd(),
-			tiocsti,
-			uintptr(unsafe.Pointer(&char)),
-		)
-		if err != 0 {
-			// Restore terminal settings before returning error
-			unix.IoctlSetTermios(int(os.Stdin.Fd()), setTermios, &originalTermios)
-			return InjectError{Char: char}
+	if keyErr := (&APIKeyMissingError{}); errors.As(err, &keyErr) && serverURL() != "" {
+		fmt.Println("\nHave you set your token for the team server?")
+		fmt.Println("  export CFOR_SERVER_TOKEN=\"...\"")
+	} else if errors.As(err, &keyErr) && keyErr.Provider == ProviderAnthropic {
+		fmt.Println("\nHave you set up your Anthropic API key? Try one of these:")
+		fmt.Println("  export ANTHROPIC_API_KEY=\"sk-ant-...\"")
+		fmt.Println("  export CFOR_ANTHROPIC_API_KEY=\"sk-ant-...\"    # For a dedicated key")
+	} else if errors.As(err, &keyErr) && keyErr.Provider == ProviderGemini {
+		fmt.Println("\nHave you set up your Gemini API key? Try one of these:")
+		fmt.Println("  export GEMINI_API_KEY=\"...\"")
+		fmt.Println("  export CFOR_GEMINI_API_KEY=\"...\"    # For a dedicated key")
+	} else if errors.As(err, &keyErr) && keyErr.Provider == ProviderOpenRouter {
+		fmt.Println("\nHave you set up your OpenRouter API key? Try one of these:")
+		fmt.Println("  export OPENROUTER_API_KEY=\"sk-or-...\"")
+		fmt.Println("  export CFOR_OPENROUTER_API_KEY=\"sk-or-...\"    # For a dedicated key")
+	} else if errors.As(err, &keyErr) {
+		fmt.Println("\nHave you set up your OpenAI API key? Try one of these:")
+		fmt.Println("  export OPENAI_API_KEY=\"sk-...\"")
+		fmt.Println("  export CFOR_OPENAI_API_KEY=\"sk-...\"    # For a dedicated key")
+	} else if commandErr := (&APIKeyCommandError{}); errors.As(err, &commandErr) {
+		fmt.Printf("Could not read the %s API key: %v.\n", commandErr.Provider, commandErr.Err)
+		fmt.Printf("Check the command of its entry under api_key_commands in %s.\n", configFilepath())
+	} else if budgetErr := (&BudgetExceededError{}); errors.As(err, &budgetErr) {
+		fmt.Printf("No answer within %s, and no snippet, earlier answer or built-in suggestion for this question.\n", budgetErr.Budget)
+		fmt.Println("Try a larger budget, e.g. --fast=5s, or leave out --fast.")
+	} else if errors.Is(err, &UnsupportedModelError{}) {
+		fmt.Println("Unsupported model is specified. Supported models are:")
+		fmt.Printf("  %s\n", strings.Join(providerModels(), ", "))
+	} else if errors.As(err, &VisionUnsupportedError{}) {
+		fmt.Println("\nThe selected model does not accept images. Models with image support are:")
+		fmt.Printf("  %s\n", strings.Join(visionModels(), ", "))
+	} else if imageErr := (ImageError{}); errors.As(err, &imageErr) {
+		fmt.Printf("\nCould not attach image: %v\n", imageErr.Err)
+	} else if notApproved := (&ModelNotApprovedError{}); errors.As(err, &notApproved) {
+		fmt.Printf("The model %s is not approved by your organization. Approved models are:\n", notApproved.Model)
+		policy, _ := LoadPolicy()
+		fmt.Printf("  %s\n", strings.Join(policy.ApprovedModels, ", "))
+	} else if capErr := (&SpendCapExceededError{}); errors.As(err, &capErr) {
+		fmt.Printf("Your organization's %s spend cap of $%.2f has been reached.\n", capErr.Period, capErr.Cap)
+	} else if parseErr := (ConfigParseError{}); errors.As(err, &parseErr) {
+		fmt.Println(parseErr)
+	} else if offlineErr := (&OfflineError{}); errors.As(err, &offlineErr) {
+		fmt.Printf("%v.\n", offlineErr)
+	} else if noneErr := (NoSuggestionsError{}); errors.As(err, &noneErr) {
+		fmt.Printf("All suggestions %s.\n", noneErr.Reason)
+	} else if lengthErr := (QuestionTooLongError{}); errors.As(err, &lengthErr) {
+		fmt.Printf("The question is %d characters long; shorten it to %d or raise max_question_length.\n", lengthErr.Length, lengthErr.Max)
+	} else if truncatedErr := (&TruncatedResponseError{}); errors.As(err, &truncatedErr) {
+		fmt.Printf("The response was cut off at %d tokens. Try a narrower question.\n", truncatedErr.MaxTokens)
+		if truncatedErr.RequestID != "" {
+			fmt.Printf("Request ID: %s\n", truncatedErr.RequestID)
+		}
+	} else {
+		fmt.Println("Error generating commands.")
+		logVerbose("%v", err)
+
+		// Quote the request ID when reporting the issue to the provider
+		if requestErr := (&OpenAIRequestError{}); errors.As(err, &requestErr) && requestErr.RequestID != "" {
+			fmt.Printf("Request ID: %s\n", requestErr.RequestID)
+		} else if parseErr := (&JSONParseError{}); errors.As(err, &parseErr) && parseErr.RequestID != "" {
+			fmt.Printf("Request ID: %s\n", parseErr.RequestID)
 		}
 	}
 
-	// Restore original terminal settings
-	if err := unix.IoctlSetTermios(int(os.Stdin.Fd()), setTermios, &originalTermios); err != nil {
-		return fmt.Errorf("failed to restore terminal settings: %w", err)
+	os.Exit(1)
+}
+
+// SelectOptions tunes the command selector
+type SelectOptions struct {
+	// Only allow selecting suggestions whose explanation has been viewed
+	RequireExplanation bool
+	// The model that gave the suggestions, marked when switching models
+	Model string
+	// Suggestions as they're generated, shown as they arrive instead of
+	// those passed in
+	Updates <-chan CmdsUpdate
+	// Where the selector was left when asking again, restored over the new
+	// suggestions
+	State SelectorState
+}
+
+// SelectorState is how the selector was left when asking again: the
+// suggestion under the cursor, the panes open and the layout, and what was
+// fetched for the suggestions, which a kept one shows again without waiting
+type SelectorState struct {
+	Cursor          string
+	ShowExplanation bool
+	ShowPreview     bool
+	Split           bool
+	Width           int
+	// Explanations and package previews fetched so far, by command
+	Explanations map[string]Explanation
+	Previews     map[string]PackagePreview
+}
+
+// PackagePreview is the outcome of previewing a suggestion's package
+// transaction
+type PackagePreview struct {
+	Output string
+	Err    error
+}
+
+func generateOptionsFromFlags(cmd *cobra.Command) GenerateOptions {
+	opts := DefaultGenerateOptions()
+
+	deterministic, _ := cmd.Flags().GetBool("deterministic")
+	if deterministic {
+		opts = DeterministicGenerateOptions()
+	}
+
+	opts.Images, _ = cmd.Flags().GetStringSlice("image")
+	opts.Language, _ = cmd.Flags().GetString("lang")
+
+	// An explicit seed overrides the one set by --deterministic
+	if cmd.Flags().Changed("seed") {
+		seed, _ := cmd.Flags().GetInt64("seed")
+		opts.Seed = &seed
+	}
+
+	if cmd.Flags().Changed("fast") {
+		opts.Budget, _ = cmd.Flags().GetDuration("fast")
+		opts.Model = fastModel(opts)
 	}
 
-	return nil
+	return opts
+}
+
+var whyCmd = &cobra.Command{
+	Use:   "why [command]",
+	Short: "Explain what a command you already ran did",
+	Long: `Explain what a command you already ran did and the side effects it likely had,
+such as files overwritten or deleted, software installed or settings changed,
+and how to undo them. Useful after pasting something from the internet in a
+hurry.
+
+Without a command, the last one is read from your shell's history file. Shells
+that only write it on exit, like bash by default, won't have the last command
+yet; set up the integration with cfor init to get a why function that passes it
+along:
+
+  eval "$(cfor init bash)"   # or zsh; for fish: cfor init fish | source`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ran := ""
+		if len(args) > 0 {
+			ran = strings.TrimSpace(args[0])
+		}
+		if ran == "" {
+			var err error
+			ran, err = LastShellCommand()
+			if err != nil {
+				fmt.Printf("Could not read the last command: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		s := NewStatusSpinner()
+		s.Start()
+		result, err := ExplainRanCmd(ran)
+		s.Stop()
+		RecordUsage(result)
+		if err != nil {
+			handleGenerateError(err)
+		}
+
+		PrintWhy(ran, result.Message)
+	},
+}
+
+var initCmd = &cobra.Command{
+	Use:       "init <bash|zsh|fish>",
+	Short:     "Print the shell integration",
+	Long:      `Print the shell integration, which adds a why function that explains the previous command with cfor why.`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Run: func(cmd *cobra.Command, args []string) {
+		script, ok := ShellIntegration(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unsupported shell: %s; bash, zsh and fish are supported.\n", args[0])
+			os.Exit(1)
+		}
+		fmt.Print(script)
+	},
 }
 
 var costCmd = &cobra.Command{
@@ -160,9 +781,52 @@ var costCmd = &cobra.Command{
 	Long: `Display a detailed breakdown of API usage costs incurred by cfor commands.
 This helps you track your expenses and monitor usage patterns across different
 AI models over time. The costs are shown by date, with the total amount spent
-on each day, helping you monitor your daily API usage.`,
+on each day, helping you monitor your daily API usage.
+
+Each request is also attributed to the active profile (CFOR_PROFILE) and the
+project (git repository) it was made from, so that work for different clients
+can be billed separately with --by-profile or --by-project.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		costs, err := GetCosts()
+		byProfile, _ := cmd.Flags().GetBool("by-profile")
+		byProject, _ := cmd.Flags().GetBool("by-project")
+		if team, _ := cmd.Flags().GetBool("team"); team {
+			totals, err := GetTeamCosts()
+			if err != nil {
+				fmt.Printf("Error retrieving team costs: %v\n", err)
+				os.Exit(1)
+			}
+			PrintCostBreakdown("Member", totals)
+			return
+		}
+		if byProfile || byProject {
+			entries, err := GetCostEntries()
+			if err != nil {
+				if errors.Is(err, CostFileNotFoundError{}) {
+					fmt.Println("No costs incurred yet.")
+					os.Exit(0)
+				}
+				fmt.Println("Error retrieving costs.")
+				os.Exit(1)
+			}
+
+			if byProfile {
+				PrintCostBreakdown("Profile", GroupCosts(entries, func(e CostEntry) string { return e.Profile }))
+			} else {
+				PrintCostBreakdown("Project", GroupCosts(entries, func(e CostEntry) string { return e.Project }))
+			}
+			return
+		}
+
+		// Cost files from elsewhere are only ever reviewed
+		readonly, _ := cmd.Flags().GetBool("readonly")
+		costFilePath, _ := cmd.Flags().GetString("file")
+		if costFilePath == "" {
+			costFilePath = costFilepath()
+		} else {
+			readonly = true
+		}
+
+		costs, err := ReadCosts(costFilePath)
 		if err != nil {
 			if errors.Is(err, CostFileNotFoundError{}) {
 				fmt.Println("No costs incurred yet.")
@@ -171,8 +835,9 @@ on each day, helping you monitor your daily API usage.`,
 			fmt.Println("Error retrieving costs.")
 			os.Exit(1)
 		}
+		readonly = readonly || !costFileWritable(costFilePath)
 
-		if err = CostTableModel(costs); err != nil {
+		if err = CostTableModel(costs, readonly); err != nil {
 			HandleQuitError(err)
 			fmt.Println("Error displaying costs.")
 			os.Exit(1)
@@ -180,11 +845,1139 @@ on each day, helping you monitor your daily API usage.`,
 	},
 }
 
-var (
-	Version string
-	Commit  string
-	Date    string
-)
+var costRepairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Remove requests recorded more than once from the costs and history",
+	Long: `Remove the requests recorded more than once, e.g. after a crash between
+recording a request and exiting, from the cost log and the history, and take
+their costs off the daily totals. Requests are told apart by the provider's
+request ID, so those recorded before cfor kept it are left as they are. Lines
+left partially written by an interrupted process are removed too.
+
+New requests are never recorded twice; this cleans up those recorded before.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		repair, err := RepairUsage()
+		if err != nil {
+			fmt.Printf("Error repairing costs: %v\n", err)
+			os.Exit(1)
+		}
+		if repair == (UsageRepair{}) {
+			fmt.Println("No duplicates found.")
+			return
+		}
+		fmt.Printf("Removed %d duplicate cost records ($%.5f) and %d duplicate history entries.\n", repair.CostDuplicates, repair.DuplicateCost, repair.HistoryDuplicates)
+		if repair.PartialLines > 0 {
+			fmt.Printf("Removed %d partially written lines.\n", repair.PartialLines)
+		}
+	},
+}
+
+var manCmd = &cobra.Command{
+	Use:   "man <tool>",
+	Short: "Summarize the most useful invocations of a tool from its man page",
+	Long: `Read the local man page (or --help output) of a tool and summarize the
+most useful invocations with examples. Summaries are cached locally, so
+subsequent lookups of the same tool are instant and free.
+
+Example:
+
+$ cfor man rsync
+$ cfor man tar --refresh`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tool := args[0]
+		refresh, _ := cmd.Flags().GetBool("refresh")
+
+		for {
+			fmt.Print("\033[s") // Save cursor position
+
+			s := NewStatusSpinner()
+			s.Start()
+
+			result, err := SummarizeManPage(tool, refresh, DefaultGenerateOptions())
+			s.Stop()
+			RecordUsage(result)
+			if err != nil {
+				if errors.As(err, &ManPageNotFoundError{}) {
+					fmt.Printf("No man page or --help output found for %s.\n", tool)
+					os.Exit(1)
+				}
+				handleGenerateError(err)
+			}
+
+			result.Message.Cmds = SanitizeCmds(result.Message.Cmds)
+			if !tuiAvailable {
+				PrintPlain(result.Message.Cmds)
+				break
+			}
+
+			selectedCmd, err := SelectCmd(result.Message.Cmds, SelectOptions{})
+			if err != nil {
+				if errors.Is(err, RerunError{}) {
+					// Rerunning bypasses the cache to get a fresh summary
+					refresh = true
+					fmt.Print("\033[u") // Restore cursor to saved position
+					fmt.Print("\033[J") // Clear from cursor to end of screen
+					continue
+				}
+
+				HandleQuitError(err)
+				fmt.Println("Error selecting command")
+				os.Exit(1)
+			}
+
+			insertCmd(selectedCmd)
+
+			break
+		}
+	},
+}
+
+var whichCmd = &cobra.Command{
+	Use:   "which <task>",
+	Short: "Find which installed tool can do a task",
+	Long: `Find out which tool installed on this system can do a task. The model suggests
+candidate tools, which are checked against your PATH: installed ones are listed
+first with an example command, followed by install commands for the others.
+
+Example:
+
+$ cfor which "converting heic images to jpg"
+$ cfor which "benchmarking an http endpoint"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for {
+			fmt.Print("\033[s") // Save cursor position
+
+			s := NewStatusSpinner()
+			s.Start()
+
+			result, err := FindTools(args[0])
+			s.Stop()
+			RecordUsage(result)
+			if err != nil {
+				handleGenerateError(err)
+			}
+
+			result.Message.Cmds = FilterDenied(StructureCmds(SanitizeCmds(result.Message.Cmds)))
+			if len(result.Message.Cmds) == 0 {
+				fmt.Println("No tools found for this task.")
+				os.Exit(1)
+			}
+
+			if !tuiAvailable {
+				PrintPlain(result.Message.Cmds)
+				break
+			}
+
+			selectedCmd, err := SelectCmd(result.Message.Cmds, SelectOptions{})
+			if err != nil {
+				if errors.Is(err, RerunError{}) {
+					fmt.Print("\033[u") // Restore cursor to saved position
+					fmt.Print("\033[J") // Clear from cursor to end of screen
+					continue
+				}
+
+				HandleQuitError(err)
+				fmt.Println("Error selecting command")
+				os.Exit(1)
+			}
+
+			insertCmd(selectedCmd)
+
+			break
+		}
+	},
+}
+
+var gitCmd = &cobra.Command{
+	Use:   "git [question]",
+	Short: "Suggest the next git commands for the repository's current state",
+	Long: `Suggest the exact next git commands for the repository you're in. The current
+branch or detached HEAD, any rebase, merge or cherry-pick in progress, conflicted
+files and the short status are sent along with the question, which generic git
+questions usually lack.
+
+Example:
+
+$ cfor git
+$ cfor git "abort this and keep my changes"`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		question := defaultGitQuestion
+		if len(args) > 0 {
+			question = args[0]
+		}
+
+		state, err := ReadGitState()
+		if err != nil {
+			fmt.Println("Not inside a git repository.")
+			os.Exit(1)
+		}
+		fmt.Println(state.Describe())
+
+		for {
+			fmt.Print("\033[s") // Save cursor position
+
+			s := NewStatusSpinner()
+			s.Start()
+
+			result, err := SuggestGitCmds(state, question)
+			s.Stop()
+			RecordUsage(result)
+			if err != nil {
+				handleGenerateError(err)
+			}
+
+			cmds := AdaptCmdsToShell(FixQuoting(SanitizeCmds(result.Message.Cmds), contextPaths(question), currentShell()), currentShell())
+			cmds = VerifyFlags(FilterDenied(SanitizeCmds(PostProcessCmds(StructureCmds(cmds), question))))
+			if len(cmds) == 0 {
+				fmt.Println("No suggestions for this repository.")
+				os.Exit(1)
+			}
+
+			if !tuiAvailable {
+				PrintPlain(cmds)
+				break
+			}
+
+			selectedCmd, err := SelectCmd(cmds, SelectOptions{})
+			if err != nil {
+				if errors.Is(err, RerunError{}) {
+					fmt.Print("\033[u") // Restore cursor to saved position
+					fmt.Print("\033[J") // Clear from cursor to end of screen
+					continue
+				}
+
+				HandleQuitError(err)
+				fmt.Println("Error selecting command")
+				os.Exit(1)
+			}
+
+			insertCmd(selectedCmd)
+
+			break
+		}
+	},
+}
+
+var translateCmd = &cobra.Command{
+	Use:   "translate <command>",
+	Short: "Translate a command into the equivalent for another tool",
+	Long: `Translate an existing command into the equivalent for a different tool, for
+example sed to awk, docker to podman, yum to apt or curl to wget. The target
+tool must be installed.
+
+Example:
+
+$ cfor translate "sed -n '5,10p' log.txt" --to awk
+$ cfor translate "curl -fsSLO https://example.com/file.tar.gz" --to wget`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tool, _ := cmd.Flags().GetString("to")
+
+		for {
+			fmt.Print("\033[s") // Save cursor position
+
+			s := NewStatusSpinner()
+			s.Start()
+
+			result, err := TranslateCmd(args[0], tool)
+			s.Stop()
+			RecordUsage(result)
+			if notInstalled := (&ToolNotInstalledError{}); errors.As(err, &notInstalled) {
+				fmt.Printf("%s is not installed; install it first or pick an installed tool with --to.\n", notInstalled.Tool)
+				os.Exit(1)
+			}
+			if err != nil {
+				handleGenerateError(err)
+			}
+
+			cmds := AdaptCmdsToShell(FixQuoting(SanitizeCmds(result.Message.Cmds), contextPaths(args[0]), currentShell()), currentShell())
+			cmds = VerifyFlags(FilterDenied(SanitizeCmds(PostProcessCmds(StructureCmds(cmds), args[0]))))
+			if len(cmds) == 0 {
+				fmt.Printf("No %s equivalent found for this command.\n", tool)
+				os.Exit(1)
+			}
+
+			if !tuiAvailable {
+				PrintPlain(cmds)
+				break
+			}
+
+			selectedCmd, err := SelectCmd(cmds, SelectOptions{})
+			if err != nil {
+				if errors.Is(err, RerunError{}) {
+					fmt.Print("\033[u") // Restore cursor to saved position
+					fmt.Print("\033[J") // Clear from cursor to end of screen
+					continue
+				}
+
+				HandleQuitError(err)
+				fmt.Println("Error selecting command")
+				os.Exit(1)
+			}
+
+			insertCmd(selectedCmd)
+
+			break
+		}
+	},
+}
+
+var diffToolsCmd = &cobra.Command{
+	Use:   "diff-tools <script|command>",
+	Short: "Rewrite a script or command for the modern tools you have installed",
+	Long: `Rewrite a script, or a single command, to use the modern tools installed on
+your system instead of legacy ones, e.g. grep to ripgrep, find to fd or top to
+btop, and list the behavioral differences of each replacement. Only the
+replacements found on your PATH are used, and any tool the rewrite needs that
+isn't installed is reported.
+
+Example:
+
+$ cfor diff-tools deploy.sh
+$ cfor diff-tools "find . -name '*.go' | xargs grep -n TODO"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		script := args[0]
+		if data, err := os.ReadFile(args[0]); err == nil {
+			script = string(data)
+		}
+
+		s := NewStatusSpinner()
+		s.Start()
+		result, replacements, err := MigrateTools(script)
+		s.Stop()
+		RecordUsage(result)
+		if err != nil && len(replacements) == 0 {
+			fmt.Printf("Nothing to rewrite: %v.\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			handleGenerateError(err)
+		}
+
+		PrintToolMigration(result.Message, MissingTools(result.Message.Script))
+	},
+}
+
+var batchCmd = &cobra.Command{
+	Use:   "batch [file]",
+	Short: "Answer a list of questions at once",
+	Long: `Answer a list of questions, one per line, read from a file or from stdin, and
+write all the suggestions as markdown or JSON. Useful for generating internal
+cheatsheets or runbook sections at once. Blank lines and lines starting with #
+are skipped.
+
+With --batch-api, the questions are sent through OpenAI's batch API at half the
+price. Batches may take up to 24 hours; cfor waits and reports progress.
+
+Example:
+
+$ cfor batch questions.txt --output cheatsheet.md
+$ cat questions.txt | cfor batch --format json --batch-api`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		if format != "markdown" && format != "json" {
+			fmt.Println("--format must be markdown or json.")
+			os.Exit(1)
+		}
+
+		input := os.Stdin
+		if len(args) > 0 && args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fmt.Printf("Error opening %s.\n", args[0])
+				os.Exit(1)
+			}
+			defer f.Close()
+			input = f
+		}
+
+		questions, err := ReadQuestions(input)
+		if err != nil {
+			fmt.Println("Error reading questions.")
+			os.Exit(1)
+		}
+		if len(questions) == 0 {
+			fmt.Println("No questions to answer.")
+			os.Exit(1)
+		}
+
+		opts := generateOptionsFromFlags(cmd)
+		var results []BatchResult
+		if useBatchAPI, _ := cmd.Flags().GetBool("batch-api"); useBatchAPI {
+			results, err = GenerateBatchAPI(questions, opts, func(status string) {
+				fmt.Fprintln(os.Stderr, status)
+			})
+			if err != nil {
+				handleGenerateError(err)
+			}
+		} else {
+			results = GenerateBatch(questions, opts)
+		}
+
+		for i := range results {
+			results[i].Cmds = FilterDenied(StructureCmds(AdaptCmdsToShell(results[i].Cmds, currentShell())))
+		}
+
+		output := os.Stdout
+		if path, _ := cmd.Flags().GetString("output"); path != "" {
+			f, err := os.Create(path)
+			if err != nil {
+				fmt.Printf("Error creating %s.\n", path)
+				os.Exit(1)
+			}
+			defer f.Close()
+			output = f
+		}
+
+		if format == "json" {
+			if err := WriteBatchJSON(output, results); err != nil {
+				fmt.Println("Error writing results.")
+				os.Exit(1)
+			}
+			return
+		}
+		WriteBatchMarkdown(output, results)
+	},
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that cfor is set up correctly",
+	Long: `Check the local setup (API key, config, org policy, data and cache directories,
+man) and probe every configured model concurrently for authentication, latency
+and availability, with per-model results and timings.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		checks := RunDoctor()
+		PrintDoctorChecks(checks)
+
+		for _, check := range checks {
+			if !check.OK {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+var rpcCmd = &cobra.Command{
+	Use:   "rpc",
+	Short: "Serve editor plugins over JSON-RPC on stdio",
+	Long: `Serve editor plugins (VS Code, Neovim, ...) over JSON-RPC 2.0 on stdin and
+stdout, one message per line. The interface is deliberately minimal and stable:
+
+  getSuggestions {"question": "...", "lang": "...", "deterministic": false}
+  explain        {"cmd": "..."}
+  searchHistory  {"query": "...", "limit": 20}`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ServeRPC(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error serving RPC:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage the API key",
+}
+
+var authRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the API key with a new one",
+	Long: `Replace the API key with a new one. The new key is read from the terminal (or
+stdin), validated with a test call, then stored atomically in the credentials
+file next to the config file, readable only by you, or in the keychain when the
+current key was stored there with cfor auth set.
+
+With --revoke-old and --project, the old key is then revoked through OpenAI's
+admin API, which needs an admin key (CFOR_OPENAI_ADMIN_KEY or OPENAI_ADMIN_KEY).`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		revokeOld, _ := cmd.Flags().GetBool("revoke-old")
+		project, _ := cmd.Flags().GetString("project")
+		if revokeOld && project == "" {
+			fmt.Println("--revoke-old needs the --project the old key belongs to.")
+			os.Exit(1)
+		}
+
+		oldKey, err := openAIAPIKey()
+		if err != nil {
+			logVerbose("%v", err)
+		}
+
+		newKey, err := readSecret("New API key: ")
+		if err != nil || newKey == "" {
+			fmt.Println("No key entered.")
+			os.Exit(1)
+		}
+
+		fmt.Println("Validating the new key...")
+		if err := ValidateAPIKey(newKey); err != nil {
+			fmt.Println("The new key was rejected; keeping the current one.")
+			logVerbose("%v", err)
+			os.Exit(1)
+		}
+
+		// A key stored with `cfor auth set` is replaced where it is, since it
+		// takes precedence over the credentials file
+		if keychainAPIKey(ProviderOpenAI) != "" {
+			if err := StoreKeychainAPIKey(ProviderOpenAI, newKey); err != nil {
+				fmt.Println("Error storing the new key:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Stored the new key in %s.\n", keychainName())
+		} else {
+			if err := StoreAPIKey(newKey); err != nil {
+				fmt.Println("Error storing the new key:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Stored the new key in %s.\n", credentialsFilepath())
+		}
+		noteOverridingAPIKey()
+
+		if !revokeOld {
+			return
+		}
+		if oldKey == "" || oldKey == newKey {
+			fmt.Println("There is no old key to revoke.")
+			return
+		}
+		if err := RevokeAPIKey(oldKey, project); err != nil {
+			fmt.Println("Could not revoke the old key:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Revoked the old key.")
+	},
+}
+
+var authSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Store the API key in the system keychain",
+	Long: `Store the OpenAI API key in the macOS Keychain, or with libsecret (e.g. GNOME
+Keyring or KWallet) on Linux, instead of an environment variable. The key is
+read from the terminal (or stdin) and validated with a test call first.
+
+The key is looked up in this order, the first one set winning:
+
+  1. CFOR_OPENAI_API_KEY, then OPENAI_API_KEY
+  2. the key printed by the openai entry of api_key_commands in the config file
+  3. the key stored in the keychain by cfor auth set
+  4. the key stored in the credentials file by cfor auth rotate`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, ok := keychainTool(); !ok {
+			fmt.Println("No keychain found: cfor auth set needs the macOS Keychain, or libsecret's secret-tool on Linux.")
+			fmt.Println("Use cfor auth rotate to store the key in the credentials file instead.")
+			os.Exit(1)
+		}
+
+		key, err := readSecret("API key: ")
+		if err != nil || key == "" {
+			fmt.Println("No key entered.")
+			os.Exit(1)
+		}
+
+		fmt.Println("Validating the key...")
+		if err := ValidateAPIKey(key); err != nil {
+			fmt.Println("The key was rejected; nothing was stored.")
+			logVerbose("%v", err)
+			os.Exit(1)
+		}
+
+		if err := StoreKeychainAPIKey(ProviderOpenAI, key); err != nil {
+			fmt.Println("Error storing the key:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Stored the key in %s.\n", keychainName())
+		noteOverridingAPIKey()
+	},
+}
+
+// noteOverridingAPIKey points out a key set elsewhere that takes precedence
+// over the one just stored
+func noteOverridingAPIKey() {
+	if envAPIKey() != "" {
+		fmt.Println("Note: CFOR_OPENAI_API_KEY or OPENAI_API_KEY is set and takes precedence; unset it to use the new key.")
+	} else if apiKeyCommand(ProviderOpenAI) != "" {
+		fmt.Println("Note: the openai entry of api_key_commands in the config file takes precedence; remove it to use the new key, or update the key in your secret manager instead.")
+	}
+}
+
+// readSecret reads a line without echoing it when stdin is a terminal
+func readSecret(prompt string) (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Print(prompt)
+		secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		return strings.TrimSpace(string(secret)), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move existing data into the XDG directories",
+	Long: `Move cfor's files into the XDG base directories: config in XDG_CONFIG_HOME,
+costs, history and snippets in XDG_DATA_HOME, caches in XDG_CACHE_HOME and logs
+and other state in XDG_STATE_HOME.
+
+This picks up data left in ~/.local/share/cfor after setting XDG_DATA_HOME, and
+state that older versions kept with the data. Cost files are merged day by day;
+history and cost logs are appended.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		migrations := PlanMigrations()
+		if len(migrations) == 0 {
+			fmt.Println("Nothing to migrate.")
+			return
+		}
+
+		failed := false
+		for _, migration := range migrations {
+			fmt.Printf("%s -> %s\n", migration.From, migration.To)
+			if dryRun {
+				continue
+			}
+			if err := migration.Migrate(); err != nil {
+				fmt.Printf("  failed: %v\n", err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var limitsCmd = &cobra.Command{
+	Use:   "limits",
+	Short: "Display the remaining rate limits for the API key",
+	Long: `Display the remaining requests and tokens per minute for the API key and model,
+and when they reset. The limits apply to the whole key, so this is useful when a
+key is shared across a team. A one-token request is sent to read them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		result, err := GetRateLimits()
+		RecordUsage(result)
+		if err != nil {
+			handleGenerateError(err)
+		}
+
+		PrintRateLimits(result.Model, result.Message)
+	},
+}
+
+var costVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Compare local cost estimates with the costs billed by OpenAI",
+	Long: `Compare the locally estimated costs with the costs billed by OpenAI, as reported
+by its costs API, and show the drift per day and in total.
+
+The costs API needs an admin key (CFOR_OPENAI_ADMIN_KEY or OPENAI_ADMIN_KEY).
+Billed costs cover the whole organization unless narrowed down to the project
+cfor's API key belongs to with --project. Days are in UTC, as billed, so
+requests made around midnight may fall on an adjacent day locally.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		project, _ := cmd.Flags().GetString("project")
+		if days < 1 {
+			fmt.Println("--days must be at least 1.")
+			os.Exit(1)
+		}
+
+		now := time.Now().UTC()
+		since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+
+		local, err := GetCosts()
+		if err != nil && !errors.Is(err, CostFileNotFoundError{}) {
+			fmt.Println("Error retrieving costs.")
+			os.Exit(1)
+		}
+
+		billed, err := GetProviderCosts(since, project)
+		if errors.Is(err, &AdminKeyMissingError{}) {
+			fmt.Println("The costs API needs an admin key. Create one in the OpenAI dashboard, then:")
+			fmt.Println("  export OPENAI_ADMIN_KEY=\"sk-admin-...\"")
+			os.Exit(1)
+		}
+		if offlineErr := (&OfflineError{}); errors.As(err, &offlineErr) {
+			fmt.Printf("%v.\n", offlineErr)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println("Error retrieving billed costs.")
+			logVerbose("%v", err)
+			if requestErr := (&OpenAIRequestError{}); errors.As(err, &requestErr) && requestErr.RequestID != "" {
+				fmt.Printf("Request ID: %s\n", requestErr.RequestID)
+			}
+			os.Exit(1)
+		}
+
+		PrintCostDrift(local, billed, since)
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List the questions asked through cfor",
+	Long: `List the questions asked through cfor along with the command selected for
+each, oldest first. The history is stored locally and never leaves your machine.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := GetHistory()
+		if err != nil {
+			fmt.Println("Error retrieving history.")
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No questions asked yet.")
+			return
+		}
+		PrintHistory(entries)
+	},
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <history-id>",
+	Short: "Re-send a past request and compare the suggestions",
+	Long: `Re-send the request recorded in the history with the same model, prompt and
+options, and show how the new suggestions differ from the ones shown then:
+lines starting with - are gone, lines starting with + are new.
+
+Useful for checking a model update or how much the suggestions vary between
+runs. Find the IDs with cfor history.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		history, err := GetHistory()
+		if err != nil {
+			fmt.Println("Error retrieving history.")
+			os.Exit(1)
+		}
+		entry, ok := FindHistoryEntry(history, args[0])
+		if !ok {
+			fmt.Printf("No history entry with ID %s.\n", args[0])
+			os.Exit(1)
+		}
+		if entry.Prompt == "" {
+			fmt.Println("The prompt wasn't recorded for this entry; rebuilding it from the question.")
+		}
+
+		s := NewStatusSpinner()
+		s.Start()
+		result, err := ReplayCmds(entry)
+		s.Stop()
+		RecordUsage(result)
+		if err != nil {
+			handleGenerateError(err)
+		}
+
+		fmt.Printf("%s  %s  (%s)\n\n", entry.ID, entry.Question, entry.Model)
+		PrintCmdsDiff(DiffCmds(entry.Cmds, result.Message.Cmds))
+	},
+}
+
+var evalCmd = &cobra.Command{
+	Use:   "eval <suite.yaml>",
+	Short: "Compare two prompts or models on a set of questions",
+	Long: `Ask every question in a YAML suite of two variants, each a model and
+optionally different guidelines for the prompt, and score the suggestions:
+whether shellcheck finds errors in them and whether they use one of the
+binaries expected for the question.
+
+  variants:
+    - name: current
+    - name: candidate
+      model: gpt-4o-mini
+      guidelines: |
+        Follow the below guidelines.
+        ...
+  questions:
+    - question: finding large files
+      expect: [find, du]
+
+Requests use temperature 0 and a fixed seed, and their cost is tracked as usual.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		suite, err := ReadEvalSuite(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		_, lookErr := exec.LookPath("shellcheck")
+		shellcheck := lookErr == nil
+		if !shellcheck {
+			fmt.Println("shellcheck is not installed; scoring expected binaries only.")
+		}
+
+		s := NewStatusSpinner()
+		s.Start()
+		scores, err := RunEval(suite, shellcheck)
+		s.Stop()
+		if err != nil {
+			handleGenerateError(err)
+		}
+
+		PrintEvalReport(suite, scores, shellcheck)
+	},
+}
+
+var warmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "Cache answers to common questions ahead of time",
+	Long: `Answer the questions listed under warm in the config file, and optionally the
+most asked questions from the history, through OpenAI's batch API with a cheaper
+model, and cache the answers. Lookups of these questions are then served from
+the cache for a week.
+
+Batches may take up to 24 hours, so run it overnight, e.g. from cron:
+
+  0 2 * * * cfor warm`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := LoadConfig()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		questions, err := WarmQuestions(config.Warm)
+		if err != nil {
+			fmt.Println("Error retrieving history.")
+			os.Exit(1)
+		}
+		if len(questions) == 0 {
+			fmt.Println("No questions to warm; list them under warm in the config file.")
+			os.Exit(1)
+		}
+
+		model := config.Warm.Model
+		if cmd.Flags().Changed("model") || model == "" {
+			model, _ = cmd.Flags().GetString("model")
+		}
+
+		results, err := WarmCache(questions, model, func(status string) {
+			fmt.Fprintln(os.Stderr, status)
+		})
+		if err != nil {
+			handleGenerateError(err)
+		}
+
+		cached := 0
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("%s: %s\n", result.Question, result.Error)
+				continue
+			}
+			cached++
+		}
+		fmt.Printf("Cached answers to %d of %d questions.\n", cached, len(results))
+	},
+}
+
+var historyStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Display usage analytics from the local history",
+	Long: `Display usage analytics computed from the local history: the most-asked topics,
+the busiest hours, the average tokens per question, the cache hit rate and the
+acceptance rate (how often a suggestion was actually selected).`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := GetHistory()
+		if err != nil {
+			fmt.Println("Error retrieving history.")
+			os.Exit(1)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No questions asked yet.")
+			return
+		}
+
+		if err := StatsDashboardModel(ComputeHistoryStats(entries)); err != nil {
+			fmt.Println("Error displaying stats.")
+			os.Exit(1)
+		}
+	},
+}
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Display how cfor performs, from locally recorded metrics",
+	Long: `Display the metrics cfor records locally: how often each command was run, the
+latency of requests to the provider and the cache hit rate. Questions and
+commands are never recorded.
+
+The metrics stay on this machine unless categories of them are listed under
+telemetry.share in the config file, along with telemetry.endpoint. The
+aggregates of those categories are then posted at most once a day; pass
+--report to print the next report exactly as it will be sent.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if report, _ := cmd.Flags().GetBool("report"); report {
+			pending, ok := PendingMetricsReport(time.Now())
+			if !ok {
+				fmt.Println("Telemetry is off; nothing is shared.")
+				return
+			}
+			if err := PrintMetricsReport(pending); err != nil {
+				fmt.Println("Error printing the report.")
+				os.Exit(1)
+			}
+			return
+		}
+
+		events, err := GetMetrics()
+		if err != nil {
+			fmt.Println("Error retrieving metrics.")
+			os.Exit(1)
+		}
+		days, _ := cmd.Flags().GetInt("days")
+		now := time.Now()
+		since := now.AddDate(0, 0, -days)
+		PrintMetrics(SummarizeMetrics(events, since, now), days)
+	},
+}
+
+var snippetsCmd = &cobra.Command{
+	Use:   "snippets",
+	Short: "List saved command snippets",
+	Long: `List the command snippets saved locally and in the team-shared directory.
+
+Snippets matching a question are shown alongside the suggestions from the
+API, with shared ones marked by a [team] badge. Point CFOR_SNIPPETS_DIR at a
+directory of JSON snippet files, typically a git repository curated by your
+team, and set CFOR_PREFER_SNIPPETS=1 (or pass --prefer-snippets) to use
+matching shared snippets instead of asking the API.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		snippets, err := GetSnippets()
+		if err != nil {
+			fmt.Printf("Error reading snippets: %v\n", err)
+			os.Exit(1)
+		}
+		if len(snippets) == 0 {
+			fmt.Println("No snippets saved yet.")
+			return
+		}
+		PrintPlain(SnippetCmds(snippets))
+	},
+}
+
+var snippetsAddCmd = &cobra.Command{
+	Use:   "add <command>",
+	Short: "Save a command snippet",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		question, _ := cmd.Flags().GetString("question")
+		comment, _ := cmd.Flags().GetString("comment")
+		tags, _ := cmd.Flags().GetStringSlice("tag")
+		shared, _ := cmd.Flags().GetBool("shared")
+
+		snippet := Snippet{Question: question, Cmd: args[0], Comment: comment, Tags: tags}
+		if err := AddSnippet(snippet, shared); err != nil {
+			fmt.Printf("Error saving snippet: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var snippetsSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Pull the latest team-shared snippets",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := SyncSharedSnippets(); err != nil {
+			fmt.Printf("Error syncing snippets: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a team server holding the API key for everyone, or a local web interface",
+	Long: `Run a server that makes the LLM requests of your team's members with its own
+API key, so that developers never handle provider keys. The org policy on the
+server's machine is enforced for every member: only approved models are
+accepted, and the spend caps apply to each member's spend tracked by the
+server.
+
+Members are listed in a YAML file mapping each name to a token you generate:
+
+  alice: 6f1c0e...
+  bob: 9a44d2...
+
+Members then point cfor at the server:
+
+  export CFOR_SERVER=https://cfor.example.com
+  export CFOR_SERVER_TOKEN=6f1c0e...
+
+Put the server behind a TLS-terminating proxy when it isn't only listening
+locally.
+
+With --web, serve a web interface for yourself instead, on localhost only:
+browse and search your history, chart your costs, manage your favorite
+commands (the local snippets) and ask questions.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr, _ := cmd.Flags().GetString("addr")
+		membersPath, _ := cmd.Flags().GetString("members")
+
+		if web, _ := cmd.Flags().GetBool("web"); web {
+			if membersPath != "" {
+				fmt.Println("Error: --web serves only you and can't be combined with --members.")
+				os.Exit(1)
+			}
+			if !cmd.Flags().Changed("addr") {
+				addr = defaultWebAddr
+			}
+			if err := checkLoopbackAddr(addr); err != nil {
+				fmt.Printf("Error starting the web interface: %v\n", err)
+				os.Exit(1)
+			}
+
+			httpServer := &http.Server{
+				Addr:              addr,
+				Handler:           WebHandler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			fmt.Printf("Serving the web interface on http://%s\n", addr)
+			if err := httpServer.ListenAndServe(); err != nil {
+				fmt.Printf("Error serving: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+		if membersPath == "" {
+			fmt.Println("Error: --members is required to run a team server.")
+			os.Exit(1)
+		}
+
+		server, err := NewTeamServer(membersPath)
+		if err != nil {
+			fmt.Printf("Error starting the server: %v\n", err)
+			os.Exit(1)
+		}
+
+		httpServer := &http.Server{
+			Addr:              addr,
+			Handler:           server.Handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		fmt.Printf("Serving %d members on %s\n", len(server.members), addr)
+		if err := httpServer.ListenAndServe(); err != nil {
+			fmt.Printf("Error serving: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var ringCmd = &cobra.Command{
+	Use:   "ring [n]",
+	Short: "Insert one of the recently inserted commands again",
+	Long: `Insert one of the last commands inserted into your prompt again, for going
+back and forth between a few commands during a task. Unlike the history, the
+ring only holds the commands themselves, most recent first; set ring_size in
+the config file to keep more than 10.
+
+Example:
+
+$ cfor ring      # Pick from the ring
+$ cfor ring 2    # Insert the second most recent command right away`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ring, err := ReadRing()
+		if err != nil {
+			fmt.Printf("Error reading the ring: %v\n", err)
+			os.Exit(1)
+		}
+		if len(ring) == 0 {
+			fmt.Println("No commands inserted yet.")
+			return
+		}
+
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 || n > len(ring) {
+				fmt.Printf("Pick a command between 1 and %d.\n", len(ring))
+				os.Exit(1)
+			}
+			insertCmd(ring[n-1])
+			return
+		}
+
+		if !tuiAvailable {
+			for i, c := range ring {
+				fmt.Printf("%d. %s\n", i+1, c)
+			}
+			return
+		}
+
+		selectedCmd, err := SelectCmd(RingCmds(ring), SelectOptions{})
+		if err != nil {
+			HandleQuitError(err)
+			// Rerunning has nothing to ask for here
+			if errors.Is(err, RerunError{}) {
+				return
+			}
+			fmt.Println("Error selecting command")
+			os.Exit(1)
+		}
+		insertCmd(selectedCmd)
+	},
+}
+
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+var modelsCmd = &cobra.Command{
+	Use:   "models",
+	Short: "List the supported models with their pricing",
+	Long: `List the models cfor can ask, the provider serving each, and its price per
+million input, cached input and output tokens, as used for the cost estimates.
+Prices set under pricing in the config file or in the bundle take precedence
+over the built-in ones.
+
+The models of OpenAI, Anthropic, Gemini and Bedrock are always listed. Those of
+OpenRouter and Ollama are looked up on the server, so they're only listed for
+the configured provider or with --provider, and those of an OpenAI-compatible
+server when one is configured. Select one with CFOR_OPENAI_MODEL, along with
+its provider (CFOR_PROVIDER).`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		provider, _ := cmd.Flags().GetString("provider")
+		provider = strings.ToLower(provider)
+		if provider != "" && !slices.Contains(modelListingProviders, provider) {
+			fmt.Printf("Unknown provider %q (expected %s).\n", provider, strings.Join(modelListingProviders, ", "))
+			os.Exit(1)
+		}
+
+		listings := ListModels(provider)
+		if len(listings) == 0 {
+			fmt.Println("No models found.")
+			return
+		}
+		PrintModels(listings)
+	},
+}
 
 var versionCmd = &cobra.Command{
 	Use:   "version",
@@ -202,7 +1995,91 @@ running the latest release.`,
 func init() {
 	rootCmd.AddCommand(costCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(manCmd)
+	rootCmd.AddCommand(snippetsCmd)
+	rootCmd.AddCommand(whichCmd)
+	rootCmd.AddCommand(translateCmd)
+	rootCmd.AddCommand(limitsCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authRotateCmd)
+	authCmd.AddCommand(authSetCmd)
+	rootCmd.AddCommand(rpcCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(batchCmd)
+	costCmd.AddCommand(costVerifyCmd)
+	costCmd.AddCommand(costRepairCmd)
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyStatsCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(evalCmd)
+	rootCmd.AddCommand(warmCmd)
+	rootCmd.AddCommand(whyCmd)
+	rootCmd.AddCommand(gitCmd)
+	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(ringCmd)
+	rootCmd.AddCommand(diffToolsCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(metricsCmd)
+	rootCmd.AddCommand(modelsCmd)
+	modelsCmd.Flags().String("provider", "", "Only list the models of this provider, e.g. anthropic or openrouter")
+	metricsCmd.Flags().Int("days", 30, "Number of days to summarize, up to today")
+	metricsCmd.Flags().Bool("report", false, "Print the next report shared with telemetry.endpoint, as JSON")
+	serveCmd.Flags().String("addr", defaultServerAddr, "Address to listen on")
+	serveCmd.Flags().String("members", "", "YAML file mapping each member's name to their token")
+	serveCmd.Flags().Bool("web", false, "Serve a web interface for your history, costs and favorites on "+defaultWebAddr)
+	warmCmd.Flags().String("model", defaultWarmModel, "Model answering the questions, overriding warm.model in the config file")
+	snippetsCmd.AddCommand(snippetsAddCmd)
+	snippetsCmd.AddCommand(snippetsSyncCmd)
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "Log request details, including the provider's request IDs, to stderr")
+	rootCmd.PersistentFlags().DurationVar(&timeoutFlag, "timeout", 0, "How long a request may take, e.g. 30s or 2m (10s by default, longer for local and reasoning models)")
 	rootCmd.Flags().BoolP("version", "v", false, "Display cfor version information")
+	rootCmd.Flags().Int64("seed", 0, "Seed for sampling, so repeated runs of the same question return the same suggestions")
+	rootCmd.Flags().Bool("deterministic", false, "Use temperature 0 and a fixed seed for stable suggestions")
+	rootCmd.Flags().Bool("plain", false, "Print the suggestions as plain text instead of prompting for a selection")
+	rootCmd.Flags().BoolVar(&jsonErrors, "json", false, "Print the suggestions as JSON instead of prompting for a selection, and errors as JSON on stderr")
+	rootCmd.MarkFlagsMutuallyExclusive("plain", "json")
+	rootCmd.Flags().StringSlice("compare", nil, "Also ask these models, concurrently, and label each suggestion with the models that suggested it")
+	rootCmd.Flags().Bool("exec", false, "Run the selected command instead of inserting it into the prompt (with --plain, run the first suggestion)")
+	rootCmd.Flags().BoolP("yes", "y", false, "Skip the confirmations required by the config: viewing the explanation before --exec runs a command, and sending expensive requests")
+	rootCmd.Flags().String("order", defaultOrder, "Order of the suggestions: complexity, safety, portability or preference (most picked tools first)")
+	rootCmd.Flags().String("max-risk", "", "Drop suggestions classified above this risk level: low, medium or high")
+	rootCmd.MarkFlagsMutuallyExclusive("json", "exec")
+	costCmd.Flags().Bool("by-profile", false, "Show the costs per profile (CFOR_PROFILE)")
+	costCmd.Flags().Bool("team", false, "Show this month's costs of each member of the team server (CFOR_SERVER)")
+	costVerifyCmd.Flags().Int("days", 30, "Number of days to compare, up to today")
+	costVerifyCmd.Flags().String("project", "", "Only compare the costs billed to this OpenAI project ID")
+	batchCmd.Flags().String("format", "markdown", "Output format: markdown or json")
+	batchCmd.Flags().StringP("output", "o", "", "Write the results to this file instead of stdout")
+	batchCmd.Flags().Bool("batch-api", false, "Send the questions through OpenAI's batch API at half the price (may take up to 24 hours)")
+	batchCmd.Flags().Bool("deterministic", false, "Use temperature 0 and a fixed seed for stable suggestions")
+	batchCmd.Flags().String("lang", "", "Language of the comments (detected from each question by default)")
+	authRotateCmd.Flags().Bool("revoke-old", false, "Revoke the old key through OpenAI's admin API after storing the new one")
+	authRotateCmd.Flags().String("project", "", "OpenAI project ID the old key belongs to, for --revoke-old")
+	migrateCmd.Flags().Bool("dry-run", false, "Only list the files that would be moved")
+	costCmd.Flags().Bool("readonly", false, "Disable deleting entries (the default when the cost file is not writable)")
+	costCmd.Flags().String("file", "", "Review a cost file from elsewhere, such as another machine (implies --readonly)")
+	costCmd.Flags().Bool("by-project", false, "Show the costs per project (git repository)")
+	costCmd.MarkFlagsMutuallyExclusive("by-profile", "by-project")
+	snippetsAddCmd.Flags().String("question", "", "Question the snippet answers, used to match it")
+	snippetsAddCmd.Flags().String("comment", "", "Short comment shown next to the command")
+	snippetsAddCmd.Flags().StringSlice("tag", nil, "Extra keyword to match the snippet by")
+	snippetsAddCmd.Flags().Bool("shared", false, "Save to the team-shared directory instead of locally")
+	snippetsAddCmd.MarkFlagRequired("question")
+	translateCmd.Flags().String("to", "", "Tool to translate the command to, such as awk, podman, apt or wget")
+	translateCmd.MarkFlagRequired("to")
+	manCmd.Flags().Bool("refresh", false, "Ignore the cached summary and regenerate it")
+	rootCmd.Flags().Bool("prefer-snippets", false, "Use matching team-shared snippets instead of asking the API")
+	rootCmd.Flags().Duration("fast", defaultFastBudget, "Answer within a latency budget, e.g. --fast=5s, with the cheapest model and less context, showing cached or built-in suggestions if it runs out")
+	rootCmd.Flags().Lookup("fast").NoOptDefVal = defaultFastBudget.String()
+	rootCmd.Flags().Bool("no-cache", false, "Ask the API even if this question was answered before in the same environment")
+	rootCmd.Flags().Bool("no-verify", false, "Skip checking the suggested flags against local man pages")
+	rootCmd.Flags().Bool("dry-run", false, "Print the prompt and its estimated token count without calling the API")
+	rootCmd.Flags().String("lang", "", "Language of the comments (detected from the question by default)")
+	rootCmd.Flags().Bool("test", false, "Before using a command that changes files, run it in an empty copy of the current directory and show what changed")
+	rootCmd.Flags().Bool("accessible", false, "Screen-reader friendly mode: numbered suggestions, a simple input prompt and no screen redrawing (also CFOR_ACCESSIBLE=1)")
+	rootCmd.Flags().Bool("guide", false, "Answer a few clarifying questions first for tools like ffmpeg, rsync and tar")
+	rootCmd.Flags().StringSlice("image", nil, "Attach an image, such as a screenshot of an error, to the question")
 }
 
 func Execute() {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ComparedAnswer is one model's answer when comparing models
+type ComparedAnswer struct {
+	Model  string
+	Result ChatResult[Cmds]
+	Err    error
+}
+
+// comparedModels returns the model the question is asked with, followed by
+// the others to compare it to
+func comparedModels(opts GenerateOptions, others []string) []string {
+	models := []string{requestedModel(opts)}
+	for _, other := range others {
+		if other = strings.TrimSpace(other); other != "" && !slices.Contains(models, other) {
+			models = append(models, other)
+		}
+	}
+	return models
+}
+
+// CompareModels asks each of the models the question concurrently. The
+// answers are in the order of the models, failed ones included; only when
+// every model fails is an error returned, the first model's.
+func CompareModels(ctx context.Context, question string, opts GenerateOptions, models []string, refresh bool) ([]ComparedAnswer, error) {
+	answers := make([]ComparedAnswer, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			modelOpts := opts
+			modelOpts.Model = model
+			result, err := newGenerator(modelOpts, refresh).Generate(ctx, question)
+			answers[i] = ComparedAnswer{Model: model, Result: result, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	for _, answer := range answers {
+		if answer.Err == nil {
+			return answers, nil
+		}
+	}
+	return answers, answers[0].Err
+}
+
+// MergeComparedAnswers combines the suggestions of the models, each labeled
+// with the models that suggested it, e.g. [gpt-4o, gpt-4o-mini] for one both
+// did. The result is the first answering model's otherwise, since the history
+// and a rerun follow it.
+func MergeComparedAnswers(answers []ComparedAnswer) ChatResult[Cmds] {
+	var merged ChatResult[Cmds]
+	answered := false
+	suggestedBy := map[string][]string{}
+	for _, answer := range answers {
+		if answer.Err != nil {
+			continue
+		}
+		if !answered {
+			answered = true
+			merged = answer.Result
+			if merged.Model == "" {
+				merged.Model = answer.Model
+			}
+			merged.Message.Cmds = nil
+		}
+		for _, entry := range answer.Result.Message.Cmds {
+			key := normalizeCmd(entry.Cmd)
+			if _, ok := suggestedBy[key]; !ok {
+				merged.Message.Cmds = append(merged.Message.Cmds, entry)
+			}
+			if !slices.Contains(suggestedBy[key], answer.Model) {
+				suggestedBy[key] = append(suggestedBy[key], answer.Model)
+			}
+		}
+	}
+	// Served from the cache or not, each suggestion is labeled with its models
+	merged.Cached = false
+	for i, entry := range merged.Message.Cmds {
+		merged.Message.Cmds[i].Source = "[" + strings.Join(suggestedBy[normalizeCmd(entry.Cmd)], ", ") + "]"
+	}
+	return merged
+}
+
+// PrintComparison summarizes each model's answer: how many suggestions it
+// made, how many of them the first model made too, and what it cost
+func PrintComparison(w io.Writer, answers []ComparedAnswer) {
+	first := map[string]bool{}
+	for _, entry := range answers[0].Result.Message.Cmds {
+		first[normalizeCmd(entry.Cmd)] = true
+	}
+
+	for i, answer := range answers {
+		if answer.Err != nil {
+			fmt.Fprintf(w, "%s: failed (%v)\n", answer.Model, answer.Err)
+			continue
+		}
+		cmds := answer.Result.Message.Cmds
+		line := fmt.Sprintf("%s: %d suggestions", answer.Model, len(cmds))
+		if i > 0 && answers[0].Err == nil {
+			shared := 0
+			for _, entry := range cmds {
+				if first[normalizeCmd(entry.Cmd)] {
+					shared++
+				}
+			}
+			line += fmt.Sprintf(", %d also from %s", shared, answers[0].Model)
+		}
+		if answer.Result.Cached {
+			line += ", cached"
+		} else {
+			line += fmt.Sprintf(", $%.4f in %.1fs", answer.Result.Cost, answer.Result.Latency.Seconds())
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Listing the served models shouldn't hold up the request
+const compatibleListTimeout = 2 * time.Second
+
+// Servers that don't check keys, such as vLLM or llama.cpp's server without
+// --api-key, are sent this one, since the client requires one
+const compatiblePlaceholderKey = "none"
+
+// compatibleServer reports whether OpenAI requests go to a server of our own
+// choosing through CFOR_OPENAI_BASE_URL or base_url: a vLLM or llama.cpp
+// server, a LiteLLM proxy or a gateway. Which models it serves and how much
+// they cost is up to the server.
+func compatibleServer() bool {
+	return providerName() == ProviderOpenAI && serverURL() == "" && upstreamBaseURL() != ""
+}
+
+// compatibleModels returns the models the server lists, or none if it can't
+// be reached or doesn't list them
+var compatibleModels = sync.OnceValue(func() []openai.ChatModel {
+	client, err := sharedClient()
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), compatibleListTimeout)
+	defer cancel()
+	page, err := client.Models.List(ctx)
+	if err != nil {
+		logVerbose("failed to list the models of %s: %v", upstreamBaseURL(), err)
+		return nil
+	}
+	models := make([]openai.ChatModel, len(page.Data))
+	for i, model := range page.Data {
+		models[i] = model.ID
+	}
+	return models
+})
+
+// compatibleDefaultModel returns GPT-4o if the server serves it, as a gateway
+// in front of OpenAI would, or else the first model it lists
+func compatibleDefaultModel() openai.ChatModel {
+	models := compatibleModels()
+	if len(models) == 0 || slices.Contains(models, OpenAIModelGPT4o) {
+		return OpenAIModelGPT4o
+	}
+	return models[0]
+}
+
+// builtinPricing looks the model up in the prices cfor knows. Proxies such as
+// LiteLLM name models after their provider, e.g. openai/gpt-4o, so the name
+// is also looked up without that prefix, and Bedrock's inference profiles
+// are priced as their model.
+func builtinPricing(model openai.ChatModel) (CostPerToken, bool) {
+	model = bedrockBaseModel(model)
+	for _, costs := range []map[openai.ChatModel]CostPerToken{OpenAIModelCosts, AnthropicModelCosts, GeminiModelCosts, BedrockModelCosts} {
+		if cost, ok := costs[model]; ok {
+			return cost, true
+		}
+		if i := strings.LastIndex(model, "/"); i >= 0 {
+			if cost, ok := costs[model[i+1:]]; ok {
+				return cost, true
+			}
+		}
+	}
+	return CostPerToken{}, false
+}
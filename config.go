@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings read from the config file. Environment variables
+// and flags take precedence over it.
+type Config struct {
+	// Require viewing a suggestion's explanation (or passing --yes) before
+	// --exec runs it
+	ExecRequiresExplanation bool `yaml:"exec_requires_explanation"`
+	// Ask for confirmation (or --yes) before sending a request estimated to
+	// cost more than this many USD
+	ConfirmCostAbove float64 `yaml:"confirm_cost_above"`
+	// Print this week's spend, at most once a day
+	WeeklySummary bool `yaml:"weekly_summary"`
+	// Always use the screen-reader friendly mode, as with --accessible
+	Accessible bool `yaml:"accessible"`
+	// Colors of the interactive interface, default, colorblind or
+	// high-contrast, as with CFOR_THEME
+	Theme string `yaml:"theme"`
+	// Pricing per model, overriding the built-in prices and allowing models
+	// cfor doesn't know about
+	Pricing map[string]ModelPricing `yaml:"pricing"`
+	// Pricing of the models priced nowhere else, e.g. those served by an
+	// OpenAI-compatible server
+	FallbackPricing *ModelPricing `yaml:"fallback_pricing"`
+	// Questions answered ahead of time by cfor warm
+	Warm WarmConfig `yaml:"warm"`
+	// Pause between the characters of a command inserted into the prompt, for
+	// terminals that drop characters when a long command arrives at once
+	InjectDelay time.Duration `yaml:"inject_delay"`
+	// Wrap inserted commands in bracketed paste, so that nothing runs before
+	// Enter is pressed. Unset, only multi-line commands are wrapped, in shells
+	// known to support it.
+	BracketedPaste *bool `yaml:"bracketed_paste"`
+	// How commands are copied when they can't be inserted: auto, wl-copy,
+	// xclip, xsel or osc52, as with CFOR_CLIPBOARD
+	Clipboard string `yaml:"clipboard"`
+	// Set the terminal title and progress while generating, and mark inserted
+	// commands for terminals that can jump between them. On unless false.
+	TerminalIntegration *bool `yaml:"terminal_integration"`
+	// Copy the output of commands run with --exec to the transcript, which
+	// has them write to a pipe rather than the terminal. On unless false.
+	CaptureExecOutput *bool `yaml:"capture_exec_output"`
+	// Disable every outbound call except to the LLM endpoint, as with
+	// CFOR_OFFLINE
+	Offline bool `yaml:"offline"`
+	// The LLM provider, openai, anthropic, gemini, bedrock, openrouter or
+	// ollama, as with CFOR_PROVIDER
+	Provider string `yaml:"provider"`
+	// Models asked in turn when the model is rate limited, times out or is
+	// down, e.g. [gpt-4o-mini, ollama/llama3.1], as with CFOR_FALLBACK_MODELS
+	FallbackModels []string `yaml:"fallback_models"`
+	// The Ollama server, as with OLLAMA_HOST
+	OllamaHost string `yaml:"ollama_host"`
+	// The AWS region of Bedrock, as with CFOR_BEDROCK_REGION
+	BedrockRegion string `yaml:"bedrock_region"`
+	// Upstream providers OpenRouter routes each model to
+	OpenRouterRouting map[string]OpenRouterRouting `yaml:"openrouter_routing"`
+	// Commands printing the API key of each provider, e.g. openai: op read
+	// op://Private/OpenAI/credential, or of openai_admin for the admin key and
+	// server for the team server token, run when it isn't set in the
+	// environment. The key is only kept in memory.
+	APIKeyCommands map[string]string `yaml:"api_key_commands"`
+	// The LLM endpoint, e.g. an internal gateway, as with CFOR_OPENAI_BASE_URL
+	BaseURL string `yaml:"base_url"`
+	// Directory of pricing, risk rules and policy distributed alongside cfor,
+	// as with CFOR_BUNDLE
+	Bundle string `yaml:"bundle"`
+	// Proxy every connection goes through unless HTTPS_PROXY or HTTP_PROXY is
+	// set, e.g. http://proxy.corp:3128 or socks5://localhost:1080. Hosts in
+	// NO_PROXY are still connected to directly.
+	ProxyURL string `yaml:"proxy_url"`
+	// Team server holding the API key, as with CFOR_SERVER
+	Server string `yaml:"server"`
+	// How long a request may take, e.g. 30s or 2m, as with CFOR_TIMEOUT.
+	// Unset, it's 10s, longer for local and reasoning models.
+	Timeout time.Duration `yaml:"timeout"`
+	// Longest question accepted, in characters
+	MaxQuestionLength int `yaml:"max_question_length"`
+	// Largest image accepted as an attachment, in MB
+	MaxImageSize float64 `yaml:"max_image_size"`
+	// Commands each given the suggestions as JSON on stdin, which print them
+	// back changed, e.g. to add --dry-run to terraform apply. Run in order.
+	PostProcessors []string `yaml:"post_processors"`
+	// Model asked with --fast, the provider's cheapest one unless set
+	FastModel string `yaml:"fast_model"`
+	// Order of the suggestions, as with --order
+	Order string `yaml:"order"`
+	// Number of recently inserted commands kept for cfor ring
+	RingSize int `yaml:"ring_size"`
+	// Metrics shared with the maintainers, none by default
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+}
+
+// Limits applied unless set in the config file
+const (
+	defaultMaxQuestionLength = 1000
+	defaultMaxImageSize      = 10
+)
+
+// maxQuestionLength returns the longest question accepted, in characters
+func maxQuestionLength() int {
+	config, _ := LoadConfig()
+	if config.MaxQuestionLength > 0 {
+		return config.MaxQuestionLength
+	}
+	return defaultMaxQuestionLength
+}
+
+// maxImageSize returns the largest image accepted, in MB
+func maxImageSize() float64 {
+	config, _ := LoadConfig()
+	if config.MaxImageSize > 0 {
+		return config.MaxImageSize
+	}
+	return defaultMaxImageSize
+}
+
+// WarmConfig selects the questions cfor warm caches answers for
+type WarmConfig struct {
+	// The model answering, a cheaper one than used interactively
+	Model     string   `yaml:"model"`
+	Questions []string `yaml:"questions"`
+	// Also cache answers for this many of the most asked questions
+	MostAsked int `yaml:"most_asked"`
+}
+
+// ModelPricing is a model's price in USD per million tokens, the way
+// providers list it
+type ModelPricing struct {
+	Input       float64 `yaml:"input"`
+	CachedInput float64 `yaml:"cached_input"`
+	Output      float64 `yaml:"output"`
+}
+
+func (p ModelPricing) CostPerToken() CostPerToken {
+	return CostPerToken{
+		Input:       Cost(p.Input * 1e-6),
+		CachedInput: Cost(p.CachedInput * 1e-6),
+		Output:      Cost(p.Output * 1e-6),
+	}
+}
+
+func configFilepath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(dir, "cfor", "config.yaml")
+}
+
+// LoadConfig reads the config file once per process. A missing file yields
+// the defaults.
+var LoadConfig = sync.OnceValues(func() (Config, error) {
+	var config Config
+
+	configFilePath := configFilepath()
+	if configFilePath == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(configFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, ConfigParseError{Path: configFilePath, Err: err}
+	}
+
+	return config, nil
+})
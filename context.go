@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of the environment's context added to the prompt, gathered
+// concurrently. A source that isn't done by the deadline is left out rather
+// than holding up the request.
+var contextSources = []ContextSource{
+	{Name: "ssh hosts", Gather: sshHostsContext},
+	{Name: "git", Gather: gitContext},
+	{Name: "tools", Gather: toolsContext},
+	{Name: "man pages", Gather: manContext},
+}
+
+// How long gathering all context may take before the request goes out
+const contextDeadline = 200 * time.Millisecond
+
+// Prompts of the context sections
+const (
+	gitContextPrompt      = "The question is asked in a git repository in this state; use its actual branch and file names rather than placeholders:\n%s\n\n"
+	toolsContextPrompt    = "These tools mentioned in the question are installed: %s.\n\n"
+	packageManagerPrompt  = "The package manager is %s.\n\n"
+	manContextPrompt      = "Invocations of %s summarized from its man page here:\n%s\n\n"
+	maxManContextCommands = 5
+)
+
+// Questions about git, for which the repository's state matters
+var gitQuestionPattern = regexp.MustCompile(`(?i)\b(git|branch(es)?|commits?|merge|rebase|stash|cherry-pick|remote|tag)\b`)
+
+// Questions about installing software, for which the package manager matters
+var installQuestionPattern = regexp.MustCompile(`(?i)\b(install|uninstall|package|upgrade|update)`)
+
+// ContextSource gathers a section of the prompt from the environment. It
+// returns an empty section when it has nothing relevant to the question.
+type ContextSource struct {
+	Name   string
+	Gather func(ctx context.Context, question string) string
+}
+
+// The prompt is built several times per question (the cache lookup, the cost
+// preview and the request), but the context is only gathered once
+var (
+	gatheredContextMu sync.Mutex
+	gatheredContext   = map[string]string{}
+)
+
+// GatherContext returns the context sections relevant to the question, in
+// the order of the sources, from those done before the deadline
+func GatherContext(question string) string {
+	gatheredContextMu.Lock()
+	defer gatheredContextMu.Unlock()
+	if sections, ok := gatheredContext[question]; ok {
+		return sections
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextDeadline)
+	defer cancel()
+
+	type gathered struct {
+		index   int
+		section string
+	}
+	// Buffered, so that sources finishing after the deadline don't block
+	results := make(chan gathered, len(contextSources))
+	for i, source := range contextSources {
+		go func() {
+			results <- gathered{index: i, section: source.Gather(ctx, question)}
+		}()
+	}
+
+	sections := make([]string, len(contextSources))
+	done := make([]bool, len(contextSources))
+	started := time.Now()
+gather:
+	for range contextSources {
+		select {
+		case result := <-results:
+			sections[result.index] = result.section
+			done[result.index] = true
+		case <-ctx.Done():
+			for i, source := range contextSources {
+				if !done[i] {
+					logVerbose("context from %s not gathered within %s", source.Name, contextDeadline)
+				}
+			}
+			break gather
+		}
+	}
+	logVerbose("gathered context in %s", time.Since(started).Round(time.Millisecond))
+
+	joined := strings.Join(sections, "")
+	gatheredContext[question] = joined
+	return joined
+}
+
+// sshHostsContext resolves the SSH aliases mentioned in the question
+func sshHostsContext(ctx context.Context, question string) string {
+	hosts := MentionedSSHHosts(question)
+	if len(hosts) == 0 {
+		return ""
+	}
+	lines := make([]string, len(hosts))
+	for i, host := range hosts {
+		lines[i] = "- " + host.Describe()
+	}
+	return fmt.Sprintf(sshHostsPrompt, wrapData("SSH config", strings.Join(lines, "\n")))
+}
+
+// gitContext describes the repository's state for questions about git
+func gitContext(ctx context.Context, question string) string {
+	if !gitQuestionPattern.MatchString(question) {
+		return ""
+	}
+	state, err := ReadGitState()
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(gitContextPrompt, wrapData("repository state", state.Describe()))
+}
+
+// toolsContext lists the tools mentioned in the question that are on PATH,
+// and the package manager for questions about installing software
+func toolsContext(ctx context.Context, question string) string {
+	section := ""
+	if tools := mentionedTools(question); len(tools) > 0 {
+		section += fmt.Sprintf(toolsContextPrompt, strings.Join(tools, ", "))
+	}
+	if installQuestionPattern.MatchString(question) {
+		if manager := packageManager(); manager != "unknown" {
+			section += fmt.Sprintf(packageManagerPrompt, manager)
+		}
+	}
+	return section
+}
+
+// manContext adds the summaries cfor man has cached for the tools mentioned
+// in the question, which only use options documented on this system
+func manContext(ctx context.Context, question string) string {
+	section := ""
+	for _, tool := range mentionedTools(question) {
+		path := manCacheFilepath(tool)
+		if path == "" {
+			continue
+		}
+		summary, err := readManCache(path)
+		if err != nil || len(summary.Cmds) == 0 {
+			continue
+		}
+		cmds := summary.Cmds[:min(len(summary.Cmds), maxManContextCommands)]
+		lines := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			lines[i] = fmt.Sprintf("- %s  # %s", cmd.Cmd, cmd.Comment)
+		}
+		section += fmt.Sprintf(manContextPrompt, tool, wrapData("man page summary", strings.Join(lines, "\n")))
+	}
+	return section
+}
+
+// mentionedTools returns the words of the question that name a binary on
+// PATH, in order
+func mentionedTools(question string) []string {
+	tools := []string{}
+	for _, word := range strings.FieldsFunc(question, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) {
+		if len(word) < 2 || isShellBuiltin(word) || slices.Contains(tools, word) {
+			continue
+		}
+		if _, err := exec.LookPath(word); err == nil {
+			tools = append(tools, word)
+		}
+	}
+	return tools
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Bundled short flags, e.g. -la, which are the same as -l -a
+var bundledFlagsPattern = regexp.MustCompile(`^-[A-Za-z]{2,}$`)
+
+// Words separating the commands of pipelines and command lists
+var separatorWordPattern = regexp.MustCompile(`^(\|\||&&|[|;&])$`)
+
+// DedupeCmds drops suggestions equivalent to an earlier one, keeping the first
+func DedupeCmds(cmds []CmdEntry) []CmdEntry {
+	return mergeCmds(nil, cmds)
+}
+
+// normalizeCmd reduces a command to a form shared by trivially equivalent
+// ones: whitespace collapsed, bundled short flags split, and each run of
+// consecutive flags sorted. Arguments keep their order and quoting, so
+// commands that differ in what they act on stay apart.
+func normalizeCmd(cmd string) string {
+	words := []string{}
+	flags := []string{}
+	endOfFlags := false
+	flush := func() {
+		slices.Sort(flags)
+		words = append(words, flags...)
+		flags = flags[:0]
+	}
+
+	for _, word := range quotedFields(cmd) {
+		switch {
+		case separatorWordPattern.MatchString(word):
+			// A new command starts after a separator
+			flush()
+			endOfFlags = false
+			words = append(words, word)
+		case word == "--":
+			flush()
+			endOfFlags = true
+			words = append(words, word)
+		case !endOfFlags && bundledFlagsPattern.MatchString(word):
+			for _, letter := range word[1:] {
+				flags = append(flags, "-"+string(letter))
+			}
+		case !endOfFlags && isFlag(word):
+			flags = append(flags, word)
+		default:
+			flush()
+			words = append(words, word)
+		}
+	}
+	flush()
+	return strings.Join(words, " ")
+}
+
+// quotedFields splits a command into words like shellFields, but keeps the
+// quotes so that "a b" and a b remain different
+func quotedFields(s string) []string {
+	fields := []string{}
+	var current strings.Builder
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			current.WriteRune(r)
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			current.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n':
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+const diagnosePrompt = `For the **%s** operating system and the **%s** shell, the user ran this
+command, which exited with status %d:
+
+%s
+
+It printed the following (the end of its output):
+
+%s
+
+In one or two sentences, explain the likely cause of the failure. Then suggest
+commands that fix it or find out more, in the order of increasing complexity,
+each with a very short inline comment and a one-phrase tradeoff.`
+
+// Characters of output sent with the question, from the end where errors
+// usually are
+const maxDiagnosedOutput = 4000
+
+type FailureDiagnosis struct {
+	Cause string     `json:"cause"`
+	Cmds  []CmdEntry `json:"cmds"`
+}
+
+var StructuredDiagnosisSchema = sync.OnceValue(GenerateSchema[FailureDiagnosis])
+
+func diagnosisSchemaParam() openai.ResponseFormatJSONSchemaJSONSchemaParam {
+	return openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("diagnosis"),
+		Description: openai.F("The likely cause of a failed command and commands to fix it."),
+		Schema:      openai.F(StructuredDiagnosisSchema()),
+		Strict:      openai.Bool(true),
+	}
+}
+
+// DiagnoseFailure asks the model why a command failed given its output, and
+// for commands to fix it
+func DiagnoseFailure(cmd string, exitCode int, output string) (ChatResult[FailureDiagnosis], error) {
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[FailureDiagnosis]{}, err
+	}
+
+	if len(output) > maxDiagnosedOutput {
+		output = "..." + output[len(output)-maxDiagnosedOutput:]
+	}
+	if output == "" {
+		output = "(nothing)"
+	}
+
+	prompt := fmt.Sprintf(diagnosePrompt, runtime.GOOS, currentShell(), exitCode, cmd, wrapData("command output", output))
+	result, err := chatStructured[FailureDiagnosis](context.Background(), model, prompt, diagnosisSchemaParam(), DefaultGenerateOptions())
+	result.Message.Cmds = FlagSmuggledCmds(DedupeCmds(result.Message.Cmds), prompt, cmd)
+	return result, err
+}
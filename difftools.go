@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+const diffToolsPrompt = `For the **%s** operating system and the **%s** shell, rewrite this script to
+use the modern tools installed on the system instead of the legacy ones:
+
+%s
+
+Only these replacements are installed, under these exact names:
+%s
+
+Replace only the tools listed above, and keep the behavior identical where
+possible: same inputs, outputs, side effects and exit status. For each
+replacement, list the behavioral differences that remain or that the rewrite
+had to compensate for, such as files ignored by default, regex syntax, output
+format or exit codes.`
+
+// Modern equivalents of legacy tools, under the names they are installed as
+// (Debian renames some of them)
+var modernTools = map[string][]string{
+	"grep": {"rg"},
+	"find": {"fd", "fdfind"},
+	"top":  {"btop", "htop"},
+	"ls":   {"eza", "exa"},
+	"cat":  {"bat", "batcat"},
+	"du":   {"dust", "ncdu"},
+	"df":   {"duf"},
+	"ps":   {"procs"},
+	"sed":  {"sd"},
+	"diff": {"difft", "delta"},
+	"dig":  {"dog", "doggo"},
+}
+
+// ToolReplacement is a legacy tool and the modern one installed for it
+type ToolReplacement struct {
+	Legacy string
+	Modern string
+}
+
+type ToolDifference struct {
+	Legacy     string `json:"legacy"`
+	Modern     string `json:"modern"`
+	Difference string `json:"difference"`
+}
+
+type ToolMigration struct {
+	Script      string           `json:"script"`
+	Differences []ToolDifference `json:"differences"`
+}
+
+var StructuredToolMigrationSchema = sync.OnceValue(GenerateSchema[ToolMigration])
+
+func toolMigrationSchemaParam() openai.ResponseFormatJSONSchemaJSONSchemaParam {
+	return openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("tool_migration"),
+		Description: openai.F("A script rewritten for modern tools, with the behavioral differences."),
+		Schema:      openai.F(StructuredToolMigrationSchema()),
+		Strict:      openai.Bool(true),
+	}
+}
+
+// AvailableReplacements returns the modern tools installed for the legacy
+// ones the script uses
+func AvailableReplacements(script string) []ToolReplacement {
+	used := map[string]bool{}
+	for _, line := range strings.Split(script, "\n") {
+		for binary := range commandFlags(line) {
+			used[binary] = true
+		}
+	}
+
+	replacements := []ToolReplacement{}
+	for legacy := range used {
+		for _, modern := range modernTools[legacy] {
+			if _, err := exec.LookPath(modern); err == nil {
+				replacements = append(replacements, ToolReplacement{Legacy: legacy, Modern: modern})
+				break
+			}
+		}
+	}
+	sort.Slice(replacements, func(i, j int) bool { return replacements[i].Legacy < replacements[j].Legacy })
+	return replacements
+}
+
+// MigrateTools rewrites the script for the modern tools installed for its
+// legacy ones. Only installed replacements are offered to the model.
+func MigrateTools(script string) (ChatResult[ToolMigration], []ToolReplacement, error) {
+	replacements := AvailableReplacements(script)
+	if len(replacements) == 0 {
+		return ChatResult[ToolMigration]{}, nil, fmt.Errorf("no modern replacements are installed for the tools this uses")
+	}
+
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[ToolMigration]{}, replacements, err
+	}
+
+	lines := make([]string, len(replacements))
+	for i, r := range replacements {
+		lines[i] = fmt.Sprintf("- %s -> %s", r.Legacy, r.Modern)
+	}
+	prompt := fmt.Sprintf(diffToolsPrompt, runtime.GOOS, currentShell(), wrapData("script", script), strings.Join(lines, "\n"))
+	result, err := chatStructured[ToolMigration](context.Background(), model, prompt, toolMigrationSchemaParam(), DefaultGenerateOptions())
+	return result, replacements, err
+}
+
+// MissingTools returns the tools the rewritten script runs that aren't
+// installed, which would make it fail here
+func MissingTools(script string) []string {
+	missing := []string{}
+	for _, line := range strings.Split(script, "\n") {
+		for binary := range commandFlags(line) {
+			if slices.Contains(missing, binary) || isShellBuiltin(binary) {
+				continue
+			}
+			if _, err := exec.LookPath(binary); err != nil {
+				missing = append(missing, binary)
+			}
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// Builtins and keywords that commandFlags sees as binaries in scripts
+var shellBuiltins = map[string]bool{
+	"cd": true, "echo": true, "export": true, "for": true, "while": true, "if": true,
+	"then": true, "else": true, "fi": true, "do": true, "done": true, "case": true,
+	"esac": true, "read": true, "set": true, "local": true, "return": true, "source": true,
+	".": true, "[": true, "[[": true, "test": true, "printf": true, "shift": true,
+	"exit": true, "true": true, "false": true, "unset": true, "trap": true, "eval": true,
+	"function": true, "{": true, "}": true, "elif": true, "until": true, "in": true,
+}
+
+func isShellBuiltin(name string) bool {
+	return shellBuiltins[name] || strings.HasPrefix(name, "#") || strings.Contains(name, "=") ||
+		strings.HasSuffix(name, ")") || strings.HasPrefix(name, "$")
+}
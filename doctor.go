@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// DoctorCheck is the outcome of one health check
+type DoctorCheck struct {
+	Name     string
+	OK       bool
+	Detail   string
+	Duration time.Duration
+}
+
+// RunDoctor checks the local setup, then probes every configured model
+// concurrently, since a fallback is only as good as the models behind it
+func RunDoctor() []DoctorCheck {
+	checks := localChecks()
+	return append(checks, probeModels(configuredModels())...)
+}
+
+func localChecks() []DoctorCheck {
+	checks := []DoctorCheck{}
+
+	if _, err := newClient(); err != nil {
+		checks = append(checks, DoctorCheck{Name: "API key", Detail: err.Error()})
+	} else {
+		checks = append(checks, DoctorCheck{Name: "API key", OK: true, Detail: "set"})
+	}
+
+	if _, err := LoadConfig(); err != nil {
+		checks = append(checks, DoctorCheck{Name: "Config", Detail: err.Error()})
+	} else {
+		checks = append(checks, DoctorCheck{Name: "Config", OK: true, Detail: configFilepath()})
+	}
+
+	if _, err := LoadPolicy(); err != nil {
+		checks = append(checks, DoctorCheck{Name: "Org policy", Detail: err.Error()})
+	} else if _, err := os.Stat(policyFilepath); err != nil {
+		checks = append(checks, DoctorCheck{Name: "Org policy", OK: true, Detail: "none"})
+	} else {
+		checks = append(checks, DoctorCheck{Name: "Org policy", OK: true, Detail: policyFilepath})
+	}
+
+	if dir := bundleDir(); dir != "" {
+		if _, err := LoadBundle(); err != nil {
+			checks = append(checks, DoctorCheck{Name: "Bundle", Detail: err.Error()})
+		} else {
+			checks = append(checks, DoctorCheck{Name: "Bundle", OK: true, Detail: dir})
+		}
+	}
+	if offlineMode() {
+		checks = append(checks, DoctorCheck{Name: "Offline", OK: true, Detail: "only the LLM endpoint is contacted"})
+	}
+
+	for _, dir := range []struct{ name, path string }{
+		{"Data dir", filepath.Dir(costFilepath())},
+		{"Cache dir", cacheDir()},
+		{"State dir", stateDir()},
+	} {
+		if err := checkWritable(dir.path); err != nil {
+			checks = append(checks, DoctorCheck{Name: dir.name, Detail: err.Error()})
+		} else {
+			checks = append(checks, DoctorCheck{Name: dir.name, OK: true, Detail: dir.path})
+		}
+	}
+
+	// Optional: flag verification and man summaries fall back to --help
+	if _, err := exec.LookPath("man"); err != nil {
+		checks = append(checks, DoctorCheck{Name: "man", OK: true, Detail: "not found; using --help instead"})
+	} else {
+		checks = append(checks, DoctorCheck{Name: "man", OK: true, Detail: "found"})
+	}
+
+	checks = append(checks, DoctorCheck{Name: "Shell", OK: true, Detail: currentShell()})
+	return checks
+}
+
+func checkWritable(dir string) error {
+	if dir == "" || dir == "." {
+		return fmt.Errorf("could not determine the directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// probeModels looks each model up concurrently, which checks the key, the
+// latency and that the model is available to the key
+func probeModels(models []string) []DoctorCheck {
+	client, err := sharedClient()
+	if err != nil {
+		return nil
+	}
+
+	checks := make([]DoctorCheck, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), apiTimeout())
+			defer cancel()
+
+			started := time.Now()
+			_, err := client.Models.Get(ctx, model)
+			check := DoctorCheck{Name: "OpenAI " + model, Duration: time.Since(started)}
+
+			apiErr := &openai.Error{}
+			switch {
+			case err == nil:
+				check.OK = true
+				check.Detail = "available"
+			case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized:
+				check.Detail = "authentication failed"
+			case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+				check.Detail = "not available to this key"
+			default:
+				check.Detail = err.Error()
+			}
+			checks[i] = check
+		}()
+	}
+	wg.Wait()
+
+	return checks
+}
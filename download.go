@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"runtime"
+)
+
+// Downloads run without being saved first: piped into a shell, or passed to
+// one through process or command substitution
+var downloadRunPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(curl|wget)\b[^|]*\|\s*(sudo\s+(-\S+\s+)*)?(ba|z|da|k|fi)?sh\b`),
+	regexp.MustCompile(`\b(ba|z|k)?sh\s+<\(\s*(curl|wget)\b`),
+	regexp.MustCompile(`\b(ba|z|k)?sh\s+-c\s+["']?\$\(\s*(curl|wget)\b`),
+	regexp.MustCompile(`(?i)\b(iex|invoke-expression)\b.*\b(irm|iwr|invoke-restmethod|invoke-webrequest)\b`),
+}
+
+var downloadURLPattern = regexp.MustCompile(`https?://[^\s'"|)]+`)
+
+// Name used for the downloaded script when the URL's path has none
+const defaultDownloadName = "install.sh"
+
+// runsUnverifiedDownload reports whether the command runs something it
+// downloads without a chance to verify it
+func runsUnverifiedDownload(cmd string) bool {
+	for _, pattern := range downloadRunPatterns {
+		if pattern.MatchString(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// PairDownloadVerification warns about suggestions running a download
+// straight away, and follows each with a variant that saves the download and
+// prints its checksum to compare with the published one before running it
+func PairDownloadVerification(cmds []CmdEntry) []CmdEntry {
+	paired := []CmdEntry{}
+	for _, entry := range cmds {
+		if !runsUnverifiedDownload(entry.Cmd) {
+			paired = append(paired, entry)
+			continue
+		}
+
+		entry.Warnings = append(entry.Warnings, "runs a download without verifying its checksum or signature")
+		paired = append(paired, entry)
+
+		if verified, ok := verifiedDownload(entry.Cmd); ok {
+			paired = append(paired, verified)
+		}
+	}
+	return mergeCmds(nil, paired)
+}
+
+// verifiedDownload rewrites a download-and-run command to save the download
+// and print its checksum instead
+func verifiedDownload(cmd string) (CmdEntry, bool) {
+	rawURL := downloadURLPattern.FindString(cmd)
+	if rawURL == "" {
+		return CmdEntry{}, false
+	}
+
+	name := defaultDownloadName
+	if u, err := url.Parse(rawURL); err == nil {
+		if base := path.Base(u.Path); base != "." && base != "/" && base != "" {
+			name = base
+		}
+	}
+
+	checksum := "sha256sum"
+	if runtime.GOOS == "darwin" {
+		checksum = "shasum -a 256"
+	}
+
+	return CmdEntry{
+		Cmd:      fmt.Sprintf("curl -fsSL -o %s '%s' && %s %s", name, rawURL, checksum, name),
+		Comment:  fmt.Sprintf("compare with the published checksum (or gpg --verify its signature), read it, then run: sh %s", name),
+		Tradeoff: "verified before running",
+		Source:   "[safer]",
+	}, true
+}
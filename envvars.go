@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Variables referenced as $NAME or ${NAME}, including ${NAME:-default}
+var envReferencePattern = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Names of variables likely holding secrets, whose values are never shown
+var sensitiveEnvPattern = regexp.MustCompile(`(?i)(key|token|secret|pass(word|wd)?|credential|auth|private|session|cookie)`)
+
+// Single-quoted strings, which the shell takes literally
+var singleQuotedPattern = regexp.MustCompile(`'[^']*'`)
+
+// Shell parameters that aren't environment variables
+var shellParameters = map[string]bool{"_": true}
+
+// EnvVar is a variable a command references, as currently set
+type EnvVar struct {
+	Name  string
+	Value string
+	Set   bool
+	// The value is hidden because the variable likely holds a secret
+	Redacted bool
+}
+
+// Describe renders the variable for the detail pane
+func (v EnvVar) Describe() string {
+	switch {
+	case !v.Set:
+		return "(unset)"
+	case v.Redacted:
+		return "(set, hidden)"
+	case v.Value == "":
+		return `""`
+	}
+	return v.Value
+}
+
+// ReferencedEnvVars returns the environment variables the command uses with
+// their current values, so it's clear whether it will run against the
+// intended profile, cluster or account. Variables in single quotes aren't
+// expanded by the shell and are skipped.
+func ReferencedEnvVars(cmd string) []EnvVar {
+	vars := []EnvVar{}
+	for _, name := range referencedEnvNames(cmd) {
+		value, set := os.LookupEnv(name)
+		v := EnvVar{Name: name, Value: value, Set: set}
+		if set && sensitiveEnvPattern.MatchString(name) {
+			v.Value = ""
+			v.Redacted = true
+		} else if policy, err := LoadPolicy(); err == nil {
+			v.Value = policy.RedactText(value)
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+func referencedEnvNames(cmd string) []string {
+	names := []string{}
+	unquoted := singleQuotedPattern.ReplaceAllString(cmd, "")
+	for _, match := range envReferencePattern.FindAllStringSubmatch(unquoted, -1) {
+		name := match[1]
+		if shellParameters[name] || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// envVarLines renders the variables as aligned lines
+func envVarLines(vars []EnvVar) []string {
+	width := 0
+	for _, v := range vars {
+		width = max(width, len(v.Name)+1)
+	}
+	lines := make([]string, len(vars))
+	for i, v := range vars {
+		lines[i] = fmt.Sprintf("%-*s  %s", width, "$"+v.Name, strings.ReplaceAll(v.Describe(), "\n", " "))
+	}
+	return lines
+}
@@ -3,36 +3,164 @@ package main
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
+
+	"github.com/openai/openai-go"
 )
 
-type APIKeyMissingError struct{}
+type AdminKeyMissingError struct{}
+type APIKeyCommandError struct {
+	Provider string
+	Err      error
+}
+type APIKeyMissingError struct {
+	// The provider whose key is missing, OpenAI unless set
+	Provider string
+}
+type BudgetExceededError struct{ Budget time.Duration }
+type ConfigParseError struct {
+	Path string
+	Err  error
+}
 type CostFileNotFoundError struct{}
-type InjectError struct{ Char rune }
-type JSONParseError struct{ Err error }
-type OpenAIRequestError struct{ Err error }
+type ExplanationRequiredError struct{}
+type ImageError struct {
+	Path string
+	Err  error
+}
+type InjectError struct{ Byte byte }
+type JSONParseError struct {
+	Err       error
+	RequestID string
+}
+type ManPageNotFoundError struct{ Tool string }
+type NoSuggestionsError struct{ Reason string }
+type ModelNotApprovedError struct{ Model string }
+type OfflineError struct{ Action string }
+type OpenAIRequestError struct {
+	// The provider's display name, OpenAI unless set
+	Provider  string
+	Err       error
+	RequestID string
+	// The HTTP status the provider answered with, for those whose errors
+	// don't carry it
+	Status int
+	// How long the provider asked to wait before retrying
+	RetryAfter time.Duration
+}
+type QuestionTooLongError struct{ Length, Max int }
 type QuitError struct{}
-type RerunError struct{}
+type RerunError struct {
+	Pinned []CmdEntry
+	// The model to ask instead, when switching models
+	Model string
+	// How the selector was left, for the next one to open the same way
+	State SelectorState
+}
+type ToolNotInstalledError struct{ Tool string }
+type SpendCapExceededError struct {
+	Period string
+	Cap    float64
+}
+type TruncatedResponseError struct {
+	MaxTokens int64
+	RequestID string
+}
+type TUIUnavailableError struct{}
+type UnsafeTestError struct{ Cmd string }
 type UnsupportedModelError struct{ Model string }
+type VisionUnsupportedError struct{ Model string }
+
+func (e AdminKeyMissingError) Error() string {
+	return "CFOR_OPENAI_ADMIN_KEY or OPENAI_ADMIN_KEY environment variable must be set"
+}
+
+func (e APIKeyCommandError) Error() string {
+	return fmt.Sprintf("the api_key_commands entry of %s failed: %v", e.Provider, e.Err)
+}
+
+func (e APIKeyCommandError) Unwrap() error {
+	return e.Err
+}
 
 func (e APIKeyMissingError) Error() string {
-	return "CFOR_OPEN_API_KEY or OPENAI_API_KEY environment variable must be set"
+	switch e.Provider {
+	case ProviderAnthropic:
+		return "CFOR_ANTHROPIC_API_KEY or ANTHROPIC_API_KEY environment variable must be set"
+	case ProviderGemini:
+		return "CFOR_GEMINI_API_KEY or GEMINI_API_KEY environment variable must be set"
+	case ProviderOpenRouter:
+		return "CFOR_OPENROUTER_API_KEY or OPENROUTER_API_KEY environment variable must be set"
+	}
+	return "CFOR_OPEN_API_KEY or OPENAI_API_KEY environment variable must be set, or a key stored with cfor auth set or cfor auth rotate"
+}
+
+func (e BudgetExceededError) Error() string {
+	return fmt.Sprintf("no answer within the latency budget of %s", e.Budget)
+}
+
+func (e ConfigParseError) Error() string {
+	return fmt.Sprintf("failed to parse config file %s: %v", e.Path, e.Err)
 }
 
 func (e CostFileNotFoundError) Error() string {
 	return "Cost file not found"
 }
 
+func (e ExplanationRequiredError) Error() string {
+	return "the explanation must be viewed (or --yes passed) before running a command"
+}
+
+func (e ImageError) Error() string {
+	return fmt.Sprintf("failed to read image %s: %v", e.Path, e.Err)
+}
+
 func (e InjectError) Error() string {
-	return fmt.Sprintf("failed to inject character: %c", e.Char)
+	return fmt.Sprintf("failed to inject byte: %#x", e.Byte)
 }
 
 func (e JSONParseError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("JSON unmarshal failed (request ID: %s): %v", e.RequestID, e.Err)
+	}
 	return fmt.Sprintf("JSON unmarshal failed: %v", e.Err)
 }
 
+func (e ManPageNotFoundError) Error() string {
+	return fmt.Sprintf("no man page found for %s", e.Tool)
+}
+
+func (e ModelNotApprovedError) Error() string {
+	return fmt.Sprintf("model %s is not approved by the org policy", e.Model)
+}
+
+func (e NoSuggestionsError) Error() string {
+	return "all suggestions " + e.Reason
+}
+
+func (e OfflineError) Error() string {
+	return fmt.Sprintf("%s needs network access, which is disabled in offline mode", e.Action)
+}
+
 func (e OpenAIRequestError) Error() string {
-	return fmt.Sprintf("OpenAI request failed: %v", e.Err)
+	provider := e.Provider
+	if provider == "" {
+		provider = "OpenAI"
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s request failed (request ID: %s): %v", provider, e.RequestID, e.Err)
+	}
+	return fmt.Sprintf("%s request failed: %v", provider, e.Err)
+}
+
+func (e OpenAIRequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e QuestionTooLongError) Error() string {
+	return fmt.Sprintf("the question is %d characters long, over the limit of %d set by max_question_length", e.Length, e.Max)
 }
 
 func (q QuitError) Error() string {
@@ -43,6 +171,34 @@ func (q RerunError) Error() string {
 	return "rerunning"
 }
 
+func (q RerunError) Is(target error) bool {
+	_, ok := target.(RerunError)
+	return ok
+}
+
+func (e SpendCapExceededError) Error() string {
+	return fmt.Sprintf("the org policy's %s spend cap of $%.2f has been reached", e.Period, e.Cap)
+}
+
+func (e ToolNotInstalledError) Error() string {
+	return fmt.Sprintf("%s is not installed", e.Tool)
+}
+
+func (e TruncatedResponseError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("the response was cut off at %d tokens (request ID: %s)", e.MaxTokens, e.RequestID)
+	}
+	return fmt.Sprintf("the response was cut off at %d tokens", e.MaxTokens)
+}
+
+func (e TUIUnavailableError) Error() string {
+	return "interactive selection is not available in this build; use --plain or --json"
+}
+
+func (e UnsafeTestError) Error() string {
+	return fmt.Sprintf("cannot test %q: it refers to paths outside the current directory", e.Cmd)
+}
+
 func (e UnsupportedModelError) Error() string {
 	return fmt.Sprintf("Unsupported model: %s", e.Model)
 }
@@ -51,8 +207,96 @@ func (e UnsupportedModelError) Is(target error) bool {
 	return target == e
 }
 
+func (e VisionUnsupportedError) Error() string {
+	return fmt.Sprintf("Model does not accept images: %s", e.Model)
+}
+
 func HandleQuitError(err error) {
 	if errors.Is(err, QuitError{}) {
 		os.Exit(0)
 	}
 }
+
+// ErrorDetail describes an error for wrappers and editor plugins, which
+// branch on the code rather than the message
+type ErrorDetail struct {
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	Retryable bool            `json:"retryable"`
+	Provider  *ProviderDetail `json:"provider,omitempty"`
+}
+
+// ProviderDetail is what the provider reported about a failed request
+type ProviderDetail struct {
+	Status    int    `json:"status,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// DescribeError classifies the error. Requests are worth retrying when the
+// provider was rate limited, failed on its end or couldn't be reached, and
+// when it answered with malformed JSON.
+func DescribeError(err error) ErrorDetail {
+	detail := ErrorDetail{Code: "unknown", Message: err.Error()}
+
+	if requestErr := (&OpenAIRequestError{}); errors.As(err, &requestErr) {
+		detail.Code = "provider_error"
+		detail.Retryable = true
+		if requestErr.RequestID != "" || requestErr.Status != 0 {
+			detail.Provider = &ProviderDetail{RequestID: requestErr.RequestID, Status: requestErr.Status}
+		}
+		if requestErr.Status != 0 {
+			detail.Retryable = requestErr.Status == http.StatusTooManyRequests || requestErr.Status >= 500
+		}
+		if apiErr := (&openai.Error{}); errors.As(err, &apiErr) {
+			detail.Provider = &ProviderDetail{RequestID: requestErr.RequestID}
+			detail.Provider.Status = apiErr.StatusCode
+			detail.Provider.Type = apiErr.Type
+			detail.Provider.Code = apiErr.Code
+			detail.Provider.Message = apiErr.Message
+			detail.Retryable = apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+		}
+		return detail
+	}
+	if parseErr := (&JSONParseError{}); errors.As(err, &parseErr) {
+		detail.Code = "invalid_response"
+		detail.Retryable = true
+		if parseErr.RequestID != "" {
+			detail.Provider = &ProviderDetail{RequestID: parseErr.RequestID}
+		}
+		return detail
+	}
+
+	switch {
+	case errors.As(err, new(*APIKeyMissingError)):
+		detail.Code = "api_key_missing"
+	case errors.As(err, new(*APIKeyCommandError)):
+		detail.Code = "api_key_command_failed"
+	case errors.As(err, new(*BudgetExceededError)):
+		detail.Code = "budget_exceeded"
+		detail.Retryable = true
+	case errors.As(err, new(UnsupportedModelError)):
+		detail.Code = "unsupported_model"
+	case errors.As(err, new(VisionUnsupportedError)):
+		detail.Code = "vision_unsupported"
+	case errors.As(err, new(ImageError)):
+		detail.Code = "invalid_image"
+	case errors.As(err, new(*ModelNotApprovedError)):
+		detail.Code = "model_not_approved"
+	case errors.As(err, new(*SpendCapExceededError)):
+		detail.Code = "spend_cap_exceeded"
+	case errors.As(err, new(ConfigParseError)):
+		detail.Code = "invalid_config"
+	case errors.As(err, new(NoSuggestionsError)):
+		detail.Code = "no_suggestions"
+	case errors.As(err, new(QuestionTooLongError)):
+		detail.Code = "question_too_long"
+	case errors.As(err, new(*TruncatedResponseError)):
+		detail.Code = "truncated_response"
+	case errors.As(err, new(*OfflineError)):
+		detail.Code = "offline"
+	}
+	return detail
+}
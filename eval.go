@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EvalSuite is a set of questions asked of two variants of the prompt or
+// model, to compare them
+type EvalSuite struct {
+	Variants  []EvalVariant  `yaml:"variants"`
+	Questions []EvalQuestion `yaml:"questions"`
+}
+
+type EvalVariant struct {
+	Name string `yaml:"name"`
+	// Defaults to the model selected through CFOR_OPENAI_MODEL
+	Model string `yaml:"model"`
+	// Replaces the built-in guidelines of the prompt
+	Guidelines string `yaml:"guidelines"`
+}
+
+type EvalQuestion struct {
+	Question string `yaml:"question"`
+	// Binaries a good suggestion uses, any one of them
+	Expect []string `yaml:"expect"`
+}
+
+// EvalScore is how a variant's suggestions for a question scored
+type EvalScore struct {
+	Suggestions int
+	// Suggestions shellcheck found no errors in, when it's installed
+	Valid int
+	// Suggestions using one of the expected binaries
+	Expected int
+	Err      error
+}
+
+// ReadEvalSuite reads an evaluation suite from a YAML file
+func ReadEvalSuite(path string) (EvalSuite, error) {
+	var suite EvalSuite
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return suite, err
+	}
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return suite, ConfigParseError{Path: path, Err: err}
+	}
+
+	if len(suite.Variants) != 2 {
+		return suite, ConfigParseError{Path: path, Err: fmt.Errorf("expected 2 variants, got %d", len(suite.Variants))}
+	}
+	if len(suite.Questions) == 0 {
+		return suite, ConfigParseError{Path: path, Err: fmt.Errorf("no questions")}
+	}
+	for i, variant := range suite.Variants {
+		if variant.Name == "" {
+			suite.Variants[i].Name = string(rune('A' + i))
+		}
+	}
+	return suite, nil
+}
+
+// RunEval asks every question of both variants, a few at a time, and scores
+// the suggestions. Deterministic options keep the comparison about the
+// variants rather than sampling.
+func RunEval(suite EvalSuite, shellcheck bool) ([][]EvalScore, error) {
+	defaultModel, err := openAIModel()
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([][]EvalScore, len(suite.Questions))
+	usage := make([][]ChatResult[Cmds], len(suite.Questions))
+	for i := range suite.Questions {
+		scores[i] = make([]EvalScore, len(suite.Variants))
+		usage[i] = make([]ChatResult[Cmds], len(suite.Variants))
+	}
+	sem := make(chan struct{}, batchConcurrency)
+
+	var wg sync.WaitGroup
+	for i, question := range suite.Questions {
+		for j, variant := range suite.Variants {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				result, err := evalVariant(variant, defaultModel, question.Question)
+				usage[i][j] = result
+				if err != nil {
+					scores[i][j] = EvalScore{Err: err}
+					return
+				}
+				scores[i][j] = scoreCmds(result.Message.Cmds, question.Expect, shellcheck)
+			}()
+		}
+	}
+	wg.Wait()
+
+	// The cost file isn't safe to update concurrently
+	for _, results := range usage {
+		for _, result := range results {
+			RecordUsage(result)
+		}
+	}
+
+	return scores, nil
+}
+
+func evalVariant(variant EvalVariant, defaultModel, question string) (ChatResult[Cmds], error) {
+	model := defaultModel
+	if variant.Model != "" {
+		checked, err := checkModel(variant.Model)
+		if err != nil {
+			return ChatResult[Cmds]{}, err
+		}
+		model = checked
+	}
+
+	guidelines := guidelinePrompt
+	if variant.Guidelines != "" {
+		guidelines = strings.TrimSpace(variant.Guidelines) + "\n\n"
+	}
+
+	opts := DeterministicGenerateOptions()
+	return chatCmds(context.Background(), model, cmdsPrompt(guidelines, question, opts), opts)
+}
+
+func scoreCmds(cmds []CmdEntry, expect []string, shellcheck bool) EvalScore {
+	score := EvalScore{Suggestions: len(cmds)}
+	for _, entry := range cmds {
+		if shellcheck && shellcheckPasses(entry.Cmd) {
+			score.Valid++
+		}
+		for binary := range commandFlags(entry.Cmd) {
+			if slices.Contains(expect, binary) {
+				score.Expected++
+				break
+			}
+		}
+	}
+	return score
+}
+
+// shellcheckPasses reports whether shellcheck finds no errors in the command.
+// It doesn't know zsh or fish, which are checked as bash.
+func shellcheckPasses(cmd string) bool {
+	dialect := "bash"
+	switch shell := currentShell(); shell {
+	case "sh", "dash", "ksh":
+		dialect = shell
+	}
+
+	check := exec.Command("shellcheck", "--shell", dialect, "--severity", "error", "-")
+	check.Stdin = bytes.NewBufferString(cmd + "\n")
+	return check.Run() == nil
+}
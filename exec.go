@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+)
+
+// Commands running longer than this get their duration printed when they
+// finish
+const longRunningCmd = 5 * time.Second
+
+// userShell returns the user's login shell, falling back to sh
+func userShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "sh"
+}
+
+// captureExecOutput reports whether the output of executed commands is copied
+// to the transcript. Copying it means the command writes to a pipe rather than
+// the terminal, so it's on unless false in the config file.
+func captureExecOutput() bool {
+	config, _ := LoadConfig()
+	return config.CaptureExecOutput == nil || *config.CaptureExecOutput
+}
+
+// ExecResult is the outcome of an executed command
+type ExecResult struct {
+	ExitCode int
+	Duration time.Duration
+	// Interrupted with Ctrl+C
+	Interrupted bool
+	// The end of the combined output, when captured
+	Output string
+}
+
+// execCmd runs the command in the user's shell with the terminal attached.
+// Ctrl+C reaches the command, which shares the terminal's process group,
+// while cfor waits for it to exit. The elapsed time is shown in the terminal
+// title.
+func execCmd(cmd string) (ExecResult, error) {
+	c := exec.Command(userShell(), "-c", cmd)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+
+	output := &tailBuffer{max: maxTranscriptOutput}
+	if captureExecOutput() {
+		c.Stdout = io.MultiWriter(os.Stdout, output)
+		c.Stderr = io.MultiWriter(os.Stderr, output)
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	started := time.Now()
+	if err := c.Start(); err != nil {
+		return ExecResult{ExitCode: 1}, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+
+	startTerminalProgress()
+	defer stopTerminalProgress()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	result := ExecResult{}
+	var err error
+	for waiting := true; waiting; {
+		select {
+		case err = <-done:
+			waiting = false
+		case <-interrupts:
+			result.Interrupted = true
+		case <-ticker.C:
+			setTerminalTitle(fmt.Sprintf("%s (%s)", cmd, formatElapsed(time.Since(started))))
+		}
+	}
+	result.Duration = time.Since(started)
+	result.Output = output.String()
+
+	if exitErr := (&exec.ExitError{}); errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		// Killed by the signal rather than exiting on it, the way shells
+		// report it
+		if result.ExitCode == -1 && result.Interrupted {
+			result.ExitCode = 130
+		}
+		return result, nil
+	}
+	if err != nil {
+		result.ExitCode = 1
+		return result, err
+	}
+	return result, nil
+}
+
+// formatElapsed renders a duration to the second, e.g. 1m05s
+func formatElapsed(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d < time.Hour {
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%dh%02dm%02ds", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Cached explanations older than this are regenerated. What a command does
+// changes more slowly than the best way to do something, so they're kept
+// longer than answers.
+const explanationCacheTTL = 30 * 24 * time.Hour
+
+const explainPrompt = `Explain what the following command does on the **%s** operating system,
+for someone about to run it. Give a one-sentence summary, then break the command
+down into its parts (binaries, flags, arguments, pipes) with a short description
+of each. Mention side effects such as deleted or overwritten files.
+
+%s`
+
+type ExplanationPart struct {
+	Part        string `json:"part"`
+	Description string `json:"description"`
+}
+
+type Explanation struct {
+	Summary string            `json:"summary"`
+	Parts   []ExplanationPart `json:"parts"`
+}
+
+var StructuredExplanationSchema = sync.OnceValue(GenerateSchema[Explanation])
+
+func explanationSchemaParam() openai.ResponseFormatJSONSchemaJSONSchemaParam {
+	return openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("explanation"),
+		Description: openai.F("A summary of a command and a breakdown of its parts."),
+		Schema:      openai.F(StructuredExplanationSchema()),
+		Strict:      openai.Bool(true),
+	}
+}
+
+type cachedExplanation struct {
+	Explanation Explanation `json:"explanation"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+func explanationCacheFilepath(key string) string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "explanations", key+".json")
+}
+
+// explanationCacheKey hashes the model, the operating system and the command
+// normalized, so that the same command written with its flags reordered or
+// respaced, as is common when reviewing code, is explained once
+func explanationCacheKey(model, cmd string) string {
+	hash := sha256.New()
+	for _, part := range []string{model, runtime.GOOS, normalizeCmd(cmd)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ExplainCmd asks the model to break a command down into its parts. The
+// explanation is cached by the command, so explaining it again in another
+// session is instant and free.
+func ExplainCmd(cmd string) (ChatResult[Explanation], error) {
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[Explanation]{}, err
+	}
+
+	cachePath := explanationCacheFilepath(explanationCacheKey(model, cmd))
+	if cachePath != "" {
+		if cached, err := readExplanationCache(cachePath); err == nil {
+			return ChatResult[Explanation]{Message: cached, Model: model, Cached: true}, nil
+		}
+	}
+
+	prompt := fmt.Sprintf(explainPrompt, runtime.GOOS, cmd)
+	result, err := chatStructured[Explanation](context.Background(), model, prompt, explanationSchemaParam(), DefaultGenerateOptions())
+	if err != nil {
+		return result, err
+	}
+
+	if cachePath != "" {
+		if err := writeExplanationCache(cachePath, result.Message); err != nil {
+			logVerbose("failed to cache the explanation: %v", err)
+		}
+	}
+	return result, nil
+}
+
+func readExplanationCache(path string) (Explanation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Explanation{}, err
+	}
+
+	var cached cachedExplanation
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return Explanation{}, err
+	}
+
+	if time.Since(cached.CreatedAt) > explanationCacheTTL {
+		return Explanation{}, fmt.Errorf("cached explanation expired")
+	}
+
+	return cached.Explanation, nil
+}
+
+func writeExplanationCache(path string, explanation Explanation) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cachedExplanation{Explanation: explanation, CreatedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal explanation: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FallbackModel is a model asked when the ones before it fail, served by the
+// configured provider or another one
+type FallbackModel struct {
+	Provider string
+	Model    string
+}
+
+// String returns the model as it's configured, prefixed with its provider
+// when that isn't the configured one
+func (m FallbackModel) String() string {
+	if m.Provider == providerName() {
+		return m.Model
+	}
+	return m.Provider + "/" + m.Model
+}
+
+var providerNames = []string{ProviderOpenAI, ProviderAnthropic, ProviderGemini, ProviderBedrock, ProviderOpenRouter, ProviderOllama}
+
+// parseFallbackModel reads a fallback model: a model of the configured
+// provider, e.g. gpt-4o-mini, or one prefixed with another provider, e.g.
+// ollama/llama3.1. Since OpenRouter's models are prefixed with their vendor,
+// they keep the provider's prefix even when it's the configured one, e.g.
+// openrouter/openai/gpt-4o.
+func parseFallbackModel(entry string) FallbackModel {
+	if prefix, model, ok := strings.Cut(entry, "/"); ok && slices.Contains(providerNames, strings.ToLower(prefix)) {
+		return FallbackModel{Provider: strings.ToLower(prefix), Model: model}
+	}
+	return FallbackModel{Provider: providerName(), Model: entry}
+}
+
+// fallbackModels returns the models asked in turn when the model fails for
+// reasons on the provider's end, set through CFOR_FALLBACK_MODELS as a comma
+// separated list or fallback_models in the config file
+func fallbackModels() []FallbackModel {
+	var entries []string
+	if env := os.Getenv("CFOR_FALLBACK_MODELS"); env != "" {
+		entries = strings.Split(env, ",")
+	} else {
+		config, _ := LoadConfig()
+		entries = config.FallbackModels
+	}
+
+	models := []FallbackModel{}
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			models = append(models, parseFallbackModel(entry))
+		}
+	}
+	return models
+}
+
+// Providers of fallback models, built when first fallen back to
+var (
+	fallbackProvidersMu sync.Mutex
+	fallbackProviders   = map[string]Provider{}
+)
+
+// fallbackProvider returns the provider of the name, reusing the configured
+// one
+func fallbackProvider(name string) (Provider, error) {
+	if name == providerName() {
+		return sharedProvider()
+	}
+
+	fallbackProvidersMu.Lock()
+	defer fallbackProvidersMu.Unlock()
+	if provider, ok := fallbackProviders[name]; ok {
+		return provider, nil
+	}
+	provider, err := newNamedProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	fallbackProviders[name] = provider
+	return provider, nil
+}
+
+// providerFailed reports whether the request failed on the provider's end:
+// rate limited, timed out, unreachable or failing with a server error. Errors
+// in the request itself, e.g. a missing key, would fail again or with the
+// next model too, and once the caller gives up nothing more is asked.
+func providerFailed(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	detail := DescribeError(err)
+	return detail.Code == "provider_error" && detail.Retryable
+}
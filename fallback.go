@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// The most common lookups, answered without the API when it can't be reached
+// and the question isn't cached. Generated from fallback.json with
+// `make fallback`.
+//
+//go:embed fallback.json.gz
+var fallbackCorpusGz []byte
+
+// Label shown next to suggestions from the embedded corpus
+const fallbackBadge = "[offline]"
+
+// Share of words a question and a corpus entry must have in common, relative
+// to both, for the entry to answer it
+const fallbackMatchThreshold = 0.5
+
+// FallbackEntry is an answer in the embedded corpus
+type FallbackEntry struct {
+	Question string `json:"question"`
+	// Other words the question may use, e.g. big for large
+	Tags []string `json:"tags,omitempty"`
+	// The only operating system the answer applies to, if any
+	OS   string     `json:"os,omitempty"`
+	Cmds []CmdEntry `json:"cmds"`
+}
+
+// Words that don't tell two lookups apart. Unlike keywords, two-letter words
+// are kept for extensions and tools such as gz, xz and ip.
+var fallbackStopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "to": true, "of": true, "in": true,
+	"on": true, "for": true, "and": true, "or": true, "with": true, "from": true,
+	"into": true, "all": true, "how": true, "do": true, "can": true, "my": true,
+	"is": true, "it": true, "what": true, "this": true, "that": true, "me": true,
+	"using": true, "command": true, "way": true,
+}
+
+// Words used for the same thing, mapped to the one the corpus uses
+var fallbackSynonyms = map[string]string{
+	"delete": "remove", "erase": "remove", "big": "large", "huge": "large",
+	"folder": "directory", "dir": "directory", "unpack": "extract",
+	"unzip": "extract", "untar": "extract", "mac": "macos", "osx": "macos",
+}
+
+// Corpus decompressed on first use, so that startup doesn't pay for it
+var loadFallbackCorpus = sync.OnceValues(func() ([]FallbackEntry, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(fallbackCorpusGz))
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []FallbackEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+})
+
+// FallbackCmds returns the embedded answer to the closest common lookup, if
+// one is close enough and applies to this operating system
+func FallbackCmds(question string) ([]CmdEntry, bool) {
+	entries, err := loadFallbackCorpus()
+	if err != nil {
+		logVerbose("failed to load the fallback corpus: %v", err)
+		return nil, false
+	}
+
+	words := fallbackWords(question)
+	if len(words) == 0 {
+		return nil, false
+	}
+
+	best, bestScore := FallbackEntry{}, 0.0
+	for _, entry := range entries {
+		if entry.OS != "" && entry.OS != runtime.GOOS {
+			continue
+		}
+		if score := fallbackScore(words, entry); score > bestScore {
+			best, bestScore = entry, score
+		}
+	}
+	if bestScore < fallbackMatchThreshold {
+		return nil, false
+	}
+	return labelSource(best.Cmds, fallbackBadge), true
+}
+
+// fallbackScore is the share of words the question has in common with the
+// entry's question, counted against both, so that neither a vague question
+// nor a long one matches by accident. Tags count as hits but not against the
+// entry.
+func fallbackScore(words map[string]bool, entry FallbackEntry) float64 {
+	entryWords := fallbackWords(entry.Question)
+	hits := 0
+	for word := range entryWords {
+		if words[word] {
+			hits++
+		}
+	}
+	for word := range fallbackWords(strings.Join(entry.Tags, " ")) {
+		if words[word] && !entryWords[word] {
+			hits++
+		}
+	}
+	return 2 * float64(hits) / float64(len(words)+len(entryWords))
+}
+
+// fallbackWords returns the stemmed words of the text that carry meaning
+func fallbackWords(text string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}) {
+		if len(word) < 2 || fallbackStopWords[word] {
+			continue
+		}
+		// Plurals share the synonym, e.g. folders for directory
+		if synonym, ok := fallbackSynonyms[strings.TrimSuffix(word, "s")]; ok {
+			word = synonym
+		}
+		words[stem(word)] = true
+	}
+	return words
+}
+
+// stem strips common English suffixes, so that finding matches find and
+// files matches file. Both sides are stemmed, so the stems needn't be words.
+func stem(word string) string {
+	switch {
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		word = strings.TrimSuffix(word, "ing")
+		// running, stopping
+		if n := len(word); word[n-1] == word[n-2] {
+			word = word[:n-1]
+		}
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		word = strings.TrimSuffix(word, "ies") + "y"
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		word = strings.TrimSuffix(word, "ed")
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		word = strings.TrimSuffix(word, "s")
+	}
+	if len(word) > 3 {
+		word = strings.TrimSuffix(word, "e")
+	}
+	return word
+}
+
+// IsConnectivityError reports whether the request failed for lack of a
+// connection to the API, rather than being refused by it
+func IsConnectivityError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
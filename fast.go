@@ -0,0 +1,44 @@
+package main
+
+import "time"
+
+// Latency budget of --fast unless given, e.g. --fast=5s
+const defaultFastBudget = 2 * time.Second
+
+// Tokens a fast answer may take at first, enough for a few short suggestions
+const fastMaxTokens = 512
+
+// fastModel returns the model --fast asks: fast_model from the config file,
+// or else the provider's cheapest model that doesn't reason before answering,
+// which are also its fastest. Local models and those of OpenRouter or an
+// OpenAI-compatible server are kept as configured, since loading another or
+// picking among hundreds of unknown ones isn't any faster.
+func fastModel(opts GenerateOptions) string {
+	config, _ := LoadConfig()
+	if config.FastModel != "" {
+		return config.FastModel
+	}
+	model := requestedModel(opts)
+	if providerName() == ProviderOllama || providerName() == ProviderOpenRouter || compatibleServer() {
+		return model
+	}
+
+	policy, _ := LoadPolicy()
+	cheapest, cheapestCost := model, Cost(-1)
+	for _, candidate := range providerModels() {
+		if isReasoningModel(candidate) || !policy.ApprovesModel(candidate) {
+			continue
+		}
+		if len(opts.Images) > 0 && !IsVisionModel(candidate) {
+			continue
+		}
+		pricing, ok := modelPricing(candidate)
+		if !ok {
+			continue
+		}
+		if cost := pricing.Input + pricing.Output; cheapestCost < 0 || cost < cheapestCost {
+			cheapest, cheapestCost = candidate, cost
+		}
+	}
+	return cheapest
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Larger trees are cut short; the fixture only needs to be representative
+	maxFixtureEntries = 5000
+	fixtureTimeout    = 10 * time.Second
+	// Output beyond this is elided when showing the test result
+	maxFixtureOutputLines = 20
+)
+
+// Tools that create, delete, move or change files
+var fileModifyingPattern = regexp.MustCompile(
+	`(^|[;&|(]\s*|\b(sudo|do|then|else|xargs)\s+)(rm|rmdir|mv|cp|mkdir|touch|chmod|chown|chgrp|ln|rename|truncate|shred|install|unzip|rsync)\b` +
+		`|\bfind\b.*\s-(delete|exec)\b|\btar\s+-?\w*x|\bsed\s+(-\w+\s+)*-i|[^<>&0-9]>{1,2}\s*[^&\s]`,
+)
+
+// Output discarded to /dev/null doesn't change any files
+var devNullPattern = regexp.MustCompile(`[0-9&]?>{1,2}\s*/dev/null`)
+
+// Arguments that reach outside the current directory, which the fixture can't
+// stand in for
+var outsidePathPattern = regexp.MustCompile(`(^|[\s=:'"])(/|~|\.\./|\.\.$)|\$HOME\b|\$\{HOME\}`)
+
+// ModifiesFiles reports whether the command looks like it changes the
+// filesystem, and so is worth testing in a fixture first
+func ModifiesFiles(cmd string) bool {
+	return fileModifyingPattern.MatchString(devNullPattern.ReplaceAllString(cmd, ""))
+}
+
+type FixtureResult struct {
+	// Changes to the tree, one per line: "+ added", "- removed", "~ changed"
+	Diff     []string
+	Output   string
+	ExitCode int
+}
+
+type fileState struct {
+	dir  bool
+	mode fs.FileMode
+	size int64
+}
+
+// TestCmd runs the command in a throwaway copy of dir holding the same names
+// but empty files, and reports how it changed the tree
+func TestCmd(cmd, dir string) (FixtureResult, error) {
+	if outsidePathPattern.MatchString(strings.ReplaceAll(cmd, "/dev/null", "")) {
+		return FixtureResult{}, &UnsafeTestError{Cmd: cmd}
+	}
+
+	fixture, err := os.MkdirTemp("", "cfor-fixture-")
+	if err != nil {
+		return FixtureResult{}, err
+	}
+	defer os.RemoveAll(fixture)
+
+	if err := buildFixture(dir, fixture); err != nil {
+		return FixtureResult{}, err
+	}
+	before, err := snapshotTree(fixture)
+	if err != nil {
+		return FixtureResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fixtureTimeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, userShell(), "-c", cmd)
+	c.Dir = fixture
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+
+	result := FixtureResult{}
+	err = c.Run()
+	if exitErr := (&exec.ExitError{}); errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return FixtureResult{}, err
+	}
+	result.Output = output.String()
+
+	after, err := snapshotTree(fixture)
+	if err != nil {
+		return FixtureResult{}, err
+	}
+	result.Diff = diffTrees(before, after)
+	return result, nil
+}
+
+// buildFixture recreates the directory structure of src under dst with empty
+// files of the same names and permissions. Symlinks become empty files so the
+// command can't follow them out of the fixture.
+func buildFixture(src, dst string) error {
+	entries := 0
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are left out rather than failing the test
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path == src {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+
+		entries++
+		if entries > maxFixtureEntries {
+			return fs.SkipAll
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			// Keep directories writable so their contents can be created
+			return os.Mkdir(target, info.Mode().Perm()|0o700)
+		}
+		return os.WriteFile(target, nil, info.Mode().Perm()|0o600)
+	})
+}
+
+func snapshotTree(root string) (map[string]fileState, error) {
+	tree := map[string]fileState{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		tree[rel] = fileState{dir: d.IsDir(), mode: info.Mode().Perm(), size: info.Size()}
+		return nil
+	})
+	return tree, err
+}
+
+func diffTrees(before, after map[string]fileState) []string {
+	var diff []string
+	for path, old := range before {
+		current, ok := after[path]
+		switch {
+		case !ok:
+			diff = append(diff, "- "+displayPath(path, old))
+		case old.dir != current.dir:
+			diff = append(diff, "~ "+displayPath(path, current)+" (replaced)")
+		case old.mode != current.mode:
+			diff = append(diff, fmt.Sprintf("~ %s (%s → %s)", displayPath(path, current), old.mode, current.mode))
+		case !current.dir && old.size != current.size:
+			diff = append(diff, "~ "+displayPath(path, current)+" (written)")
+		}
+	}
+	for path, state := range after {
+		if _, ok := before[path]; !ok {
+			diff = append(diff, "+ "+displayPath(path, state))
+		}
+	}
+
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i][2:] < diff[j][2:]
+	})
+	return diff
+}
+
+func displayPath(path string, state fileState) string {
+	if state.dir {
+		return path + string(filepath.Separator)
+	}
+	return path
+}
+
+// PrintFixtureResult shows what the command did to the fixture
+func PrintFixtureResult(result FixtureResult) {
+	fmt.Println("Tested in a copy of the current directory (names only, empty files):")
+	if len(result.Diff) == 0 {
+		fmt.Println("  no files changed")
+	}
+	for _, line := range result.Diff {
+		fmt.Printf("  %s\n", line)
+	}
+
+	if output := strings.TrimSpace(result.Output); output != "" {
+		fmt.Println("Output:")
+		lines := strings.Split(output, "\n")
+		for _, line := range lines[:min(len(lines), maxFixtureOutputLines)] {
+			fmt.Printf("  %s\n", line)
+		}
+		if len(lines) > maxFixtureOutputLines {
+			fmt.Printf("  ... %d more lines\n", len(lines)-maxFixtureOutputLines)
+		}
+	}
+	if result.ExitCode != 0 {
+		fmt.Printf("Exited with status %d\n", result.ExitCode)
+	}
+}
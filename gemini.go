@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+	"github.com/openai/openai-go"
+)
+
+const geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta/"
+
+const (
+	GeminiModel15Flash openai.ChatModel = "gemini-1.5-flash"
+	GeminiModel15Pro   openai.ChatModel = "gemini-1.5-pro"
+)
+
+// https://ai.google.dev/pricing, for prompts up to 128k tokens
+const (
+	// Gemini 1.5 Flash
+	GeminiModel15FlashInputCostPerToken       Cost = 0.075 * 1e-6
+	GeminiModel15FlashCachedInputCostPerToken Cost = 0.01875 * 1e-6
+	GeminiModel15FlashOutputCostPerToken      Cost = 0.30 * 1e-6
+	// Gemini 1.5 Pro
+	GeminiModel15ProInputCostPerToken       Cost = 1.25 * 1e-6
+	GeminiModel15ProCachedInputCostPerToken Cost = 0.3125 * 1e-6
+	GeminiModel15ProOutputCostPerToken      Cost = 5.00 * 1e-6
+)
+
+var GeminiModelCosts = map[openai.ChatModel]CostPerToken{
+	GeminiModel15Flash: {
+		Input:       GeminiModel15FlashInputCostPerToken,
+		CachedInput: GeminiModel15FlashCachedInputCostPerToken,
+		Output:      GeminiModel15FlashOutputCostPerToken,
+	},
+	GeminiModel15Pro: {
+		Input:       GeminiModel15ProInputCostPerToken,
+		CachedInput: GeminiModel15ProCachedInputCostPerToken,
+		Output:      GeminiModel15ProOutputCostPerToken,
+	},
+}
+
+// Both models accept images as input
+var GeminiSupportedModels = []openai.ChatModel{
+	GeminiModel15Flash,
+	GeminiModel15Pro,
+}
+
+// geminiAPIKey returns the key set through CFOR_GEMINI_API_KEY, which takes
+// precedence, or GEMINI_API_KEY
+func geminiAPIKey() string {
+	if key := os.Getenv("CFOR_GEMINI_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("GEMINI_API_KEY")
+}
+
+// geminiProvider sends requests to the Gemini API's generateContent method.
+// Structured output uses its response schema, which is a subset of OpenAPI
+// rather than JSON Schema, so the schema is converted first.
+type geminiProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newGeminiProvider() (Provider, error) {
+	apiKey, err := apiKeyOrCommand(ProviderGemini, geminiAPIKey())
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, &APIKeyMissingError{Provider: ProviderGemini}
+	}
+
+	// CFOR_GEMINI_BASE_URL points at a gateway in front of the API
+	baseURL := os.Getenv("CFOR_GEMINI_BASE_URL")
+	if baseURL == "" {
+		baseURL = geminiBaseURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	return geminiProvider{apiKey: apiKey, baseURL: baseURL, client: newHTTPClient()}, nil
+}
+
+type geminiRequest struct {
+	SystemInstruction geminiContent          `json:"systemInstruction"`
+	Contents          []geminiContent        `json:"contents"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiPart is a part of a message: text or an image
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      float64       `json:"temperature"`
+	Seed             *int64        `json:"seed,omitempty"`
+	MaxOutputTokens  int64         `json:"maxOutputTokens"`
+	ResponseMimeType string        `json:"responseMimeType"`
+	ResponseSchema   *geminiSchema `json:"responseSchema"`
+}
+
+// geminiSchema is the OpenAPI subset the Gemini API accepts as a response
+// schema
+type geminiSchema struct {
+	Type        string                   `json:"type,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Enum        []any                    `json:"enum,omitempty"`
+	Items       *geminiSchema            `json:"items,omitempty"`
+	Properties  map[string]*geminiSchema `json:"properties,omitempty"`
+	Required    []string                 `json:"required,omitempty"`
+	// The properties are generated in this order, as they are declared,
+	// rather than alphabetically
+	PropertyOrdering []string `json:"propertyOrdering,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount        int64 `json:"promptTokenCount"`
+		CandidatesTokenCount    int64 `json:"candidatesTokenCount"`
+		CachedContentTokenCount int64 `json:"cachedContentTokenCount"`
+		TotalTokenCount         int64 `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	ResponseID string `json:"responseId"`
+}
+
+type geminiErrorResponse struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p geminiProvider) Complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error) {
+	parts, err := geminiUserParts(prompt, opts.Images)
+	if err != nil {
+		return Completion{}, err
+	}
+	responseSchema, err := toGeminiSchema(schema.Schema.Value)
+	if err != nil {
+		return Completion{}, err
+	}
+
+	body, err := json.Marshal(geminiRequest{
+		SystemInstruction: geminiContent{Parts: []geminiPart{{Text: systemPrompt}}},
+		Contents:          []geminiContent{{Role: "user", Parts: parts}},
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:      opts.Temperature,
+			Seed:             opts.Seed,
+			MaxOutputTokens:  limit,
+			ResponseMimeType: "application/json",
+			ResponseSchema:   responseSchema,
+		},
+	})
+	if err != nil {
+		return Completion{}, err
+	}
+
+	// The key goes in a header rather than the query string, so that it
+	// isn't logged with the URL
+	header := http.Header{}
+	header.Set("x-goog-api-key", p.apiKey)
+	httpResp, data, err := postJSON(ctx, p.client, p.baseURL+"models/"+model+":generateContent", header, body)
+	if err != nil {
+		return Completion{}, &OpenAIRequestError{Provider: "Gemini", Err: err}
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr geminiErrorResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			err = fmt.Errorf("%s: %s: %s", httpResp.Status, apiErr.Error.Status, apiErr.Error.Message)
+		} else {
+			err = fmt.Errorf("%s", httpResp.Status)
+		}
+		return Completion{}, &OpenAIRequestError{Provider: "Gemini", Err: err, Status: httpResp.StatusCode, RetryAfter: retryAfter(httpResp.Header)}
+	}
+
+	var resp geminiResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Completion{}, &JSONParseError{Err: err}
+	}
+	if len(resp.Candidates) == 0 {
+		err := fmt.Errorf("no response (blocked: %s)", resp.PromptFeedback.BlockReason)
+		return Completion{}, &OpenAIRequestError{Provider: "Gemini", Err: err, RequestID: resp.ResponseID}
+	}
+
+	candidate := resp.Candidates[0]
+	completion := Completion{
+		Usage: openai.CompletionUsage{
+			PromptTokens:        resp.UsageMetadata.PromptTokenCount,
+			CompletionTokens:    resp.UsageMetadata.CandidatesTokenCount,
+			TotalTokens:         resp.UsageMetadata.TotalTokenCount,
+			PromptTokensDetails: openai.CompletionUsagePromptTokensDetails{CachedTokens: resp.UsageMetadata.CachedContentTokenCount},
+		},
+		Truncated: candidate.FinishReason == "MAX_TOKENS",
+		RequestID: resp.ResponseID,
+	}
+	for _, part := range candidate.Content.Parts {
+		completion.Content += part.Text
+	}
+	return completion, nil
+}
+
+// toGeminiSchema converts a schema from GenerateSchema, keeping only what the
+// Gemini API accepts: additionalProperties and $schema are rejected, and the
+// types are enum names
+func toGeminiSchema(value any) (*geminiSchema, error) {
+	schema, ok := value.(*jsonschema.Schema)
+	if !ok {
+		return nil, fmt.Errorf("unsupported response schema %T", value)
+	}
+	return convertGeminiSchema(schema), nil
+}
+
+func convertGeminiSchema(schema *jsonschema.Schema) *geminiSchema {
+	converted := &geminiSchema{
+		Type:        strings.ToUpper(schema.Type),
+		Description: schema.Description,
+		Enum:        schema.Enum,
+		Required:    schema.Required,
+	}
+	if schema.Items != nil {
+		converted.Items = convertGeminiSchema(schema.Items)
+	}
+	if schema.Properties != nil {
+		converted.Properties = map[string]*geminiSchema{}
+		for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			converted.Properties[pair.Key] = convertGeminiSchema(pair.Value)
+			converted.PropertyOrdering = append(converted.PropertyOrdering, pair.Key)
+		}
+	}
+	return converted
+}
+
+// geminiUserParts builds the parts of the user message, attaching any images
+// inline
+func geminiUserParts(prompt string, images []string) ([]geminiPart, error) {
+	parts := []geminiPart{{Text: prompt}}
+	for _, path := range images {
+		url, err := imageDataURL(path)
+		if err != nil {
+			return nil, err
+		}
+		mimeType, data, _ := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+		parts = append(parts, geminiPart{InlineData: &geminiInlineData{MimeType: mimeType, Data: data}})
+	}
+	return parts, nil
+}
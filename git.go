@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const gitPrompt = `For the **%s** operating system and the **%s** shell, suggest the exact git
+commands for the next step in this repository. Use the actual branch names, file
+names and operation in progress below, not placeholders.
+
+%s
+
+The user asks: %s`
+
+// Asked when cfor git is run without a question
+const defaultGitQuestion = "what should I do next?"
+
+// Lines of git status sent along, so huge working trees don't blow up the prompt
+const maxGitStatusLines = 40
+
+// GitState is the state of a repository that generic git questions lack
+type GitState struct {
+	Branch string
+	// The commit HEAD points at when it isn't on a branch
+	DetachedAt string
+	// The operation in progress, e.g. "rebase" or "merge"
+	Operation string
+	// For a rebase, the branch being rebased and onto what
+	RebaseHead string
+	RebaseOnto string
+	Conflicts  []string
+	Status     []string
+}
+
+// ReadGitState inspects the repository containing the working directory
+func ReadGitState() (GitState, error) {
+	var state GitState
+
+	gitDir, err := git("rev-parse", "--git-dir")
+	if err != nil {
+		return state, err
+	}
+
+	if branch, err := git("symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		state.Branch = branch
+	} else if commit, err := git("log", "-1", "--format=%h %s"); err == nil {
+		state.DetachedAt = commit
+	}
+
+	for _, op := range []struct{ path, name string }{
+		{"rebase-merge", "rebase"},
+		{"rebase-apply", "rebase"},
+		{"MERGE_HEAD", "merge"},
+		{"CHERRY_PICK_HEAD", "cherry-pick"},
+		{"REVERT_HEAD", "revert"},
+		{"BISECT_LOG", "bisect"},
+	} {
+		if _, err := os.Stat(filepath.Join(gitDir, op.path)); err != nil {
+			continue
+		}
+		state.Operation = op.name
+		if op.name == "rebase" {
+			state.RebaseHead = readGitFile(filepath.Join(gitDir, op.path, "head-name"))
+			state.RebaseHead = strings.TrimPrefix(state.RebaseHead, "refs/heads/")
+			if onto := readGitFile(filepath.Join(gitDir, op.path, "onto")); onto != "" {
+				state.RebaseOnto, _ = git("log", "-1", "--format=%h %s", onto)
+			}
+		}
+		break
+	}
+
+	if conflicts, err := git("diff", "--name-only", "--diff-filter=U"); err == nil && conflicts != "" {
+		state.Conflicts = strings.Split(conflicts, "\n")
+	}
+
+	if status, err := git("status", "--short", "--branch"); err == nil && status != "" {
+		state.Status = strings.Split(status, "\n")
+	}
+
+	return state, nil
+}
+
+// Describe renders the state for the prompt and for the user to check
+func (s GitState) Describe() string {
+	lines := []string{}
+	if s.Branch != "" {
+		lines = append(lines, "On branch: "+s.Branch)
+	} else if s.DetachedAt != "" {
+		lines = append(lines, "Detached HEAD at: "+s.DetachedAt)
+	}
+
+	switch {
+	case s.Operation == "rebase" && s.RebaseHead != "":
+		lines = append(lines, fmt.Sprintf("In progress: rebase of %s onto %s", s.RebaseHead, s.RebaseOnto))
+	case s.Operation != "":
+		lines = append(lines, "In progress: "+s.Operation)
+	}
+
+	if len(s.Conflicts) > 0 {
+		lines = append(lines, "Conflicted files: "+strings.Join(s.Conflicts, ", "))
+	}
+
+	if len(s.Status) > 0 {
+		status := s.Status
+		if len(status) > maxGitStatusLines {
+			status = append(status[:maxGitStatusLines:maxGitStatusLines], fmt.Sprintf("... and %d more", len(s.Status)-maxGitStatusLines))
+		}
+		lines = append(lines, "git status --short --branch:", strings.Join(status, "\n"))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// SuggestGitCmds asks for the next git commands given the repository's state
+func SuggestGitCmds(state GitState, question string) (ChatResult[Cmds], error) {
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	prompt := fmt.Sprintf(gitPrompt, runtime.GOOS, currentShell(), wrapData("repository state", state.Describe()), question)
+	result, err := chatCmds(context.Background(), model, prompt, DefaultGenerateOptions())
+	result.Message.Cmds = FlagSmuggledCmds(result.Message.Cmds, prompt, question)
+	return result, err
+}
+
+// git runs a git command and returns its trimmed output
+func git(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func readGitFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GuideQuestion is a clarifying question asked before generating a command
+// for a tool whose flags are easy to get wrong
+type GuideQuestion struct {
+	Label       string
+	Placeholder string
+}
+
+var guideQuestions = map[string][]GuideQuestion{
+	"ffmpeg": {
+		{Label: "Input file", Placeholder: "input.mov"},
+		{Label: "Output file", Placeholder: "output.mp4"},
+		{Label: "Video codec", Placeholder: "h264, h265, copy"},
+		{Label: "Audio", Placeholder: "keep, drop, re-encode to aac"},
+		{Label: "Trim or resize", Placeholder: "00:01:00-00:02:30, 720p"},
+	},
+	"rsync": {
+		{Label: "Source", Placeholder: "~/photos/"},
+		{Label: "Destination", Placeholder: "user@host:/backup/photos"},
+		{Label: "Preserve permissions and times?", Placeholder: "yes"},
+		{Label: "Delete files missing from the source?", Placeholder: "no"},
+		{Label: "Exclude patterns", Placeholder: "*.tmp, node_modules"},
+	},
+	"tar": {
+		{Label: "Create or extract?", Placeholder: "create"},
+		{Label: "Files or archive", Placeholder: "src/ or backup.tar.gz"},
+		{Label: "Compression", Placeholder: "gzip, xz, zstd, none"},
+		{Label: "Destination", Placeholder: "backup.tar.gz or ./out"},
+	},
+}
+
+// GuidedTools lists the tools with clarifying questions, sorted
+func GuidedTools() []string {
+	tools := make([]string, 0, len(guideQuestions))
+	for tool := range guideQuestions {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	return tools
+}
+
+// GuidedTool returns the first tool mentioned in the question that has
+// clarifying questions, or "" if there is none
+func GuidedTool(question string) string {
+	for _, word := range strings.Fields(strings.ToLower(question)) {
+		word = strings.Trim(word, ".,;:!?'\"`()")
+		if _, ok := guideQuestions[word]; ok {
+			return word
+		}
+	}
+	return ""
+}
+
+// GuidedQuestion appends the answered clarifying questions to the question,
+// skipping the ones left blank
+func GuidedQuestion(question string, questions []GuideQuestion, answers []string) string {
+	var details []string
+	for i, answer := range answers {
+		answer = strings.TrimSpace(answer)
+		if answer == "" || i >= len(questions) {
+			continue
+		}
+		details = append(details, fmt.Sprintf("- %s: %s", strings.TrimSuffix(questions[i].Label, "?"), answer))
+	}
+	if len(details) == 0 {
+		return question
+	}
+	return question + "\n\nDetails:\n" + strings.Join(details, "\n")
+}
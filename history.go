@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is a question asked through cfor along with its outcome
+type HistoryEntry struct {
+	ID        string     `json:"id"`
+	Time      time.Time  `json:"time"`
+	Question  string     `json:"question"`
+	Model     string     `json:"model,omitempty"`
+	RequestID string     `json:"request_id,omitempty"`
+	Tokens    int64      `json:"tokens,omitempty"`
+	Cached    bool       `json:"cached,omitempty"`
+	Cmds      []CmdEntry `json:"cmds,omitempty"`
+	Selected  string     `json:"selected,omitempty"`
+	// The prompt and options sent, so the request can be replayed
+	Prompt  string           `json:"prompt,omitempty"`
+	Options *GenerateOptions `json:"options,omitempty"`
+}
+
+func historyFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "history.jsonl")
+}
+
+func newHistoryID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AppendHistory records an entry, assigning it an ID and timestamp, unless its
+// request was already recorded
+func AppendHistory(entry HistoryEntry) error {
+	historyPath := historyFilepath()
+	if historyPath == "" {
+		return fmt.Errorf("could not determine history file path")
+	}
+
+	if entry.RequestID != "" && recordedRequest(historyPath, entry.RequestID) {
+		logVerbose("request %s already recorded in the history", entry.RequestID)
+		return nil
+	}
+	if entry.ID == "" {
+		entry.ID = newHistoryID()
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+
+	file, err := os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	return nil
+}
+
+// FindHistoryEntry returns the entry with the given ID
+func FindHistoryEntry(history []HistoryEntry, id string) (HistoryEntry, bool) {
+	for _, entry := range history {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+// GetHistory returns all recorded entries, oldest first
+func GetHistory() ([]HistoryEntry, error) {
+	historyPath := historyFilepath()
+	if historyPath == "" {
+		return nil, fmt.Errorf("could not determine history file path")
+	}
+
+	data, err := os.ReadFile(historyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []HistoryEntry{}, nil
+		}
+		return nil, err
+	}
+
+	entries := []HistoryEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var entry HistoryEntry
+		// Skip lines left partially written by an interrupted process
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// CompleteQuestion returns the previously asked questions matching the input,
+// most recent first: those starting with it, then those containing its
+// characters in order (fuzzy match)
+func CompleteQuestion(history []HistoryEntry, input string) []string {
+	input = strings.ToLower(input)
+
+	seen := map[string]bool{}
+	prefixMatches := []string{}
+	fuzzyMatches := []string{}
+	for _, entry := range slices.Backward(history) {
+		question := entry.Question
+		if seen[question] {
+			continue
+		}
+		seen[question] = true
+
+		lower := strings.ToLower(question)
+		if strings.HasPrefix(lower, input) {
+			prefixMatches = append(prefixMatches, question)
+		} else if isSubsequence(input, lower) {
+			fuzzyMatches = append(fuzzyMatches, question)
+		}
+	}
+
+	return append(prefixMatches, fuzzyMatches...)
+}
+
+// MostAskedQuestions returns up to limit questions asked more than once, the
+// most asked first
+func MostAskedQuestions(history []HistoryEntry, limit int) []string {
+	counts := map[string]int{}
+	questions := []string{}
+	for _, entry := range history {
+		if counts[entry.Question] == 0 {
+			questions = append(questions, entry.Question)
+		}
+		counts[entry.Question]++
+	}
+
+	slices.SortStableFunc(questions, func(a, b string) int {
+		return counts[b] - counts[a]
+	})
+	mostAsked := []string{}
+	for _, question := range questions {
+		if len(mostAsked) == limit || counts[question] < 2 {
+			break
+		}
+		mostAsked = append(mostAsked, question)
+	}
+	return mostAsked
+}
+
+// Label shown next to commands selected before for the same question
+const historyBadge = "[history]"
+
+// Commands selected before for the same question offered again, at most
+const maxHistoryCmds = 2
+
+// HistoryCmds returns the commands selected before for the same question,
+// most recent first
+func HistoryCmds(history []HistoryEntry, question string) []CmdEntry {
+	cmds := []CmdEntry{}
+	seen := map[string]bool{}
+	for _, entry := range slices.Backward(history) {
+		if len(cmds) == maxHistoryCmds {
+			break
+		}
+		if entry.Selected == "" || seen[entry.Selected] || !strings.EqualFold(strings.TrimSpace(entry.Question), strings.TrimSpace(question)) {
+			continue
+		}
+		seen[entry.Selected] = true
+		cmds = append(cmds, CmdEntry{
+			Cmd:     entry.Selected,
+			Comment: "selected " + entry.Time.Format("2006-01-02"),
+			Source:  historyBadge,
+		})
+	}
+	return cmds
+}
+
+// SearchHistory returns the entries whose question or selected command
+// contains the query, most recent first
+func SearchHistory(history []HistoryEntry, query string, limit int) []HistoryEntry {
+	query = strings.ToLower(query)
+
+	matches := []HistoryEntry{}
+	for _, entry := range slices.Backward(history) {
+		if len(matches) == limit {
+			break
+		}
+		if strings.Contains(strings.ToLower(entry.Question), query) ||
+			strings.Contains(strings.ToLower(entry.Selected), query) {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+func isSubsequence(needle, haystack string) bool {
+	remaining := []rune(needle)
+	for _, r := range haystack {
+		if len(remaining) == 0 {
+			break
+		}
+		if r == remaining[0] {
+			remaining = remaining[1:]
+		}
+	}
+	return len(remaining) == 0
+}
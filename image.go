@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/openai/openai-go"
+)
+
+// Image formats accepted by the vision models
+var supportedImageTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+}
+
+// imageDataURL reads an image file and encodes it as a data URL, so that it
+// can be sent inline with the question
+func imageDataURL(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", ImageError{Path: path, Err: err}
+	}
+	if size := float64(info.Size()) / 1e6; size > maxImageSize() {
+		return "", ImageError{Path: path, Err: fmt.Errorf("%.1f MB is over the limit of %g MB set by max_image_size", size, maxImageSize())}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", ImageError{Path: path, Err: err}
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !slices.Contains(supportedImageTypes, mimeType) {
+		return "", ImageError{Path: path, Err: fmt.Errorf("unsupported image type %s", mimeType)}
+	}
+
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// userMessage builds the user message for a prompt, attaching any images as
+// additional content parts
+func userMessage(prompt string, images []string) (openai.ChatCompletionMessageParamUnion, error) {
+	if len(images) == 0 {
+		return openai.UserMessage(prompt), nil
+	}
+
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextPart(prompt)}
+	for _, path := range images {
+		url, err := imageDataURL(path)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, openai.ImagePart(url))
+	}
+	return openai.UserMessageParts(parts...), nil
+}
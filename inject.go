@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Times the injection is retried after the command didn't fully arrive
+const injectRetries = 2
+
+// Bracketed paste markers, telling the shell the text was pasted rather
+// than typed so newlines don't run it
+const (
+	pasteStart = "\033[200~"
+	pasteEnd   = "\033[201~"
+)
+
+// Shells whose line editors understand bracketed paste
+var bracketedPasteShells = map[string]bool{"bash": true, "zsh": true, "fish": true}
+
+// bracketedPaste reports whether the command should be inserted as pasted
+func bracketedPaste(cmd string, config Config) bool {
+	if config.BracketedPaste != nil {
+		return *config.BracketedPaste
+	}
+	return strings.Contains(cmd, "\n") && bracketedPasteShells[currentShell()]
+}
+
+// insertCmd puts the command in the user's prompt. When the terminal doesn't
+// accept it, the command is copied to the clipboard and printed instead, so
+// it's never lost.
+func insertCmd(cmd string) {
+	// A broken config shouldn't lose the selected command
+	config, _ := LoadConfig()
+
+	if err := PushRing(cmd); err != nil {
+		logVerbose("failed to update the ring: %v", err)
+	}
+
+	input := cmd
+	if bracketedPaste(cmd, config) {
+		input = pasteStart + cmd + pasteEnd
+	}
+
+	err := injectToPrompt(input, config.InjectDelay)
+	if err == nil {
+		markInsertedCmd()
+		return
+	}
+	logVerbose("%v", err)
+
+	copyToClipboard(cmd)
+	fmt.Println("Could not insert the command into your prompt; it was copied to the clipboard:")
+	fmt.Printf("  %s\n", cmd)
+}
+
+// injectToPrompt pushes the command into the terminal's input queue, where
+// the shell reads it as if typed once cfor exits. Pushing can silently drop
+// characters under load, so the queue is measured afterwards; an incomplete
+// command is flushed and pushed again. A delay paces the characters for
+// terminals that can't keep up.
+func injectToPrompt(cmd string, delay time.Duration) error {
+	var getTermios, setTermios, fionread uint
+	var tiocsti, sysIoctl uintptr
+	var flushInput func(fd int) error
+
+	switch runtime.GOOS {
+	case "linux":
+		getTermios = 0x5401 // unix.TCGETS
+		setTermios = 0x5402 // unix.TCSETS
+		fionread = 0x541b   // unix.FIONREAD
+		tiocsti = 0x5412    // syscall.TIOCSTI
+		sysIoctl = 16       // syscall.SYS_IOCTL
+		flushInput = func(fd int) error {
+			return unix.IoctlSetInt(fd, 0x540b, 0) // unix.TCFLSH, unix.TCIFLUSH
+		}
+	case "darwin":
+		getTermios = 0x40487413 // unix.TIOCGETA
+		setTermios = 0x80487414 // unix.TIOCSETA
+		fionread = 0x4004667f   // unix.FIONREAD
+		tiocsti = 0x80017472    // syscall.TIOCSTI
+		sysIoctl = 54           // syscall.SYS_IOCTL
+		flushInput = func(fd int) error {
+			return unix.IoctlSetPointerInt(fd, 0x80047410, 1) // unix.TIOCFLUSH, FREAD
+		}
+	}
+
+	fd := int(os.Stdin.Fd())
+
+	// Get the current terminal settings
+	termios, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		return fmt.Errorf("failed to get terminal settings: %w", err)
+	}
+
+	// Save original settings to restore later
+	originalTermios := *termios
+
+	// Disable echo, and canonical mode so that the queue's length includes
+	// the unfinished line being pushed
+	termios.Lflag &^= unix.ECHO | unix.ICANON
+	if err := unix.IoctlSetTermios(fd, setTermios, termios); err != nil {
+		return fmt.Errorf("failed to disable terminal echo: %w", err)
+	}
+
+	err = nil
+	for attempt := 0; attempt <= injectRetries; attempt++ {
+		if attempt > 0 {
+			logVerbose("command only partially injected, retrying")
+			// Anything typed ahead is dropped along with the partial command
+			if err = flushInput(fd); err != nil {
+				err = fmt.Errorf("failed to flush terminal input: %w", err)
+				break
+			}
+		}
+
+		before, beforeErr := unix.IoctlGetInt(fd, fionread)
+		var pushed int
+		if pushed, err = pushChars(cmd, delay, sysIoctl, tiocsti); err != nil {
+			break
+		}
+		after, afterErr := unix.IoctlGetInt(fd, fionread)
+
+		// Terminals that can't report their queue are trusted
+		if beforeErr != nil || afterErr != nil || after-before == pushed {
+			break
+		}
+		err = fmt.Errorf("only %d of %d characters of the command arrived", after-before, pushed)
+	}
+
+	// Don't leave half a command behind for the shell
+	if err != nil {
+		flushInput(fd)
+	}
+
+	// Restore original terminal settings
+	if restoreErr := unix.IoctlSetTermios(fd, setTermios, &originalTermios); restoreErr != nil && err == nil {
+		err = fmt.Errorf("failed to restore terminal settings: %w", restoreErr)
+	}
+
+	return err
+}
+
+// pushChars pushes the command into the input queue one byte at a time, as
+// TIOCSTI takes a single byte, and returns how many were pushed. The delay
+// goes between characters so multi-byte ones arrive whole.
+func pushChars(cmd string, delay time.Duration, sysIoctl, tiocsti uintptr) (int, error) {
+	pushed := 0
+	for i := 0; i < len(cmd); {
+		if i > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+
+		_, size := utf8.DecodeRuneInString(cmd[i:])
+		for ; size > 0; size-- {
+			b := cmd[i]
+			_, _, err := syscall.Syscall(
+				sysIoctl,
+				os.Stdin.Fd(),
+				tiocsti,
+				uintptr(unsafe.Pointer(&b)),
+			)
+			if err != 0 {
+				return pushed, InjectError{Byte: b}
+			}
+			pushed++
+			i++
+		}
+	}
+	return pushed, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The keychain item holding each provider's API key, named by the provider
+const keychainService = "cfor"
+
+// The keychain may wait on being unlocked, e.g. with the login password,
+// before giving up the key
+const keychainTimeout = time.Minute
+
+// Keys read from the keychain, by provider, looked up once per process
+var (
+	keychainAPIKeysMu sync.Mutex
+	keychainAPIKeys   = map[string]string{}
+)
+
+// keychainTool returns the tool cfor reads and stores keys with: security for
+// the macOS Keychain, or secret-tool for libsecret, e.g. GNOME Keyring or
+// KWallet, on Linux. It returns false when there's none.
+func keychainTool() (string, bool) {
+	tool := ""
+	switch runtime.GOOS {
+	case "darwin":
+		tool = "security"
+	case "linux":
+		tool = "secret-tool"
+	default:
+		return "", false
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return "", false
+	}
+	return tool, true
+}
+
+// keychainAPIKey returns the provider's API key stored by `cfor auth set`, or
+// an empty key when there's none or no keychain
+func keychainAPIKey(provider string) string {
+	tool, ok := keychainTool()
+	if !ok {
+		return ""
+	}
+
+	keychainAPIKeysMu.Lock()
+	defer keychainAPIKeysMu.Unlock()
+	if key, ok := keychainAPIKeys[provider]; ok {
+		return key
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if tool == "security" {
+		cmd = exec.CommandContext(ctx, tool, "find-generic-password", "-s", keychainService, "-a", provider, "-w")
+	} else {
+		cmd = exec.CommandContext(ctx, tool, "lookup", "service", keychainService, "account", provider)
+	}
+	output, err := cmd.Output()
+	if err != nil {
+		// Also when there's no key stored, which both tools exit with 1 for
+		logVerbose("no %s API key read from the keychain: %v", provider, err)
+	}
+	key := strings.TrimSpace(string(output))
+	if key != "" {
+		logVerbose("read the %s API key from the keychain", provider)
+	}
+	keychainAPIKeys[provider] = key
+	return key
+}
+
+// StoreKeychainAPIKey stores the provider's API key in the keychain, replacing
+// any stored before. The key is passed on stdin, never as an argument, which
+// other users could see in the process list.
+func StoreKeychainAPIKey(provider, key string) error {
+	tool, ok := keychainTool()
+	if !ok {
+		return errors.New("no keychain found, which needs the macOS Keychain or libsecret's secret-tool")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if tool == "security" {
+		// security splits the commands it reads with -i on spaces itself
+		if strings.ContainsAny(key, " \t\n'\"\\") {
+			return errors.New("the key has spaces or quotes, which no API key has")
+		}
+		cmd = exec.CommandContext(ctx, tool, "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", keychainService, provider, key))
+	} else {
+		cmd = exec.CommandContext(ctx, tool, "store", "--label", "cfor "+provider+" API key", "service", keychainService, "account", provider)
+		cmd.Stdin = strings.NewReader(key)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%w: %s", err, message)
+		}
+		return err
+	}
+
+	keychainAPIKeysMu.Lock()
+	keychainAPIKeys[provider] = key
+	keychainAPIKeysMu.Unlock()
+	return nil
+}
+
+// keychainName names the keychain keys are stored in, for messages
+func keychainName() string {
+	if runtime.GOOS == "darwin" {
+		return "the macOS Keychain"
+	}
+	if tool, ok := keychainTool(); ok && tool == "secret-tool" {
+		return "the keyring"
+	}
+	return "the keychain"
+}
+
+// openAIAPIKey returns the OpenAI API key, looked up in the order newClient
+// documents. It returns an empty key when none is set.
+func openAIAPIKey() (string, error) {
+	key, err := apiKeyOrCommand(ProviderOpenAI, envAPIKey())
+	if err != nil || key != "" {
+		return key, err
+	}
+	if key := keychainAPIKey(ProviderOpenAI); key != "" {
+		return key, nil
+	}
+	return storedAPIKey(), nil
+}
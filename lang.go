@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Scripts used by a single (major) language
+var scriptLanguages = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "Japanese"},
+	{unicode.Katakana, "Japanese"},
+	{unicode.Hangul, "Korean"},
+	{unicode.Han, "Chinese"},
+	{unicode.Cyrillic, "Russian"},
+	{unicode.Greek, "Greek"},
+	{unicode.Arabic, "Arabic"},
+	{unicode.Hebrew, "Hebrew"},
+	{unicode.Thai, "Thai"},
+	{unicode.Devanagari, "Hindi"},
+}
+
+// Frequent words telling apart languages written in the Latin script
+var languageStopWords = map[string][]string{
+	"English":    {"the", "a", "an", "of", "in", "to", "and", "for", "with", "all", "from", "how", "my", "on", "files", "file"},
+	"Spanish":    {"el", "la", "los", "las", "de", "del", "en", "y", "para", "con", "un", "una", "que", "todos", "archivos", "cómo", "como", "mis"},
+	"French":     {"le", "la", "les", "de", "des", "du", "en", "et", "pour", "avec", "un", "une", "que", "tous", "fichiers", "comment", "mes"},
+	"German":     {"der", "die", "das", "den", "und", "mit", "für", "ein", "eine", "alle", "dateien", "wie", "im", "von", "meine"},
+	"Portuguese": {"o", "os", "as", "de", "do", "da", "em", "e", "para", "com", "um", "uma", "todos", "arquivos", "como", "meus"},
+	"Italian":    {"il", "lo", "gli", "di", "del", "della", "in", "e", "per", "con", "un", "una", "tutti", "file", "come", "miei"},
+	"Dutch":      {"de", "het", "een", "en", "van", "voor", "met", "alle", "bestanden", "hoe", "mijn", "in", "op"},
+}
+
+// DetectLanguage guesses the language a question is written in, returning ""
+// for English or when unsure
+func DetectLanguage(text string) string {
+	// Scripts are checked in order, so that Japanese text mixing kana and
+	// kanji isn't taken for Chinese
+	for _, script := range scriptLanguages {
+		for _, r := range text {
+			if unicode.Is(script.table, r) {
+				return script.language
+			}
+		}
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	scores := map[string]int{}
+	for language, stopWords := range languageStopWords {
+		for _, word := range words {
+			for _, stopWord := range stopWords {
+				if word == stopWord {
+					scores[language]++
+				}
+			}
+		}
+	}
+
+	best, bestScore := "", 0
+	for language, score := range scores {
+		if score > bestScore || (score == bestScore && language == "English") {
+			best, bestScore = language, score
+		}
+	}
+
+	// A single shared word ("de", "in") isn't enough to switch languages
+	if best == "English" || bestScore < 2 || scores[best] == scores["English"] {
+		return ""
+	}
+	return best
+}
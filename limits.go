@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// RateLimits are the remaining requests and tokens per minute for a model, as
+// reported by the provider's x-ratelimit-* headers
+type RateLimits struct {
+	LimitRequests     int64
+	RemainingRequests int64
+	ResetRequests     time.Duration
+	LimitTokens       int64
+	RemainingTokens   int64
+	ResetTokens       time.Duration
+}
+
+func rateLimitsFromHeaders(header http.Header) RateLimits {
+	count := func(name string) int64 {
+		n, _ := strconv.ParseInt(header.Get(name), 10, 64)
+		return n
+	}
+	// Reset times come as durations such as "6m0s" or "20ms"
+	reset := func(name string) time.Duration {
+		d, _ := time.ParseDuration(header.Get(name))
+		return d
+	}
+
+	return RateLimits{
+		LimitRequests:     count("x-ratelimit-limit-requests"),
+		RemainingRequests: count("x-ratelimit-remaining-requests"),
+		ResetRequests:     reset("x-ratelimit-reset-requests"),
+		LimitTokens:       count("x-ratelimit-limit-tokens"),
+		RemainingTokens:   count("x-ratelimit-remaining-tokens"),
+		ResetTokens:       reset("x-ratelimit-reset-tokens"),
+	}
+}
+
+// GetRateLimits sends the smallest possible completion request to read the
+// rate-limit headers for the configured model. The headers reflect the whole
+// key, so teammates sharing it show up in the remaining counts.
+func GetRateLimits() (ChatResult[RateLimits], error) {
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[RateLimits]{}, err
+	}
+
+	client, err := sharedClient()
+	if err != nil {
+		return ChatResult[RateLimits]{}, err
+	}
+
+	if _, err := checkPolicy(); err != nil {
+		return ChatResult[RateLimits]{}, err
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Model: openai.F(model),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage("ping"),
+		}),
+	}
+	setTokenLimit(&params, 1)
+
+	// The client's own timeout is lifted, as it is for completions, so that a
+	// longer one for the model applies
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout(providerName(), model))
+	defer cancel()
+	var httpResp *http.Response
+	resp, err := client.Chat.Completions.New(ctx, params, option.WithRequestTimeout(0), option.WithResponseInto(&httpResp))
+	if err != nil {
+		id := requestID(httpResp)
+		if apiErr := (&openai.Error{}); errors.As(err, &apiErr) {
+			if id == "" {
+				id = requestID(apiErr.Response)
+			}
+			// Hitting the limit still reports it
+			if apiErr.StatusCode == http.StatusTooManyRequests && apiErr.Response != nil {
+				return ChatResult[RateLimits]{
+					Message:   rateLimitsFromHeaders(apiErr.Response.Header),
+					Model:     model,
+					RequestID: id,
+				}, nil
+			}
+		}
+		return ChatResult[RateLimits]{}, &OpenAIRequestError{Err: err, RequestID: id}
+	}
+
+	return ChatResult[RateLimits]{
+		Message:   rateLimitsFromHeaders(httpResp.Header),
+		Cost:      EstimateCost(model, resp.Usage),
+		Model:     model,
+		RequestID: requestID(httpResp),
+		Tokens:    resp.Usage.TotalTokens,
+	}, nil
+}
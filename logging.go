@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openai/openai-go/option"
+)
+
+// Set by --verbose to log request details to stderr and the log file
+var verbose bool
+
+func logFilepath() string {
+	dir := stateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "cfor.log")
+}
+
+func logVerbose(format string, args ...any) {
+	if !verbose {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[cfor] "+format+"\n", args...)
+
+	// The log file keeps the details around for reporting issues later
+	path := logFilepath()
+	if path == "" || os.MkdirAll(filepath.Dir(path), 0755) != nil {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s "+format+"\n", append([]any{time.Now().Format(time.RFC3339)}, args...)...)
+}
+
+// Headers carrying the provider's ID of a request, to reference it when
+// reporting API-side issues to the provider's support
+var requestIDHeaders = []string{"x-request-id", "request-id", "x-amzn-requestid"}
+
+func requestID(resp *http.Response) string {
+	if resp == nil {
+		return ""
+	}
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// loggingMiddleware logs each request attempt with its outcome and request ID
+func loggingMiddleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	started := time.Now()
+	logVerbose("%s %s", req.Method, req.URL)
+
+	resp, err := next(req)
+	if err != nil {
+		logVerbose("request failed after %s: %v", time.Since(started).Round(time.Millisecond), err)
+		return resp, err
+	}
+
+	logVerbose("%s after %s (request ID: %s)", resp.Status, time.Since(started).Round(time.Millisecond), requestID(resp))
+	return resp, err
+}
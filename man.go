@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Man pages of large tools (e.g. ffmpeg, bash) are truncated to keep the
+// request within a reasonable token budget
+const maxManPageLength = 32000
+
+const manPrompt = `Below is the manual of the **%s** tool on the **%s** operating system.
+Summarize it as the 10 most useful, practical invocations of the tool, each with
+a concrete example command and a very short inline comment. Only use options
+documented in the manual.
+
+%s`
+
+func manCacheFilepath(tool string) string {
+	dir := cacheDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "man", filepath.Base(tool)+".json")
+}
+
+// SummarizeManPage returns the most useful invocations of a tool, summarized
+// from its local man page. Summaries are cached per tool unless refresh is set.
+func SummarizeManPage(tool string, refresh bool, opts GenerateOptions) (ChatResult[Cmds], error) {
+	cachePath := manCacheFilepath(tool)
+	if !refresh && cachePath != "" {
+		if cached, err := readManCache(cachePath); err == nil {
+			return ChatResult[Cmds]{Message: cached}, nil
+		}
+	}
+
+	manual, err := readManPage(tool)
+	if err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	prompt := fmt.Sprintf(manPrompt, tool, runtime.GOOS, wrapData("manual", manual))
+	result, err := chatCmds(context.Background(), model, prompt, opts)
+	if err != nil {
+		return result, err
+	}
+
+	if cachePath != "" {
+		writeManCache(cachePath, result.Message)
+	}
+
+	return result, nil
+}
+
+// Overstrike sequences (bold/underline) and ANSI escapes used by man formatters
+var manFormattingPattern = regexp.MustCompile(`.\x08|\x1b\[[0-9;]*m`)
+
+// readManPage returns the plain text manual of a tool, truncated to keep the
+// request small
+func readManPage(tool string) (string, error) {
+	manual, err := toolManual(context.Background(), tool)
+	if err != nil {
+		return "", err
+	}
+
+	if len(manual) > maxManPageLength {
+		manual = manual[:maxManPageLength]
+	}
+	return manual, nil
+}
+
+// toolManual returns the plain text manual of a tool, falling back to its
+// --help output when no man page is installed
+func toolManual(ctx context.Context, tool string) (string, error) {
+	cmd := exec.CommandContext(ctx, "man", tool)
+	cmd.Env = append(os.Environ(), "MANPAGER=cat", "PAGER=cat", "MANWIDTH=100")
+	out, err := cmd.Output()
+	if err != nil || len(strings.TrimSpace(string(out))) == 0 {
+		out, _ = exec.CommandContext(ctx, tool, "--help").CombinedOutput()
+		if len(strings.TrimSpace(string(out))) == 0 {
+			return "", ManPageNotFoundError{Tool: tool}
+		}
+	}
+
+	return manFormattingPattern.ReplaceAllString(string(out), ""), nil
+}
+
+func readManCache(path string) (Cmds, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Cmds{}, err
+	}
+
+	var cmds Cmds
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		return Cmds{}, err
+	}
+	return cmds, nil
+}
+
+func writeManCache(path string, cmds Cmds) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(cmds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal man summary: %w", err)
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
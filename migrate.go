@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Files kept in the data directory that used to live elsewhere
+var migratedDataFiles = []string{"cost.json", "cost_log.jsonl", "history.jsonl", "metrics.jsonl", "ring.json", "snippets.json", "transcript.jsonl"}
+
+// legacyDataDir is the default data directory, where data was left behind if
+// XDG_DATA_HOME was set after cfor had been used
+func legacyDataDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".local", "share", "cfor")
+}
+
+// Migration moves one file into its XDG directory
+type Migration struct {
+	From string
+	To   string
+	// How the file is combined with an existing one at To
+	Merge func(from, to string) error
+}
+
+// PlanMigrations lists the files to move into their XDG directories: data
+// left in the default data directory, and state once kept with the data
+func PlanMigrations() []Migration {
+	dataDir := filepath.Dir(costFilepath())
+	migrations := []Migration{}
+
+	if legacy := legacyDataDir(); legacy != "" && legacy != dataDir {
+		for _, name := range migratedDataFiles {
+			from := filepath.Join(legacy, name)
+			if _, err := os.Stat(from); err != nil {
+				continue
+			}
+			migration := Migration{From: from, To: filepath.Join(dataDir, name)}
+			switch filepath.Ext(name) {
+			case ".jsonl":
+				migration.Merge = appendFile
+			case ".json":
+				if name == "cost.json" {
+					migration.Merge = mergeCostFiles
+				}
+			}
+			migrations = append(migrations, migration)
+		}
+	}
+
+	from := filepath.Join(dataDir, "summary_shown")
+	if _, err := os.Stat(from); err == nil {
+		migrations = append(migrations, Migration{From: from, To: summaryShownFilepath()})
+	}
+
+	return migrations
+}
+
+// Migrate moves the file, combining it with an existing one when it can. A
+// file that can't be combined is left where it is.
+func (m Migration) Migrate() error {
+	if err := os.MkdirAll(filepath.Dir(m.To), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	if _, err := os.Stat(m.To); errors.Is(err, os.ErrNotExist) {
+		return os.Rename(m.From, m.To)
+	}
+	if m.Merge == nil {
+		return fmt.Errorf("%s already exists", m.To)
+	}
+	if err := m.Merge(m.From, m.To); err != nil {
+		return err
+	}
+	return os.Remove(m.From)
+}
+
+// mergeCostFiles adds up the daily costs of both files
+func mergeCostFiles(from, to string) error {
+	legacy, err := ReadCosts(from)
+	if err != nil {
+		return err
+	}
+	costs, err := ReadCosts(to)
+	if err != nil {
+		return err
+	}
+	for day, cost := range legacy {
+		costs[day] += cost
+	}
+	return writeCosts(costs)
+}
+
+// appendFile appends the lines of a JSON lines file to another
+func appendFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/openai/openai-go"
+)
+
+// Label of the models served by an OpenAI-compatible server, which are listed
+// apart from OpenAI's own
+const compatibleProviderLabel = "compatible"
+
+// ModelListing is a model cfor can ask, with what it costs
+type ModelListing struct {
+	Provider string
+	Model    openai.ChatModel
+	Pricing  CostPerToken
+	// Whether the pricing is known, which it isn't for most models of
+	// OpenAI-compatible servers
+	Priced bool
+	// Whether it's the model asked by default
+	Current bool
+}
+
+// builtinProviderModels returns the models of the providers whose models cfor
+// knows without asking
+func builtinProviderModels() map[string][]openai.ChatModel {
+	return map[string][]openai.ChatModel{
+		ProviderOpenAI:    OpenAISupportedModels,
+		ProviderAnthropic: AnthropicSupportedModels,
+		ProviderGemini:    GeminiSupportedModels,
+		ProviderBedrock:   BedrockSupportedModels,
+	}
+}
+
+// Providers in the order their models are listed
+var modelListingProviders = []string{ProviderOpenAI, ProviderAnthropic, ProviderGemini, ProviderBedrock, ProviderOpenRouter, ProviderOllama, compatibleProviderLabel}
+
+// ListModels returns the models of the provider, or of every provider when
+// none is given. The built-in models of OpenAI, Anthropic, Gemini and Bedrock
+// are always listed; those of Ollama and OpenRouter, which are looked up on
+// the server, only when asked for or when it's the configured provider, and
+// those of an OpenAI-compatible server when one is configured. The model
+// selected and those priced in the config file or the bundle are listed under
+// the configured provider.
+func ListModels(provider string) []ModelListing {
+	current, _ := openAIModel()
+	configured := providerName()
+	if compatibleServer() {
+		configured = compatibleProviderLabel
+	}
+
+	listings := []ModelListing{}
+	add := func(provider string, models []openai.ChatModel) {
+		for _, model := range models {
+			if slices.ContainsFunc(listings, func(l ModelListing) bool { return l.Provider == provider && l.Model == model }) {
+				continue
+			}
+			pricingProvider := provider
+			if provider == compatibleProviderLabel {
+				pricingProvider = ProviderOpenAI
+			}
+			pricing, priced := providerModelPricing(pricingProvider, model)
+			listings = append(listings, ModelListing{
+				Provider: provider,
+				Model:    model,
+				Pricing:  pricing,
+				Priced:   priced,
+				Current:  provider == configured && model == current,
+			})
+		}
+	}
+
+	builtin := builtinProviderModels()
+	for _, name := range modelListingProviders {
+		if provider != "" && provider != name {
+			continue
+		}
+		switch {
+		case builtin[name] != nil:
+			add(name, builtin[name])
+		case name == ProviderOpenRouter && (provider == name || configured == name):
+			add(name, openRouterModelIDs(false))
+		case name == ProviderOllama && (provider == name || configured == name):
+			add(name, ollamaModels())
+		case name == compatibleProviderLabel && configured == name:
+			add(name, compatibleModels())
+		}
+
+		if name == configured {
+			others := []openai.ChatModel{}
+			config, _ := LoadConfig()
+			for model := range config.Pricing {
+				others = append(others, model)
+			}
+			bundle, _ := LoadBundle()
+			for model := range bundle.Pricing {
+				others = append(others, model)
+			}
+			if current != "" {
+				others = append(others, current)
+			}
+			slices.Sort(others)
+			add(name, others)
+		}
+	}
+	return listings
+}
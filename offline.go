@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// offlineMode reports whether outbound calls other than to the LLM endpoint
+// are disabled, through CFOR_OFFLINE or offline in the config file
+func offlineMode() bool {
+	value := strings.ToLower(os.Getenv("CFOR_OFFLINE"))
+	if value == "1" || value == "true" {
+		return true
+	}
+	config, _ := LoadConfig()
+	return config.Offline
+}
+
+// checkOnline fails when the action needs an endpoint other than the LLM's
+// in offline mode
+func checkOnline(action string) error {
+	if offlineMode() {
+		return &OfflineError{Action: action}
+	}
+	return nil
+}
+
+// openAIBaseURL returns the LLM endpoint: the team server when one is set,
+// otherwise the upstream one, if any. The client resolves paths relative to
+// it, so it always ends in a slash.
+func openAIBaseURL() string {
+	url := upstreamBaseURL()
+	if server := serverURL(); server != "" {
+		url = server + "/v1"
+	}
+	if url != "" && !strings.HasSuffix(url, "/") {
+		url += "/"
+	}
+	return url
+}
+
+// upstreamBaseURL returns the LLM endpoint set through CFOR_OPENAI_BASE_URL or
+// base_url in the config file, such as an internal gateway, if any
+func upstreamBaseURL() string {
+	if url := os.Getenv("CFOR_OPENAI_BASE_URL"); url != "" {
+		return url
+	}
+	config, _ := LoadConfig()
+	return config.BaseURL
+}
+
+// bundleDir returns the directory of the local bundle set through CFOR_BUNDLE
+// or bundle in the config file, if any
+func bundleDir() string {
+	if dir := os.Getenv("CFOR_BUNDLE"); dir != "" {
+		return dir
+	}
+	config, _ := LoadConfig()
+	return config.Bundle
+}
+
+// Bundle is the auxiliary data distributed alongside cfor for networks where
+// it can't be fetched: pricing.yaml, risk.yaml and policy.yaml, all optional
+type Bundle struct {
+	// Prices per model, as under pricing in the config file
+	Pricing map[string]ModelPricing
+	// Risk rules added to the built-in ones
+	RiskRules []riskRule
+	// The org policy to use instead of fetching its URL
+	Policy []byte
+}
+
+type bundleRiskRule struct {
+	Pattern string `yaml:"pattern"`
+	Risk    string `yaml:"risk"`
+}
+
+// LoadBundle reads the bundle once per process. Without a bundle, it's empty.
+var LoadBundle = sync.OnceValues(func() (Bundle, error) {
+	var bundle Bundle
+
+	dir := bundleDir()
+	if dir == "" {
+		return bundle, nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return bundle, fmt.Errorf("bundle %s is not a directory", dir)
+	}
+
+	if data, err := readBundleFile(dir, "pricing.yaml"); err != nil {
+		return bundle, err
+	} else if data != nil {
+		if err := yaml.Unmarshal(data, &bundle.Pricing); err != nil {
+			return bundle, ConfigParseError{Path: filepath.Join(dir, "pricing.yaml"), Err: err}
+		}
+	}
+
+	if data, err := readBundleFile(dir, "risk.yaml"); err != nil {
+		return bundle, err
+	} else if data != nil {
+		path := filepath.Join(dir, "risk.yaml")
+		var rules []bundleRiskRule
+		if err := yaml.Unmarshal(data, &rules); err != nil {
+			return bundle, ConfigParseError{Path: path, Err: err}
+		}
+		for _, rule := range rules {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return bundle, ConfigParseError{Path: path, Err: err}
+			}
+			risk, err := ParseRisk(rule.Risk)
+			if err != nil {
+				return bundle, ConfigParseError{Path: path, Err: err}
+			}
+			bundle.RiskRules = append(bundle.RiskRules, riskRule{pattern: re, risk: risk})
+		}
+	}
+
+	data, err := readBundleFile(dir, "policy.yaml")
+	if err != nil {
+		return bundle, err
+	}
+	bundle.Policy = data
+
+	return bundle, nil
+})
+
+// readBundleFile returns the file's contents, or nil if the bundle lacks it
+func readBundleFile(dir, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle file: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Ollama configuration
+const (
+	defaultOllamaHost = "http://localhost:11434"
+	// Local models are slower than hosted ones, and the first request also
+	// loads the model into memory
+	ollamaTimeout = 5 * time.Minute
+	// Listing the pulled models shouldn't hold up the selector
+	ollamaListTimeout = 2 * time.Second
+)
+
+const OllamaModelLlama31 openai.ChatModel = "llama3.1"
+
+// ollamaHost returns the Ollama server set through OLLAMA_HOST, as for the
+// ollama CLI, or ollama_host in the config file
+func ollamaHost() string {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		config, _ := LoadConfig()
+		host = config.OllamaHost
+	}
+	if host == "" {
+		return defaultOllamaHost
+	}
+	// OLLAMA_HOST is often just host:port
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimSuffix(host, "/")
+}
+
+// newOllamaClient returns a client for Ollama's OpenAI-compatible API, which
+// supports structured outputs, so requests are built as for OpenAI
+func newOllamaClient() *openai.Client {
+	return openai.NewClient(
+		// Ollama ignores the key, but the client requires one
+		option.WithAPIKey(ProviderOllama),
+		option.WithBaseURL(ollamaHost()+"/v1/"),
+		option.WithRequestTimeout(ollamaTimeout),
+		option.WithHTTPClient(newHTTPClient()),
+		option.WithMiddleware(statusMiddleware, loggingMiddleware),
+	)
+}
+
+// ollamaModels returns the models pulled on the Ollama server, or none if it
+// can't be reached
+var ollamaModels = sync.OnceValue(func() []openai.ChatModel {
+	ctx, cancel := context.WithTimeout(context.Background(), ollamaListTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ollamaHost()+"/api/tags", nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		logVerbose("failed to list the Ollama models: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil
+	}
+	models := make([]openai.ChatModel, len(tags.Models))
+	for i, model := range tags.Models {
+		models[i] = model.Name
+	}
+	return models
+})
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+const openRouterBaseURL = "https://openrouter.ai/api/v1/"
+
+const (
+	OpenRouterModelGPT4o          openai.ChatModel = "openai/gpt-4o"
+	OpenRouterModelClaude35Sonnet openai.ChatModel = "anthropic/claude-3.5-sonnet"
+)
+
+// OpenRouter configuration
+const (
+	// Listing the models shouldn't hold up the request
+	openRouterListTimeout = 5 * time.Second
+	// A generation's cost is looked up shortly after it finishes, which may
+	// take OpenRouter a moment to record
+	openRouterGenerationAttempts = 3
+	openRouterGenerationDelay    = 500 * time.Millisecond
+)
+
+// OpenRouterRouting picks the upstream providers that serve a model, under
+// openrouter_routing in the config file. See
+// https://openrouter.ai/docs/features/provider-routing.
+type OpenRouterRouting struct {
+	// Providers tried first, in order, e.g. [anthropic, amazon-bedrock]
+	Order []string `yaml:"order" json:"order,omitempty"`
+	// Whether providers outside of order may serve the request when those in
+	// it are unavailable. On unless false.
+	AllowFallbacks *bool `yaml:"allow_fallbacks" json:"allow_fallbacks,omitempty"`
+	// Providers never used
+	Ignore []string `yaml:"ignore" json:"ignore,omitempty"`
+	// "deny" to skip providers that may store or train on prompts
+	DataCollection string `yaml:"data_collection" json:"data_collection,omitempty"`
+	// Only providers supporting every parameter, the response schema
+	// included, serve the request; always set
+	RequireParameters bool `yaml:"-" json:"require_parameters"`
+}
+
+// openRouterAPIKey returns the key set through CFOR_OPENROUTER_API_KEY, which
+// takes precedence, or OPENROUTER_API_KEY
+func openRouterAPIKey() string {
+	if key := os.Getenv("CFOR_OPENROUTER_API_KEY"); key != "" {
+		return key
+	}
+	return os.Getenv("OPENROUTER_API_KEY")
+}
+
+// openRouterURL returns the API's URL, or that of a gateway in front of it set
+// through CFOR_OPENROUTER_BASE_URL
+func openRouterURL() string {
+	baseURL := os.Getenv("CFOR_OPENROUTER_BASE_URL")
+	if baseURL == "" {
+		baseURL = openRouterBaseURL
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return baseURL
+}
+
+// openRouterProvider sends requests through OpenRouter's OpenAI-compatible
+// API, to any of the models it routes to with a single key. Model names are
+// prefixed with their vendor, e.g. anthropic/claude-3.5-sonnet. Since no
+// static prices cover the hundreds of models, each request's actual cost is
+// looked up afterwards.
+type openRouterProvider struct {
+	openAIProvider
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newOpenRouterProvider() (Provider, error) {
+	apiKey, err := apiKeyOrCommand(ProviderOpenRouter, openRouterAPIKey())
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, &APIKeyMissingError{Provider: ProviderOpenRouter}
+	}
+
+	baseURL := openRouterURL()
+	client := newHTTPClient()
+	return openRouterProvider{
+		openAIProvider: newOpenAIProvider(openai.NewClient(
+			option.WithAPIKey(apiKey),
+			option.WithBaseURL(baseURL),
+			option.WithRequestTimeout(apiTimeout()),
+			option.WithHTTPClient(client),
+			option.WithMiddleware(statusMiddleware, loggingMiddleware),
+			// Shown on OpenRouter's activity page
+			option.WithHeader("X-Title", "cfor"),
+		)),
+		apiKey:  apiKey,
+		baseURL: baseURL,
+		client:  client,
+	}, nil
+}
+
+func (p openRouterProvider) Complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error) {
+	completion, id, err := p.complete(ctx, model, prompt, schema, opts, limit, p.routing(model))
+	return p.withCost(ctx, completion, id, err)
+}
+
+func (p openRouterProvider) CompleteStream(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64, partial func(content string)) (Completion, error) {
+	completion, id, err := p.stream(ctx, model, prompt, schema, opts, limit, partial, p.routing(model))
+	return p.withCost(ctx, completion, id, err)
+}
+
+// routing sends the routing configured for the model along with the request
+func (p openRouterProvider) routing(model openai.ChatModel) option.RequestOption {
+	routing := openRouterRouting(model)
+	routing.RequireParameters = true
+	return option.WithJSONSet("provider", routing)
+}
+
+// withCost sets the actual cost of the completed generation
+func (p openRouterProvider) withCost(ctx context.Context, completion Completion, id string, err error) (Completion, error) {
+	if err != nil {
+		return completion, err
+	}
+	if completion.RequestID == "" {
+		completion.RequestID = id
+	}
+
+	// Without the generation, the cost is estimated from the listed prices
+	if generation, err := p.generation(ctx, id); err != nil {
+		logVerbose("failed to look up the cost of generation %s: %v", id, err)
+	} else {
+		logVerbose("generation %s was served by %s for $%.6f", id, generation.ProviderName, generation.TotalCost)
+		cost := Cost(generation.TotalCost)
+		completion.Cost = &cost
+	}
+	return completion, nil
+}
+
+type openRouterGeneration struct {
+	TotalCost    float64 `json:"total_cost"`
+	ProviderName string  `json:"provider_name"`
+}
+
+// generation looks up what a generation cost
+func (p openRouterProvider) generation(ctx context.Context, id string) (openRouterGeneration, error) {
+	if id == "" {
+		return openRouterGeneration{}, fmt.Errorf("no generation ID")
+	}
+	for attempt := 1; ; attempt++ {
+		generation, status, err := p.generationOnce(ctx, id)
+		// Not found until it's recorded
+		if status != http.StatusNotFound || attempt == openRouterGenerationAttempts {
+			return generation, err
+		}
+		select {
+		case <-ctx.Done():
+			return openRouterGeneration{}, ctx.Err()
+		case <-time.After(openRouterGenerationDelay * time.Duration(attempt)):
+		}
+	}
+}
+
+func (p openRouterProvider) generationOnce(ctx context.Context, id string) (openRouterGeneration, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"generation?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return openRouterGeneration{}, 0, err
+	}
+	req.Header.Set("authorization", "Bearer "+p.apiKey)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return openRouterGeneration{}, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return openRouterGeneration{}, resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+
+	var body struct {
+		Data openRouterGeneration `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return openRouterGeneration{}, resp.StatusCode, err
+	}
+	return body.Data, resp.StatusCode, nil
+}
+
+// openRouterRouting returns the routing configured for the model, if any
+func openRouterRouting(model openai.ChatModel) OpenRouterRouting {
+	config, _ := LoadConfig()
+	return config.OpenRouterRouting[model]
+}
+
+// openRouterModel is a model listed by OpenRouter, with its prices per token
+// as decimal strings
+type openRouterModel struct {
+	ID      string `json:"id"`
+	Pricing struct {
+		Prompt         string `json:"prompt"`
+		Completion     string `json:"completion"`
+		InputCacheRead string `json:"input_cache_read"`
+	} `json:"pricing"`
+	Architecture struct {
+		InputModalities []string `json:"input_modalities"`
+	} `json:"architecture"`
+}
+
+// openRouterModels returns the models OpenRouter lists, or none if it can't
+// be reached
+var openRouterModels = sync.OnceValue(func() []openRouterModel {
+	ctx, cancel := context.WithTimeout(context.Background(), openRouterListTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, openRouterURL()+"models", nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		logVerbose("failed to list the OpenRouter models: %v", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logVerbose("failed to list the OpenRouter models: %s", resp.Status)
+		return nil
+	}
+
+	var body struct {
+		Data []openRouterModel `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		logVerbose("failed to list the OpenRouter models: %v", err)
+		return nil
+	}
+	return body.Data
+})
+
+// openRouterModelIDs returns the models OpenRouter lists, or the default ones
+// if it can't be reached
+func openRouterModelIDs(vision bool) []openai.ChatModel {
+	models := openRouterModels()
+	if len(models) == 0 {
+		return []openai.ChatModel{OpenRouterModelClaude35Sonnet, OpenRouterModelGPT4o}
+	}
+	ids := []openai.ChatModel{}
+	for _, model := range models {
+		if !vision || slices.Contains(model.Architecture.InputModalities, "image") {
+			ids = append(ids, model.ID)
+		}
+	}
+	return ids
+}
+
+// openRouterPricing returns the prices OpenRouter lists for the model, which
+// estimate a request's cost before it's sent, or when its generation can't be
+// looked up
+func openRouterPricing(model openai.ChatModel) (CostPerToken, bool) {
+	for _, listed := range openRouterModels() {
+		if listed.ID != model {
+			continue
+		}
+		input, err := strconv.ParseFloat(listed.Pricing.Prompt, 64)
+		if err != nil {
+			return CostPerToken{}, false
+		}
+		output, err := strconv.ParseFloat(listed.Pricing.Completion, 64)
+		if err != nil {
+			return CostPerToken{}, false
+		}
+		// Not every model lists a price for cached tokens
+		cachedInput, _ := strconv.ParseFloat(listed.Pricing.InputCacheRead, 64)
+		return CostPerToken{Input: Cost(input), CachedInput: Cost(cachedInput), Output: Cost(output)}, true
+	}
+	return CostPerToken{}, false
+}
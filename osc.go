@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// Operating system commands understood by terminals such as WezTerm, kitty,
+// iTerm2 and Windows Terminal. Terminals without support ignore them.
+const (
+	// Save and restore the window title, so cfor doesn't leave its own behind
+	pushTitle = "\033[22;0t"
+	popTitle  = "\033[23;0t"
+	// OSC 0 sets the window title
+	titleFormat = "\033]0;%s\a"
+	// OSC 9;4 shows progress, here as indeterminate, or clears it
+	progressBusy  = "\033]9;4;3\a"
+	progressClear = "\033]9;4;0\a"
+	// OSC 133;A marks the start of a prompt, which terminals let you jump to
+	promptMark = "\033]133;A\a"
+)
+
+// terminalIntegration reports whether the escape sequences should be written
+func terminalIntegration() bool {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return false
+	}
+	config, _ := LoadConfig()
+	return config.TerminalIntegration == nil || *config.TerminalIntegration
+}
+
+// startTerminalProgress saves the title and shows that cfor is busy
+func startTerminalProgress() {
+	if terminalIntegration() {
+		fmt.Print(pushTitle + progressBusy)
+	}
+}
+
+// setTerminalTitle shows the stage of the request in the title
+func setTerminalTitle(title string) {
+	if terminalIntegration() {
+		fmt.Printf(titleFormat, title)
+	}
+}
+
+// stopTerminalProgress clears the progress and restores the title
+func stopTerminalProgress() {
+	if terminalIntegration() {
+		fmt.Print(progressClear + popTitle)
+	}
+}
+
+// markInsertedCmd marks the prompt the inserted command will appear at, so
+// that it can be navigated to like any other prompt
+func markInsertedCmd() {
+	if terminalIntegration() {
+		fmt.Print(promptMark)
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrintPlain writes the suggestions one per line with their comments
+// aligned, suitable for piping into other tools
+func PrintPlain(cmds []CmdEntry) {
+	for _, line := range commentCmds(cmds) {
+		fmt.Println(line)
+	}
+}
+
+// PrintJSON writes the suggestions as a JSON document
+func PrintJSON(cmds []CmdEntry) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(Cmds{Cmds: cmds})
+}
+
+// Set by --json to also report errors as JSON on stderr
+var jsonErrors bool
+
+// PrintJSONError writes the error as a JSON document on stderr, keeping
+// stdout for the suggestions
+func PrintJSONError(err error) {
+	encoder := json.NewEncoder(os.Stderr)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(struct {
+		Error ErrorDetail `json:"error"`
+	}{DescribeError(err)})
+}
+
+// PrintDryRun writes the prompt that would be sent along with its estimated
+// size, without contacting the API
+func PrintDryRun(prompt Prompt) {
+	tokens := EstimateInputTokens(prompt)
+	pricing, _ := modelPricing(prompt.Model)
+	cost := float64(pricing.Input) * float64(tokens)
+
+	fmt.Println("--- system ---")
+	fmt.Println(prompt.System)
+	fmt.Println("--- user ---")
+	fmt.Println(prompt.User)
+	for _, image := range prompt.Images {
+		fmt.Printf("[image: %s]\n", image)
+	}
+	fmt.Println("--- estimate ---")
+	fmt.Printf("Model:        %s\n", prompt.Model)
+	fmt.Printf("Input tokens: ~%d\n", tokens)
+	fmt.Printf("Input cost:   ~$%.5f\n", cost)
+}
+
+// PrintCosts writes the cost breakdown as a plain text table
+func PrintCosts(costs Costs) {
+	dates := make([]string, 0, len(costs))
+	var totalCost float64
+	for date, cost := range costs {
+		dates = append(dates, string(date))
+		totalCost += float64(cost)
+	}
+	sort.Strings(dates)
+
+	fmt.Printf("%-15s %-15s\n", "Date", "Cost ($)")
+	for _, date := range dates {
+		fmt.Printf("%-15s %.5f\n", date, costs[Today(date)])
+	}
+	fmt.Printf("%-15s %.5f\n", "TOTAL", totalCost)
+}
+
+// PrintCostBreakdown writes the costs grouped by profile or project as a
+// plain text table, most expensive first
+func PrintCostBreakdown(title string, totals map[string]Cost) {
+	keys := make([]string, 0, len(totals))
+	width := len(title)
+	var totalCost Cost
+	for key, cost := range totals {
+		keys = append(keys, key)
+		width = max(width, len(key))
+		totalCost += cost
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return totals[keys[i]] > totals[keys[j]]
+	})
+
+	fmt.Printf("%-*s  %s\n", width, title, "Cost ($)")
+	for _, key := range keys {
+		fmt.Printf("%-*s  %.5f\n", width, key, totals[key])
+	}
+	fmt.Printf("%-*s  %.5f\n", width, "TOTAL", totalCost)
+}
+
+// PrintModels writes the models with their prices per million tokens as a
+// plain text table, marking the one asked by default
+func PrintModels(listings []ModelListing) {
+	providerWidth, modelWidth := len("Provider"), len("Model")
+	for _, listing := range listings {
+		providerWidth = max(providerWidth, len(listing.Provider))
+		modelWidth = max(modelWidth, len(listing.Model))
+	}
+
+	fmt.Printf("%-*s  %-*s  %10s  %10s  %10s\n", providerWidth, "Provider", modelWidth+2, "  Model", "Input ($)", "Cached ($)", "Output ($)")
+	for _, listing := range listings {
+		marker := "  "
+		if listing.Current {
+			marker = "* "
+		}
+		input, cached, output := "-", "-", "-"
+		if listing.Priced {
+			input = formatPricePerMillion(listing.Pricing.Input)
+			cached = formatPricePerMillion(listing.Pricing.CachedInput)
+			output = formatPricePerMillion(listing.Pricing.Output)
+		}
+		fmt.Printf("%-*s  %s%-*s  %10s  %10s  %10s\n", providerWidth, listing.Provider, marker, modelWidth, listing.Model, input, cached, output)
+	}
+	fmt.Println("\nPrices are per 1M tokens; * marks the model asked by default.")
+}
+
+// formatPricePerMillion writes the cost per token as the price of a million
+// tokens, with as many decimals as it has, between two and five
+func formatPricePerMillion(cost Cost) string {
+	price := strconv.FormatFloat(float64(cost)*1e6, 'f', 5, 64)
+	price = strings.TrimRight(price, "0")
+	if i := strings.IndexByte(price, '.'); len(price)-i < 3 {
+		price += strings.Repeat("0", 3-(len(price)-i))
+	}
+	return price
+}
+
+// PrintCostDrift compares the local cost estimates with the costs billed by
+// the provider, day by day
+func PrintCostDrift(local, billed Costs, since time.Time) {
+	fmt.Printf("%-10s  %10s  %10s  %10s\n", "Date", "Local ($)", "Billed ($)", "Drift ($)")
+
+	var localTotal, billedTotal Cost
+	for day := since; !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		key := Today(day.Format("2006-01-02"))
+		if local[key] == 0 && billed[key] == 0 {
+			continue
+		}
+		localTotal += local[key]
+		billedTotal += billed[key]
+		fmt.Printf("%-10s  %10.5f  %10.5f  %+10.5f\n", key, local[key], billed[key], billed[key]-local[key])
+	}
+
+	fmt.Printf("%-10s  %10.5f  %10.5f  %+10.5f", "TOTAL", localTotal, billedTotal, billedTotal-localTotal)
+	if localTotal > 0 {
+		fmt.Printf("  (%+.1f%%)", float64((billedTotal-localTotal)/localTotal)*100)
+	}
+	fmt.Println()
+}
+
+// WriteBatchJSON writes the batch results as a JSON array
+func WriteBatchJSON(w io.Writer, results []BatchResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// WriteBatchMarkdown writes the batch results as a cheatsheet, one section
+// per question
+func WriteBatchMarkdown(w io.Writer, results []BatchResult) {
+	for _, result := range results {
+		fmt.Fprintf(w, "## %s\n\n", result.Question)
+		if result.Error != "" {
+			fmt.Fprintf(w, "_No answer: %s_\n\n", result.Error)
+			continue
+		}
+		for _, cmd := range result.Cmds {
+			fmt.Fprintf(w, "```sh\n%s\n```\n\n", cmd.Cmd)
+			if cmd.Comment != "" {
+				fmt.Fprintf(w, "%s\n\n", cmd.Comment)
+			}
+		}
+	}
+}
+
+// PrintDoctorChecks writes one line per health check, with its timing when
+// it involved a request
+func PrintDoctorChecks(checks []DoctorCheck) {
+	width := 0
+	for _, check := range checks {
+		width = max(width, len(check.Name))
+	}
+
+	for _, check := range checks {
+		status := "ok  "
+		if !check.OK {
+			status = "FAIL"
+		}
+		line := fmt.Sprintf("[%s] %-*s  %s", status, width, check.Name, check.Detail)
+		if check.Duration > 0 {
+			line += fmt.Sprintf(" (%s)", check.Duration.Round(time.Millisecond))
+		}
+		fmt.Println(line)
+	}
+}
+
+// PrintHistory writes the recorded questions, oldest first
+func PrintHistory(entries []HistoryEntry) {
+	for _, entry := range entries {
+		fmt.Printf("%s  %s  %s\n", entry.ID, entry.Time.Format("2006-01-02 15:04"), entry.Question)
+		if entry.Selected != "" {
+			fmt.Printf("%s  %s\n", strings.Repeat(" ", len(entry.ID)+len("2006-01-02 15:04")+2), entry.Selected)
+		}
+	}
+}
+
+// PrintCmdsDiff writes the comparison of two sets of suggestions like a
+// unified diff, with the comments of the commands
+func PrintCmdsDiff(diff []CmdsDiff) {
+	cmds := make([]CmdEntry, len(diff))
+	for i, line := range diff {
+		cmds[i] = line.Cmd
+	}
+	for i, line := range commentCmds(cmds) {
+		fmt.Printf("%s %s\n", diff[i].Op, line)
+	}
+}
+
+// PrintEvalReport writes each variant's scores per question and in total.
+// Validity is left out when shellcheck wasn't run.
+func PrintEvalReport(suite EvalSuite, scores [][]EvalScore, shellcheck bool) {
+	width := len("Total")
+	for _, question := range suite.Questions {
+		width = max(width, len(question.Question))
+	}
+
+	cell := func(score EvalScore) string {
+		if score.Err != nil {
+			return "error"
+		}
+		text := fmt.Sprintf("expected %d/%d", score.Expected, score.Suggestions)
+		if shellcheck {
+			text = fmt.Sprintf("valid %d/%d  ", score.Valid, score.Suggestions) + text
+		}
+		return text
+	}
+
+	header := fmt.Sprintf("%-*s", width, "Question")
+	for _, variant := range suite.Variants {
+		header += fmt.Sprintf("  %-28s", variant.Name)
+	}
+	fmt.Println(strings.TrimRight(header, " "))
+
+	totals := make([]EvalScore, len(suite.Variants))
+	for i, question := range suite.Questions {
+		line := fmt.Sprintf("%-*s", width, question.Question)
+		for j, score := range scores[i] {
+			line += fmt.Sprintf("  %-28s", cell(score))
+			totals[j].Suggestions += score.Suggestions
+			totals[j].Valid += score.Valid
+			totals[j].Expected += score.Expected
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+
+	line := fmt.Sprintf("%-*s", width, "Total")
+	for _, total := range totals {
+		line += fmt.Sprintf("  %-28s", cell(total))
+	}
+	fmt.Println(strings.TrimRight(line, " "))
+
+	printedErrors := false
+	for i, question := range suite.Questions {
+		for j, score := range scores[i] {
+			if score.Err == nil {
+				continue
+			}
+			if !printedErrors {
+				fmt.Println()
+				printedErrors = true
+			}
+			fmt.Printf("%s / %s: %v\n", suite.Variants[j].Name, question.Question, score.Err)
+		}
+	}
+}
+
+// PrintWhy writes what a command that was run did and its side effects
+func PrintWhy(cmd string, why WhyExplanation) {
+	fmt.Printf("$ %s\n\n%s\n\n", cmd, why.Summary)
+
+	width := 0
+	for _, part := range why.Parts {
+		width = max(width, len(part.Part))
+	}
+	for _, part := range why.Parts {
+		fmt.Printf("  %-*s  %s\n", width, part.Part, part.Description)
+	}
+
+	if len(why.SideEffects) > 0 {
+		fmt.Println("\nLikely side effects:")
+		for _, effect := range why.SideEffects {
+			fmt.Printf("  - %s\n", effect)
+		}
+	}
+	if why.Undo != "" {
+		fmt.Printf("\nTo undo: %s\n", why.Undo)
+	}
+}
+
+// PrintToolMigration writes the rewritten script followed by the behavioral
+// differences of each replacement and the tools that would be missing
+func PrintToolMigration(migration ToolMigration, missing []string) {
+	fmt.Println(strings.TrimRight(migration.Script, "\n"))
+
+	if len(migration.Differences) > 0 {
+		fmt.Println("\nBehavioral differences:")
+		for _, d := range migration.Differences {
+			fmt.Printf("  %s -> %s: %s\n", d.Legacy, d.Modern, d.Difference)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("\nWarning: not installed here: %s\n", strings.Join(missing, ", "))
+	}
+}
+
+// PrintStats writes the usage analytics as plain text
+func PrintStats(stats HistoryStats) {
+	for _, line := range statsLines(stats) {
+		fmt.Println(line)
+	}
+}
+
+// statsLines renders the usage analytics, one line per figure
+func statsLines(stats HistoryStats) []string {
+	topics := make([]string, len(stats.Topics))
+	for i, topic := range stats.Topics {
+		topics[i] = fmt.Sprintf("%s (%d)", topic.Topic, topic.Count)
+	}
+
+	return []string{
+		fmt.Sprintf("Questions asked:       %d", stats.Questions),
+		fmt.Sprintf("Most-asked topics:     %s", strings.Join(topics, ", ")),
+		fmt.Sprintf("Busiest hour:          %02d:00", busiestHour(stats.Hours)),
+		fmt.Sprintf("Questions per hour:    |%s|", hoursSparkline(stats.Hours)),
+		"                        0     6     12    18   23",
+		fmt.Sprintf("Avg tokens/question:   %.0f", stats.AverageTokens),
+		fmt.Sprintf("Cache hit rate:        %.0f%%", stats.CacheHitRate*100),
+		fmt.Sprintf("Acceptance rate:       %.0f%%", stats.AcceptanceRate*100),
+	}
+}
+
+// PrintRateLimits writes the remaining requests and tokens for the model
+func PrintRateLimits(model string, limits RateLimits) {
+	now := time.Now()
+	fmt.Printf("Rate limits for %s:\n", model)
+	fmt.Printf("  Requests/min: %d of %d remaining, resets in %s (at %s)\n",
+		limits.RemainingRequests, limits.LimitRequests, limits.ResetRequests, now.Add(limits.ResetRequests).Format("15:04:05"))
+	fmt.Printf("  Tokens/min:   %d of %d remaining, resets in %s (at %s)\n",
+		limits.RemainingTokens, limits.LimitTokens, limits.ResetTokens, now.Add(limits.ResetTokens).Format("15:04:05"))
+}
+
+// commentCmds renders each command with its tradeoff and inline comment,
+// padding the columns so that they line up
+func commentCmds(cmds []CmdEntry) []string {
+	maxCmdLength := 0
+	maxTradeoffLength := 0
+	for _, entry := range cmds {
+		if len(entry.Cmd) > maxCmdLength {
+			maxCmdLength = len(entry.Cmd)
+		}
+		if len(entry.Tradeoff) > maxTradeoffLength {
+			maxTradeoffLength = len(entry.Tradeoff)
+		}
+	}
+
+	commentedCmds := make([]string, len(cmds))
+	for i, entry := range cmds {
+		line := entry.Cmd
+		if maxTradeoffLength > 0 {
+			padding := strings.Repeat(" ", maxCmdLength-len(entry.Cmd)+2)
+			tradeoff := strings.Repeat(" ", maxTradeoffLength+2)
+			if entry.Tradeoff != "" {
+				tradeoff = fmt.Sprintf("[%-*s]", maxTradeoffLength, entry.Tradeoff)
+			}
+			line += padding + tradeoff
+		}
+
+		comment := strings.TrimSpace(entry.Comment + " " + entry.Source)
+		if entry.Workdir != "" {
+			comment = strings.TrimSpace(comment + fmt.Sprintf(" (run from %s)", entry.Workdir))
+		}
+		for _, warning := range entry.Warnings {
+			comment += fmt.Sprintf(" (⚠ %s)", warning)
+		}
+		if comment != "" {
+			padding := "  "
+			if maxTradeoffLength == 0 {
+				padding = strings.Repeat(" ", maxCmdLength-len(entry.Cmd)+2)
+			}
+			line += fmt.Sprintf("%s# %s", padding, comment)
+		}
+		commentedCmds[i] = line
+	}
+	return commentedCmds
+}
+
+// Label shown next to suggestions answered from the local cache
+const cacheBadge = "[cache]"
+
+// labelSource sets where the suggestions came from, unless already known, so
+// they can be trusted accordingly
+func labelSource(cmds []CmdEntry, source string) []CmdEntry {
+	labeled := make([]CmdEntry, len(cmds))
+	for i, cmd := range cmds {
+		if cmd.Source == "" {
+			cmd.Source = source
+		}
+		labeled[i] = cmd
+	}
+	return labeled
+}
+
+// mergeCmds appends the suggestions of b to a, skipping commands equivalent
+// to ones already in a
+func mergeCmds(a, b []CmdEntry) []CmdEntry {
+	seen := map[string]bool{}
+	merged := []CmdEntry{}
+	for _, entry := range append(append([]CmdEntry{}, a...), b...) {
+		key := normalizeCmd(entry.Cmd)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, entry)
+	}
+	return merged
+}
+
+// PrintMetrics writes the summary of the local metrics over the last days,
+// and whether any of them are shared
+func PrintMetrics(summary MetricsSummary, days int) {
+	fmt.Printf("Last %d days\n\n", days)
+
+	if len(summary.Commands) == 0 {
+		fmt.Println("No commands run yet.")
+	} else {
+		width := len("Command")
+		for _, count := range summary.Commands {
+			width = max(width, len(count.Command))
+		}
+		fmt.Printf("%-*s  %s\n", width, "Command", "Runs")
+		for _, count := range summary.Commands {
+			fmt.Printf("%-*s  %d\n", width, count.Command, count.Count)
+		}
+	}
+	fmt.Println()
+
+	fmt.Printf("%-16s  %d\n", "Requests", summary.Requests)
+	if summary.Requests > 0 {
+		fmt.Printf("%-16s  p50 %s, p95 %s\n", "Latency", summary.LatencyP50.Round(time.Millisecond), summary.LatencyP95.Round(time.Millisecond))
+	}
+	if lookups := summary.Requests + summary.CacheHits; lookups > 0 {
+		fmt.Printf("%-16s  %.0f%% (%d of %d)\n", "Cache hit rate", summary.CacheHitRate()*100, summary.CacheHits, lookups)
+	}
+
+	categories, endpoint := sharedMetricCategories()
+	if len(categories) == 0 {
+		fmt.Printf("%-16s  off\n", "Telemetry")
+		return
+	}
+	sharing := fmt.Sprintf("sharing %s with %s daily", strings.Join(categories, ", "), endpoint)
+	if last, ok := lastMetricsShare(); ok {
+		sharing += ", last on " + last.Format("2006-01-02")
+	}
+	fmt.Printf("%-16s  %s\n", "Telemetry", sharing)
+}
+
+// PrintMetricsReport writes the report as it's posted to the telemetry
+// endpoint
+func PrintMetricsReport(report MetricsReport) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
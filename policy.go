@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Where IT deploys the org policy. Packagers can move it with
+// -ldflags "-X main.policyFilepath=...", but users can't override it.
+var policyFilepath = "/etc/cfor/policy.yaml"
+
+const policyFetchTimeout = 5 * time.Second
+
+// Policy is a read-only org policy that takes precedence over the user's
+// config, environment variables and flags
+type Policy struct {
+	// Fetch the policy from this URL instead, falling back to the last copy
+	// fetched when it can't be reached
+	URL string `yaml:"url"`
+	// Regular expressions for commands that must never be suggested
+	Denylist []string `yaml:"denylist"`
+	// Spend caps in USD, checked against the local cost tracking
+	DailySpendCap   float64 `yaml:"daily_spend_cap"`
+	MonthlySpendCap float64 `yaml:"monthly_spend_cap"`
+	// Models users may select with CFOR_OPENAI_MODEL; any supported model when
+	// empty
+	ApprovedModels []string `yaml:"approved_models"`
+	// Regular expressions for text replaced with [REDACTED] before anything is
+	// sent to the provider
+	Redact []string `yaml:"redact"`
+
+	denylist []*regexp.Regexp
+	redact   []*regexp.Regexp
+}
+
+// LoadPolicy reads the org policy once per process. Without a policy file
+// nothing is restricted.
+var LoadPolicy = sync.OnceValues(func() (Policy, error) {
+	var policy Policy
+
+	data, err := os.ReadFile(policyFilepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+		return policy, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return policy, ConfigParseError{Path: policyFilepath, Err: err}
+	}
+
+	if policy.URL != "" {
+		data, err := fetchPolicy(policy.URL)
+		if err != nil {
+			return policy, err
+		}
+		url := policy.URL
+		policy = Policy{}
+		if err := yaml.Unmarshal(data, &policy); err != nil {
+			return policy, ConfigParseError{Path: url, Err: err}
+		}
+	}
+
+	for _, pattern := range policy.Denylist {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return policy, ConfigParseError{Path: policyFilepath, Err: err}
+		}
+		policy.denylist = append(policy.denylist, re)
+	}
+	for _, pattern := range policy.Redact {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return policy, ConfigParseError{Path: policyFilepath, Err: err}
+		}
+		policy.redact = append(policy.redact, re)
+	}
+
+	return policy, nil
+})
+
+func policyCacheFilepath() string {
+	return filepath.Join(cacheDir(), "policy.yaml")
+}
+
+// fetchPolicy downloads the policy and keeps a copy, so a flaky network
+// doesn't lift the restrictions. A policy in the bundle is used instead, and
+// in offline mode only the kept copy is.
+func fetchPolicy(url string) ([]byte, error) {
+	if bundle, _ := LoadBundle(); bundle.Policy != nil {
+		return bundle.Policy, nil
+	}
+	if offlineMode() {
+		cached, err := os.ReadFile(policyCacheFilepath())
+		if err != nil {
+			return nil, &OfflineError{Action: "Fetching the policy from " + url}
+		}
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), policyFetchTimeout)
+	defer cancel()
+
+	data, err := func() ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := newHTTPClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}()
+	if err != nil {
+		cached, cacheErr := os.ReadFile(policyCacheFilepath())
+		if cacheErr != nil {
+			return nil, fmt.Errorf("failed to fetch policy from %s: %w", url, err)
+		}
+		logVerbose("using the cached policy, fetching %s failed: %v", url, err)
+		return cached, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(policyCacheFilepath()), 0755); err == nil {
+		os.WriteFile(policyCacheFilepath(), data, 0644)
+	}
+	return data, nil
+}
+
+// ApprovesModel reports whether users may select the model
+func (p Policy) ApprovesModel(model string) bool {
+	return len(p.ApprovedModels) == 0 || slices.Contains(p.ApprovedModels, model)
+}
+
+// Denies reports whether the command matches the denylist
+func (p Policy) Denies(cmd string) bool {
+	for _, re := range p.denylist {
+		if re.MatchString(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactText replaces every match of the redaction patterns
+func (p Policy) RedactText(text string) string {
+	for _, re := range p.redact {
+		text = re.ReplaceAllString(text, "[REDACTED]")
+	}
+	return text
+}
+
+// CheckSpend returns an error once today's or this month's tracked spend
+// reaches its cap
+func (p Policy) CheckSpend(costs Costs) error {
+	now := time.Now()
+	today := Today(now.Format("2006-01-02"))
+	month := now.Format("2006-01")
+
+	var daily, monthly float64
+	for day, cost := range costs {
+		if day == today {
+			daily += float64(cost)
+		}
+		if strings.HasPrefix(string(day), month) {
+			monthly += float64(cost)
+		}
+	}
+
+	if p.DailySpendCap > 0 && daily >= p.DailySpendCap {
+		return &SpendCapExceededError{Period: "daily", Cap: p.DailySpendCap}
+	}
+	if p.MonthlySpendCap > 0 && monthly >= p.MonthlySpendCap {
+		return &SpendCapExceededError{Period: "monthly", Cap: p.MonthlySpendCap}
+	}
+	return nil
+}
+
+// checkPolicy enforces the spend caps before a request is sent
+func checkPolicy() (Policy, error) {
+	policy, err := LoadPolicy()
+	if err != nil {
+		return policy, err
+	}
+	if policy.DailySpendCap == 0 && policy.MonthlySpendCap == 0 {
+		return policy, nil
+	}
+
+	costs, err := GetCosts()
+	if err != nil {
+		// Nothing has been spent yet
+		return policy, nil
+	}
+	return policy, policy.CheckSpend(costs)
+}
+
+// FilterDenied drops the suggestions the org policy denies
+func FilterDenied(cmds []CmdEntry) []CmdEntry {
+	policy, err := LoadPolicy()
+	if err != nil {
+		return cmds
+	}
+
+	allowed := []CmdEntry{}
+	for _, cmd := range cmds {
+		if !policy.Denies(cmd.Cmd) {
+			allowed = append(allowed, cmd)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// A post-processor holding up the selector is worse than one skipped
+const postProcessorTimeout = 5 * time.Second
+
+// postProcessorInput is what a post-processor reads on stdin and, with the
+// suggestions changed, prints back
+type postProcessorInput struct {
+	Question string                  `json:"question"`
+	Shell    string                  `json:"shell"`
+	Cmds     []postProcessorCmdEntry `json:"cmds"`
+}
+
+// postProcessorCmdEntry is a suggestion as post-processors see it, with what
+// cfor found checking it, which they may add to
+type postProcessorCmdEntry struct {
+	Cmd      string   `json:"cmd"`
+	Comment  string   `json:"comment"`
+	Tradeoff string   `json:"tradeoff,omitempty"`
+	Workdir  string   `json:"workdir,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+
+	Binary      string   `json:"binary,omitempty"`
+	Args        []string `json:"args,omitempty"`
+	NeedsSudo   bool     `json:"needs_sudo,omitempty"`
+	Destructive bool     `json:"destructive,omitempty"`
+	Platforms   []string `json:"platforms,omitempty"`
+}
+
+// postProcessors returns the commands set through post_processors in the
+// config file
+func postProcessors() []string {
+	config, _ := LoadConfig()
+	commands := []string{}
+	for _, command := range config.PostProcessors {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// PostProcessCmds runs the suggestions through each post-processor in turn,
+// e.g. a script enforcing house rules such as adding --dry-run to terraform
+// apply. One that fails is skipped, and the suggestions are warned that it
+// was.
+func PostProcessCmds(cmds []CmdEntry, question string) []CmdEntry {
+	for _, command := range postProcessors() {
+		processed, err := runPostProcessor(command, cmds, question)
+		if err != nil {
+			logVerbose("post-processor %q failed: %v", command, err)
+			warning := fmt.Sprintf("not post-processed by %s: %v", command, err)
+			for i := range cmds {
+				cmds[i].Warnings = append(cmds[i].Warnings, warning)
+			}
+			continue
+		}
+		cmds = processed
+	}
+	return cmds
+}
+
+// runPostProcessor pipes the suggestions as JSON to the command, run with the
+// shell, and reads back those it prints
+func runPostProcessor(command string, cmds []CmdEntry, question string) ([]CmdEntry, error) {
+	input := postProcessorInput{Question: question, Shell: currentShell(), Cmds: []postProcessorCmdEntry{}}
+	for _, entry := range cmds {
+		input.Cmds = append(input.Cmds, postProcessorCmdEntry{
+			Cmd:      entry.Cmd,
+			Comment:  entry.Comment,
+			Tradeoff: entry.Tradeoff,
+			Workdir:  entry.Workdir,
+			Source:   entry.Source,
+			Warnings: entry.Warnings,
+
+			Binary:      entry.Binary,
+			Args:        entry.Args,
+			NeedsSudo:   entry.NeedsSudo,
+			Destructive: entry.Destructive,
+			Platforms:   entry.Platforms,
+		})
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), postProcessorTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// The first line is usually the reason, e.g. a traceback's last
+		if message, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+
+	var processed postProcessorInput
+	if err := json.Unmarshal(output, &processed); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	result := []CmdEntry{}
+	for _, entry := range processed.Cmds {
+		if strings.TrimSpace(entry.Cmd) == "" {
+			continue
+		}
+		result = append(result, CmdEntry{
+			Cmd:      entry.Cmd,
+			Comment:  entry.Comment,
+			Tradeoff: entry.Tradeoff,
+			Workdir:  entry.Workdir,
+			Source:   entry.Source,
+			Warnings: entry.Warnings,
+
+			Binary:      entry.Binary,
+			Args:        entry.Args,
+			NeedsSudo:   entry.NeedsSudo,
+			Destructive: entry.Destructive,
+			Platforms:   entry.Platforms,
+		})
+	}
+	// Commands rewritten without updating their structure get it derived again
+	return StructureCmds(result), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Resolving a transaction can mean refreshing metadata, but the selector
+// shouldn't wait on it for long
+const packagePreviewTimeout = 15 * time.Second
+
+// Lines of preview output kept, from the end where the summary is
+const maxPackagePreviewLines = 30
+
+// Flags answering the package manager's prompt, dropped from previews
+var assumeYesFlags = []string{"-y", "--yes", "--assumeyes", "--noconfirm", "--non-interactive"}
+
+// packagePreviews maps a package manager and subcommand to the command
+// previewing it without changing anything, given the remaining arguments
+var packagePreviews = map[string]map[string]func(args []string) []string{
+	"apt":     aptPreviews,
+	"apt-get": aptPreviews,
+	"dnf":     assumeNoPreviews("dnf"),
+	"yum":     assumeNoPreviews("yum"),
+	"brew": {
+		"install":   brewInfo,
+		"reinstall": brewInfo,
+		"upgrade":   brewInfo,
+		"uninstall": brewUses,
+		"remove":    brewUses,
+	},
+	"zypper": {
+		"install": zypperDryRun("install"),
+		"in":      zypperDryRun("install"),
+		"remove":  zypperDryRun("remove"),
+		"rm":      zypperDryRun("remove"),
+		"update":  zypperDryRun("update"),
+		"up":      zypperDryRun("update"),
+	},
+	"apk": {
+		"add": apkSimulate("add"),
+		"del": apkSimulate("del"),
+	},
+}
+
+var aptPreviews = map[string]func([]string) []string{
+	"install": aptSimulate("install"),
+	"remove":  aptSimulate("remove"),
+	"purge":   aptSimulate("purge"),
+	"upgrade": aptSimulate("upgrade"),
+}
+
+// assumeNoPreviews resolves dnf and yum transactions and declines them
+func assumeNoPreviews(manager string) map[string]func([]string) []string {
+	assumeNo := func(sub string) func([]string) []string {
+		return func(args []string) []string { return append([]string{manager, sub, "--assumeno"}, args...) }
+	}
+	return map[string]func([]string) []string{
+		"install": assumeNo("install"),
+		"remove":  assumeNo("remove"),
+		"erase":   assumeNo("remove"),
+		"upgrade": assumeNo("upgrade"),
+		"update":  assumeNo("upgrade"),
+	}
+}
+
+func aptSimulate(sub string) func([]string) []string {
+	return func(args []string) []string { return append([]string{"apt-get", "-s", sub}, args...) }
+}
+
+func zypperDryRun(sub string) func([]string) []string {
+	return func(args []string) []string {
+		return append([]string{"zypper", "--non-interactive", sub, "--dry-run"}, args...)
+	}
+}
+
+func apkSimulate(sub string) func([]string) []string {
+	return func(args []string) []string { return append([]string{"apk", sub, "--simulate"}, args...) }
+}
+
+func brewInfo(args []string) []string {
+	return append([]string{"brew", "info"}, args...)
+}
+
+// brewUses lists the installed formulae depending on the ones being removed
+func brewUses(args []string) []string {
+	return append([]string{"brew", "uses", "--installed"}, args...)
+}
+
+// PackagePreviewCmd returns the command previewing the package transaction
+// in the suggestion, if it installs, removes or upgrades packages
+func PackagePreviewCmd(cmd string) ([]string, bool) {
+	for _, segment := range commandSeparatorPattern.Split(cmd, -1) {
+		fields := shellFields(segment)
+		for len(fields) > 0 && (envAssignmentPattern.MatchString(fields[0]) || commandWrappers[fields[0]]) {
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			continue
+		}
+
+		// Pacman's operations are flags: -S installs, -R removes
+		if fields[0] == "pacman" {
+			if op := fields[1]; strings.HasPrefix(op, "-S") || strings.HasPrefix(op, "-R") || strings.HasPrefix(op, "-U") {
+				return append([]string{"pacman", op, "--print"}, withoutAssumeYes(fields[2:])...), true
+			}
+			continue
+		}
+
+		previews, ok := packagePreviews[fields[0]]
+		if !ok {
+			continue
+		}
+		// Options may come before the subcommand, e.g. apt-get -y install
+		args := withoutAssumeYes(fields[1:])
+		for i, arg := range args {
+			if isFlag(arg) {
+				continue
+			}
+			if preview, ok := previews[arg]; ok {
+				return preview(slices.Concat(args[:i], args[i+1:])), true
+			}
+			break
+		}
+	}
+	return nil, false
+}
+
+func withoutAssumeYes(args []string) []string {
+	return slices.DeleteFunc(slices.Clone(args), func(arg string) bool {
+		return slices.Contains(assumeYesFlags, arg)
+	})
+}
+
+// PreviewPackageCmd runs the preview of the suggestion's package transaction
+// and returns its output. Nothing is installed or removed.
+func PreviewPackageCmd(cmd string) (string, error) {
+	args, ok := PackagePreviewCmd(cmd)
+	if !ok {
+		return "", fmt.Errorf("not a package install or removal")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return "", ToolNotInstalledError{Tool: args[0]}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), packagePreviewTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("%s took longer than %s", strings.Join(args, " "), packagePreviewTimeout)
+	}
+	// Declining the transaction, as dnf --assumeno does, exits with an error
+	if exitErr := (&exec.ExitError{}); err != nil && !errors.As(err, &exitErr) {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) > maxPackagePreviewLines {
+		lines = append([]string{"..."}, lines[len(lines)-maxPackagePreviewLines:]...)
+	}
+	return "$ " + strings.Join(args, " ") + "\n" + strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Providers selectable through CFOR_PROVIDER or provider in the config file
+const (
+	ProviderOpenAI     = "openai"
+	ProviderAnthropic  = "anthropic"
+	ProviderOllama     = "ollama"
+	ProviderGemini     = "gemini"
+	ProviderBedrock    = "bedrock"
+	ProviderOpenRouter = "openrouter"
+)
+
+// Provider sends a structured request to an LLM API
+type Provider interface {
+	// Complete asks the model for a response following the schema, using at
+	// most limit output tokens, until the context is done
+	Complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error)
+}
+
+// StreamingProvider is a provider that can also deliver the response as it's
+// generated, so that suggestions are shown as they arrive
+type StreamingProvider interface {
+	Provider
+	// CompleteStream is Complete, calling partial with the content received
+	// so far each time more of it arrives
+	CompleteStream(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64, partial func(content string)) (Completion, error)
+}
+
+// Completion is a provider's response to a structured request, before it's
+// decoded
+type Completion struct {
+	// The JSON object following the schema
+	Content string
+	// Token usage in OpenAI's terms, so that EstimateCost applies to every
+	// provider
+	Usage openai.CompletionUsage
+	// Whether the response was cut off at the token limit
+	Truncated bool
+	RequestID string
+	// The request's actual cost, for providers that report it, rather than
+	// one estimated from the usage
+	Cost *Cost
+}
+
+// providerName returns the provider selected through CFOR_PROVIDER or provider
+// in the config file, OpenAI by default
+func providerName() string {
+	// The team server speaks the OpenAI API, whatever the provider behind it
+	if serverURL() != "" {
+		return ProviderOpenAI
+	}
+	name := os.Getenv("CFOR_PROVIDER")
+	if name == "" {
+		config, _ := LoadConfig()
+		name = config.Provider
+	}
+	if name == "" {
+		return ProviderOpenAI
+	}
+	return strings.ToLower(name)
+}
+
+func newProvider() (Provider, error) {
+	return newNamedProvider(providerName())
+}
+
+// newNamedProvider builds the provider of the name, which fallback models may
+// pick apart from the configured one
+func newNamedProvider(name string) (Provider, error) {
+	switch name {
+	case ProviderOpenAI:
+		client, err := sharedClient()
+		if err != nil {
+			return nil, err
+		}
+		return newOpenAIProvider(client), nil
+	case ProviderAnthropic:
+		return newAnthropicProvider()
+	case ProviderOllama:
+		return newOpenAIProvider(newOllamaClient()), nil
+	case ProviderGemini:
+		return newGeminiProvider()
+	case ProviderBedrock:
+		return newBedrockProvider()
+	case ProviderOpenRouter:
+		return newOpenRouterProvider()
+	default:
+		return nil, fmt.Errorf("unknown provider %q (expected %s, %s, %s, %s, %s or %s)", name, ProviderOpenAI, ProviderAnthropic, ProviderGemini, ProviderBedrock, ProviderOpenRouter, ProviderOllama)
+	}
+}
+
+// Built on the first request and reused for the rest of the process, like
+// the OpenAI client
+var sharedProvider = sync.OnceValues(newProvider)
+
+// defaultModel returns the model used unless CFOR_OPENAI_MODEL is set
+func defaultModel() openai.ChatModel {
+	switch providerName() {
+	case ProviderAnthropic:
+		return AnthropicModelClaude35Sonnet
+	case ProviderOllama:
+		return OllamaModelLlama31
+	case ProviderGemini:
+		return GeminiModel15Flash
+	case ProviderBedrock:
+		return BedrockModelClaude35Sonnet
+	case ProviderOpenRouter:
+		return OpenRouterModelGPT4o
+	}
+	if compatibleServer() {
+		return compatibleDefaultModel()
+	}
+	return OpenAIModelGPT4o
+}
+
+// providerModels returns the models cfor knows about for the provider: for
+// Ollama, those pulled on the server, and for OpenRouter and OpenAI-compatible
+// servers, those they list
+func providerModels() []openai.ChatModel {
+	switch providerName() {
+	case ProviderAnthropic:
+		return AnthropicSupportedModels
+	case ProviderOllama:
+		return ollamaModels()
+	case ProviderGemini:
+		return GeminiSupportedModels
+	case ProviderBedrock:
+		return BedrockSupportedModels
+	case ProviderOpenRouter:
+		return openRouterModelIDs(false)
+	}
+	if compatibleServer() {
+		if models := compatibleModels(); len(models) > 0 {
+			return models
+		}
+	}
+	return OpenAISupportedModels
+}
+
+// visionModels returns the provider's models that accept images as input
+func visionModels() []openai.ChatModel {
+	switch providerName() {
+	case ProviderAnthropic:
+		return AnthropicVisionModels
+	case ProviderGemini:
+		return GeminiSupportedModels
+	case ProviderBedrock:
+		return BedrockVisionModels
+	case ProviderOpenRouter:
+		return openRouterModelIDs(true)
+	}
+	return OpenAIVisionModels
+}
+
+// postJSON posts the body with the headers for the providers called without
+// an SDK, and returns the response with its body read. Failed requests are
+// retried by completeWithRetry.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("content-type", "application/json")
+
+	resp, err := statusMiddleware(req, func(req *http.Request) (*http.Response, error) {
+		return loggingMiddleware(req, client.Do)
+	})
+	if err != nil {
+		return resp, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+type openAIProvider struct {
+	client *openai.Client
+}
+
+// newOpenAIProvider sends requests through the client. The SDK appends each
+// request's options to the client's, in their spare capacity, which requests
+// made concurrently, e.g. when comparing models, would overwrite for one
+// another; clipped, every request appends to a copy.
+func newOpenAIProvider(client *openai.Client) openAIProvider {
+	client.Chat.Completions.Options = slices.Clip(client.Chat.Completions.Options)
+	return openAIProvider{client: client}
+}
+
+func (p openAIProvider) Complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error) {
+	completion, _, err := p.complete(ctx, model, prompt, schema, opts, limit)
+	return completion, err
+}
+
+func (p openAIProvider) CompleteStream(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64, partial func(content string)) (Completion, error) {
+	completion, _, err := p.stream(ctx, model, prompt, schema, opts, limit, partial)
+	return completion, err
+}
+
+// stream is complete, streaming the response. A response cut short, e.g.
+// once the user picked a suggestion, reports the content received so far
+// and its usage estimated, since only a finished one reports it.
+func (p openAIProvider) stream(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64, partial func(content string), extra ...option.RequestOption) (Completion, string, error) {
+	message, err := userMessage(prompt, opts.Images)
+	if err != nil {
+		return Completion{}, "", err
+	}
+
+	params := chatParams(model, message, schema, opts)
+	setTokenLimit(&params, limit)
+	params.StreamOptions = openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.F(true)})
+
+	// completeWithRetry retries, showing it, and times each attempt out
+	// through the context, rather than the SDK
+	var httpResp *http.Response
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params, append(extra, option.WithMaxRetries(0), option.WithRequestTimeout(0), option.WithResponseInto(&httpResp))...)
+	defer stream.Close()
+
+	var completion Completion
+	var id string
+	for stream.Next() {
+		chunk := stream.Current()
+		id = chunk.ID
+		// Only the last chunk, without choices, reports the usage
+		if chunk.Usage.TotalTokens > 0 {
+			completion.Usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.FinishReason == openai.ChatCompletionChunkChoicesFinishReasonLength {
+			completion.Truncated = true
+		}
+		if choice.Delta.Content != "" {
+			completion.Content += choice.Delta.Content
+			partial(completion.Content)
+		}
+	}
+	completion.RequestID = requestID(httpResp)
+
+	if err := stream.Err(); err != nil {
+		if apiErr := (&openai.Error{}); completion.RequestID == "" && errors.As(err, &apiErr) {
+			completion.RequestID = requestID(apiErr.Response)
+		}
+		if completion.Usage.TotalTokens == 0 && completion.Content != "" {
+			completion.Usage = estimatedUsage(prompt, schema, completion.Content)
+		}
+		return completion, id, &OpenAIRequestError{Err: err, RequestID: completion.RequestID}
+	}
+	return completion, id, nil
+}
+
+// estimatedUsage approximates the usage of a request from its length, for
+// one cut short before the provider reported it
+func estimatedUsage(prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, content string) openai.CompletionUsage {
+	schemaJSON, _ := json.Marshal(schema.Schema.Value)
+	input := int64(len(systemPrompt)+len(prompt)+len(schemaJSON)) / charsPerToken
+	output := int64(len(content)) / charsPerToken
+	return openai.CompletionUsage{PromptTokens: input, CompletionTokens: output, TotalTokens: input + output}
+}
+
+// complete sends the request with the extra options, and also returns the
+// completion's ID, which OpenRouter looks generations up by
+func (p openAIProvider) complete(ctx context.Context, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64, extra ...option.RequestOption) (Completion, string, error) {
+	message, err := userMessage(prompt, opts.Images)
+	if err != nil {
+		return Completion{}, "", err
+	}
+
+	params := chatParams(model, message, schema, opts)
+	setTokenLimit(&params, limit)
+
+	// completeWithRetry retries, showing it, and times each attempt out
+	// through the context, rather than the SDK
+	var httpResp *http.Response
+	resp, err := p.client.Chat.Completions.New(ctx, params, append(extra, option.WithMaxRetries(0), option.WithRequestTimeout(0), option.WithResponseInto(&httpResp))...)
+	if err != nil {
+		id := requestID(httpResp)
+		if apiErr := (&openai.Error{}); id == "" && errors.As(err, &apiErr) {
+			id = requestID(apiErr.Response)
+		}
+		return Completion{}, "", &OpenAIRequestError{Err: err, RequestID: id}
+	}
+
+	return Completion{
+		Content:   resp.Choices[0].Message.Content,
+		Usage:     resp.Usage,
+		Truncated: resp.Choices[0].FinishReason == openai.ChatCompletionChoicesFinishReasonLength,
+		RequestID: requestID(httpResp),
+	}, resp.ID, nil
+}
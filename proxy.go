@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Proxy schemes the HTTP client can connect through
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// configuredProxy returns the proxy set through proxy_url in the config file,
+// or nil when none or an invalid one is
+var configuredProxy = sync.OnceValue(func() *url.URL {
+	config, _ := LoadConfig()
+	if config.ProxyURL == "" {
+		return nil
+	}
+	proxy, err := url.Parse(config.ProxyURL)
+	if err == nil && (!proxySchemes[proxy.Scheme] || proxy.Host == "") {
+		err = fmt.Errorf("expected a URL such as http://proxy:3128 or socks5://proxy:1080")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: invalid proxy_url %q (%v); ignoring it.\n", config.ProxyURL, err)
+		return nil
+	}
+	return proxy
+})
+
+// proxyFor returns the proxy the request goes through: the one set in
+// HTTPS_PROXY or HTTP_PROXY, or else proxy_url in the config file. Hosts
+// listed in NO_PROXY and local ones are connected to directly.
+func proxyFor(req *http.Request) (*url.URL, error) {
+	proxy := configuredProxy()
+	if proxy == nil || proxyEnvSet() {
+		return http.ProxyFromEnvironment(req)
+	}
+	if bypassesProxy(req.URL) {
+		return nil, nil
+	}
+	return proxy, nil
+}
+
+// proxyEnvSet reports whether a proxy is set in the environment, which takes
+// precedence over proxy_url
+func proxyEnvSet() bool {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// bypassesProxy reports whether the URL is connected to directly, as for
+// HTTPS_PROXY: when its host is local or matches an entry of NO_PROXY, a
+// domain (matching its subdomains too), an IP address or a CIDR range, each
+// optionally with a port, or * for every host
+func bypassesProxy(u *url.URL) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" && u.Scheme == "https" {
+		port = "443"
+	} else if port == "" {
+		port = "80"
+	}
+	ip := net.ParseIP(host)
+	if host == "localhost" || ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entry = entryHost
+		}
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Shells whose quoting rules differ from POSIX ones, e.g. fish's backslash
+// escapes within single quotes, which the checks would misread
+var nonPOSIXQuotingShells = map[string]bool{"fish": true, "pwsh": true, "powershell": true, "nu": true}
+
+// Typographic quotes around a word or phrase, which models trained on prose
+// sometimes write instead of the shell's
+var (
+	curlyDoubleQuotePattern = regexp.MustCompile(`“([^“”"]*)”`)
+	curlySingleQuotePattern = regexp.MustCompile(`‘([^‘’']*)’`)
+)
+
+// Paths the user quoted in the request, e.g. "My Documents". Single quotes
+// aren't looked for, since they're more often apostrophes.
+var requestPathPattern = regexp.MustCompile("\"([^\"\n]+)\"|`([^`\n]+)`")
+
+// Characters the shell splits words on or expands unless they're quoted
+const unsafePathChars = " \t*?[]{}()<>|&;$!`'\"\\"
+
+// Listing a huge directory for names to check isn't worth delaying the
+// selector
+const maxContextPaths = 1000
+
+// Characters after which an unterminated quote can't be closed at the end
+// of the command without changing what it runs
+const shellOperators = "|&;<>()\n"
+
+// contextPaths returns the paths from the question's context that the shell
+// would split or expand unless quoted: names in the working directory, and
+// those quoted in the request, e.g. "My Documents" or "report[1].txt"
+func contextPaths(request string) []string {
+	paths := []string{}
+	if entries, err := os.ReadDir("."); err == nil && len(entries) <= maxContextPaths {
+		for _, entry := range entries {
+			paths = append(paths, entry.Name())
+		}
+	}
+	for _, match := range requestPathPattern.FindAllStringSubmatch(request, -1) {
+		paths = append(paths, match[1]+match[2])
+	}
+
+	needQuoting := []string{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path != "" && !strings.Contains(path, "\n") && strings.ContainsAny(path, unsafePathChars) && !slices.Contains(needQuoting, path) {
+			needQuoting = append(needQuoting, path)
+		}
+	}
+	// Longest first, so that "my file.txt" is quoted whole rather than as
+	// the "my file" it starts with
+	slices.SortFunc(needQuoting, func(a, b string) int { return len(b) - len(a) })
+	return needQuoting
+}
+
+// FixQuoting checks the quoting of each suggestion, the most common reason a
+// suggestion can't be run as is. Typographic quotes are replaced with plain
+// ones, paths from the context with spaces or glob characters are quoted, and
+// an unterminated quote is closed at the end when nothing follows it that
+// would change meaning. Suggestions are warned about what was fixed, or about
+// an unterminated quote that couldn't be.
+func FixQuoting(cmds []CmdEntry, paths []string, shell string) []CmdEntry {
+	if nonPOSIXQuotingShells[shell] {
+		return cmds
+	}
+
+	fixed := make([]CmdEntry, len(cmds))
+	for i, entry := range cmds {
+		if cmd := replaceCurlyQuotes(entry.Cmd); cmd != entry.Cmd {
+			entry.Cmd = cmd
+			entry.Warnings = append(entry.Warnings, "had typographic quotes, replaced with plain ones")
+		}
+
+		if cmd, quoted := quoteContextPaths(entry.Cmd, paths); len(quoted) > 0 {
+			entry.Cmd = cmd
+			entry.Warnings = append(entry.Warnings, "had unquoted paths, now quoted: "+strings.Join(quoted, ", "))
+		}
+
+		if quote, pos := unterminatedQuote(entry.Cmd); quote != 0 {
+			if rest := entry.Cmd[pos+1:]; !strings.ContainsAny(rest, shellOperators) && !strings.HasSuffix(rest, `\`) {
+				entry.Cmd += string(quote)
+				entry.Warnings = append(entry.Warnings, fmt.Sprintf("had an unterminated %c quote, closed at the end", quote))
+			} else {
+				entry.Warnings = append(entry.Warnings, fmt.Sprintf("has an unterminated %c quote and won't run as is", quote))
+			}
+		}
+		fixed[i] = entry
+	}
+	return fixed
+}
+
+// replaceCurlyQuotes turns typographic quotes into plain ones where they're
+// in pairs, leaving apostrophes such as the one in "it’s" alone
+func replaceCurlyQuotes(cmd string) string {
+	cmd = curlyDoubleQuotePattern.ReplaceAllString(cmd, `"$1"`)
+	return curlySingleQuotePattern.ReplaceAllString(cmd, `'$1'`)
+}
+
+// quoteContextPaths quotes each occurrence of the paths that stands as a
+// word, or the last part of one such as dir/my file.txt, with none of it
+// quoted or escaped. It returns the paths it quoted.
+func quoteContextPaths(cmd string, paths []string) (string, []string) {
+	quoted := []string{}
+	for _, path := range paths {
+		var b strings.Builder
+		found := false
+		rest := cmd
+		offset := 0
+		for {
+			i := strings.Index(rest, path)
+			if i < 0 {
+				break
+			}
+			start, end := offset+i, offset+i+len(path)
+			if pathBoundary(cmd, start, end) && unquotedSpan(cmd, start, end) {
+				b.WriteString(cmd[offset:start])
+				b.WriteString(shellQuote(path))
+				found = true
+			} else {
+				b.WriteString(cmd[offset:end])
+			}
+			offset = end
+			rest = cmd[end:]
+		}
+		if found {
+			b.WriteString(cmd[offset:])
+			cmd = b.String()
+			quoted = append(quoted, path)
+		}
+	}
+	return cmd, quoted
+}
+
+// pathBoundary reports whether cmd[start:end] is a whole word, or ends one
+// after a slash, rather than part of a longer name
+func pathBoundary(cmd string, start, end int) bool {
+	if start > 0 && !strings.ContainsRune(" \t/=", rune(cmd[start-1])) {
+		return false
+	}
+	return end == len(cmd) || strings.ContainsRune(" \t/"+shellOperators, rune(cmd[end]))
+}
+
+// unquotedSpan reports whether cmd[start:end] is neither within quotes nor
+// escaped. It's checked with the span masked, since quotes in the path
+// itself, e.g. the apostrophe of Bob's notes.txt, are what it lacks quoting
+// for.
+func unquotedSpan(cmd string, start, end int) bool {
+	masked := cmd[:start] + strings.Repeat("x", end-start) + cmd[end:]
+	states, _ := quoteStates(masked)
+	return states[start] == 0
+}
+
+// unterminatedQuote returns the quote left open at the end of the command and
+// where it opens, or 0 when all are closed. Only the first line of a command
+// with a here-document is checked, as its body is data.
+func unterminatedQuote(cmd string) (byte, int) {
+	if i := strings.Index(cmd, "<<"); i >= 0 && !strings.HasPrefix(cmd[i:], "<<<") {
+		if newline := strings.IndexByte(cmd[i:], '\n'); newline >= 0 {
+			cmd = cmd[:i+newline]
+		}
+	}
+
+	states, open := quoteStates(cmd)
+	if open < 0 {
+		return 0, 0
+	}
+	if quote := states[open]; quote != '$' {
+		return quote, open
+	}
+	// $'...' is closed like '...'
+	return '\'', open + 1
+}
+
+// quoteStates marks each byte of the command with the quote it's within:
+// ', ", ` or $' (marked '$'), \ for a byte escaped by a backslash, # for a
+// comment, or 0 for unquoted. Quotes are marked as within what they open or
+// close. It also returns where the quote left open at the end starts, or -1.
+func quoteStates(cmd string) ([]byte, int) {
+	states := make([]byte, len(cmd))
+	var quote byte
+	open := -1
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch quote {
+		case 0:
+			switch {
+			case c == '\\' && i+1 < len(cmd):
+				states[i] = '\\'
+				i++
+				states[i] = '\\'
+				continue
+			case c == '$' && i+1 < len(cmd) && cmd[i+1] == '\'':
+				quote = '$'
+				open = i
+				states[i] = quote
+				i++
+			case c == '\'' || c == '"' || c == '`':
+				quote = c
+				open = i
+			case c == '#' && (i == 0 || strings.ContainsRune(" \t\n;&|(", rune(cmd[i-1]))):
+				for ; i < len(cmd) && cmd[i] != '\n'; i++ {
+					states[i] = '#'
+				}
+				continue
+			}
+			states[i] = quote
+		case '\'':
+			states[i] = quote
+			if c == '\'' {
+				quote = 0
+			}
+		case '$':
+			states[i] = quote
+			if c == '\\' && i+1 < len(cmd) {
+				i++
+				states[i] = quote
+			} else if c == '\'' {
+				quote = 0
+			}
+		default: // " and `
+			states[i] = quote
+			if c == '\\' && i+1 < len(cmd) {
+				i++
+				states[i] = quote
+			} else if c == quote {
+				quote = 0
+			}
+		}
+	}
+	if quote == 0 {
+		open = -1
+	}
+	return states, open
+}
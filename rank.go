@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Ranker reorders the suggestions of a response
+type Ranker func(cmds []CmdEntry) []CmdEntry
+
+// Rankers by the name used with --order and order in the config file
+var rankers = map[string]Ranker{
+	// The model is asked for variations in increasing complexity, so its
+	// order is kept
+	"complexity":  slices.Clone[[]CmdEntry],
+	"safety":      rankBySafety,
+	"portability": rankByPortability,
+	"preference":  rankByPreference,
+}
+
+const defaultOrder = "complexity"
+
+// Utilities specified by POSIX, available on any Unix-like system
+var posixUtilities = map[string]bool{
+	"awk": true, "basename": true, "cat": true, "cd": true, "chmod": true, "chown": true,
+	"cmp": true, "comm": true, "cp": true, "cut": true, "date": true, "df": true,
+	"diff": true, "dirname": true, "du": true, "echo": true, "env": true, "expr": true,
+	"file": true, "find": true, "grep": true, "head": true, "id": true, "kill": true,
+	"ln": true, "ls": true, "mkdir": true, "mv": true, "od": true, "paste": true,
+	"printf": true, "ps": true, "pwd": true, "rm": true, "rmdir": true, "sed": true,
+	"sh": true, "sleep": true, "sort": true, "tail": true, "tar": true, "tee": true,
+	"test": true, "touch": true, "tr": true, "true": true, "uname": true, "uniq": true,
+	"wc": true, "xargs": true,
+}
+
+// ParseRanker returns the ranker with the given name
+func ParseRanker(name string) (Ranker, error) {
+	ranker, ok := rankers[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(rankers))
+		for name := range rankers {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return nil, fmt.Errorf("invalid order %q (expected %s)", name, strings.Join(names, ", "))
+	}
+	return ranker, nil
+}
+
+// rankBy stably sorts the suggestions by ascending score, so that ties keep
+// the model's order
+func rankBy(cmds []CmdEntry, score func(CmdEntry) int) []CmdEntry {
+	scores := map[string]int{}
+	for _, entry := range cmds {
+		scores[entry.Cmd] = score(entry)
+	}
+	ranked := slices.Clone(cmds)
+	slices.SortStableFunc(ranked, func(a, b CmdEntry) int {
+		return scores[a.Cmd] - scores[b.Cmd]
+	})
+	return ranked
+}
+
+// rankBySafety puts the least risky suggestions first
+func rankBySafety(cmds []CmdEntry) []CmdEntry {
+	return rankBy(cmds, func(entry CmdEntry) int {
+		return int(entryRisk(entry))
+	})
+}
+
+// rankByPortability puts first the suggestions relying on the fewest tools
+// outside POSIX, then on the fewest that aren't verified to exist here
+func rankByPortability(cmds []CmdEntry) []CmdEntry {
+	return rankBy(cmds, func(entry CmdEntry) int {
+		score := len(entry.Warnings)
+		for binary := range commandFlags(entry.Cmd) {
+			if !posixUtilities[binary] {
+				score += 2
+			}
+		}
+		return score
+	})
+}
+
+// rankByPreference puts first the suggestions using the tools picked most
+// often from past suggestions
+func rankByPreference(cmds []CmdEntry) []CmdEntry {
+	history, err := GetHistory()
+	if err != nil {
+		return slices.Clone(cmds)
+	}
+
+	picked := map[string]int{}
+	for _, entry := range history {
+		if entry.Selected == "" {
+			continue
+		}
+		for binary := range commandFlags(entry.Selected) {
+			picked[binary]++
+		}
+	}
+
+	return rankBy(cmds, func(entry CmdEntry) int {
+		score := 0
+		for binary := range commandFlags(entry.Cmd) {
+			score -= picked[binary]
+		}
+		return score
+	})
+}
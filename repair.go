@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// UsageRepair is what `cfor cost repair` removed from the cost log and the
+// history
+type UsageRepair struct {
+	// Requests recorded more than once, and what their extra records cost
+	CostDuplicates    int
+	DuplicateCost     Cost
+	HistoryDuplicates int
+	// Lines left partially written by an interrupted process
+	PartialLines int
+}
+
+// recordedRequest reports whether the request is already in the JSON lines
+// file, the cost log or the history, which both record it as request_id
+func recordedRequest(path, requestID string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	id, _ := json.Marshal(requestID)
+	return bytes.Contains(data, append([]byte(`"request_id":`), id...))
+}
+
+// RepairUsage removes the requests recorded more than once, keeping the
+// first record of each, and takes the costs of the others off the daily
+// totals. Requests recorded before their ID was kept can't be told apart,
+// and are left as they are.
+func RepairUsage() (UsageRepair, error) {
+	repair := UsageRepair{}
+
+	costs, err := GetCosts()
+	if errors.Is(err, CostFileNotFoundError{}) {
+		costs = Costs{}
+	} else if err != nil {
+		return repair, err
+	}
+	seen := map[string]bool{}
+	err = rewriteJSONLines(costLogFilepath(), func(line []byte) bool {
+		var entry CostEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			repair.PartialLines++
+			return false
+		}
+		if entry.RequestID != "" && seen[entry.RequestID] {
+			repair.CostDuplicates++
+			repair.DuplicateCost += entry.Cost
+			day := Today(entry.Time.Format("2006-01-02"))
+			if _, ok := costs[day]; ok {
+				costs[day] = max(costs[day]-entry.Cost, 0)
+			}
+			return false
+		}
+		seen[entry.RequestID] = true
+		return true
+	})
+	if err != nil {
+		return repair, err
+	}
+	if repair.CostDuplicates > 0 {
+		if err := writeCosts(costs); err != nil {
+			return repair, err
+		}
+	}
+
+	seen = map[string]bool{}
+	err = rewriteJSONLines(historyFilepath(), func(line []byte) bool {
+		var entry HistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			repair.PartialLines++
+			return false
+		}
+		if entry.RequestID != "" && seen[entry.RequestID] {
+			repair.HistoryDuplicates++
+			return false
+		}
+		seen[entry.RequestID] = true
+		return true
+	})
+	return repair, err
+}
+
+// rewriteJSONLines keeps the lines of the file that keep returns true for,
+// rewriting it only when any is dropped. A file that doesn't exist yet is
+// left alone.
+func rewriteJSONLines(path string, keep func(line []byte) bool) error {
+	if path == "" {
+		return fmt.Errorf("could not determine data directory")
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var kept bytes.Buffer
+	dropped := false
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if !keep(line) {
+			dropped = true
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if !dropped {
+		return nil
+	}
+	if err := os.WriteFile(path, kept.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
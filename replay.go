@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReplayCmds re-sends the request recorded in a history entry with the same
+// model, prompt and options. Entries recorded before prompts were kept are
+// rebuilt from the question with the default options.
+func ReplayCmds(entry HistoryEntry) (ChatResult[Cmds], error) {
+	model := entry.Model
+	if model == "" {
+		return ChatResult[Cmds]{}, fmt.Errorf("history entry %s has no recorded model", entry.ID)
+	}
+	if _, err := checkModel(model); err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	opts := DefaultGenerateOptions()
+	if entry.Options != nil {
+		opts = *entry.Options
+	}
+
+	prompt := entry.Prompt
+	if prompt == "" {
+		built, err := BuildCmdsPrompt(entry.Question, opts)
+		if err != nil {
+			return ChatResult[Cmds]{}, err
+		}
+		prompt = built.User
+	}
+
+	return chatCmds(context.Background(), model, prompt, opts)
+}
+
+// CmdsDiff is a line of the comparison between two sets of suggestions
+type CmdsDiff struct {
+	// "-" when only in the old suggestions, "+" when only in the new ones and
+	// " " when in both
+	Op  string
+	Cmd CmdEntry
+}
+
+// DiffCmds compares suggestions by command, keeping the old order followed by
+// the commands that are new
+func DiffCmds(old, new []CmdEntry) []CmdsDiff {
+	inNew := map[string]bool{}
+	for _, entry := range new {
+		inNew[entry.Cmd] = true
+	}
+
+	diff := []CmdsDiff{}
+	inOld := map[string]bool{}
+	for _, entry := range old {
+		inOld[entry.Cmd] = true
+		op := "-"
+		if inNew[entry.Cmd] {
+			op = " "
+		}
+		diff = append(diff, CmdsDiff{Op: op, Cmd: entry})
+	}
+	for _, entry := range new {
+		if !inOld[entry.Cmd] {
+			diff = append(diff, CmdsDiff{Op: "+", Cmd: entry})
+		}
+	}
+	return diff
+}
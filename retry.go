@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Attempts at a completion when the provider is rate limited, overloaded or
+// failing with a gateway error, before falling back to the next model. The
+// delay between them doubles from retryBaseDelay, up to retryMaxDelay.
+const (
+	maxAttempts    = 3
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// completeWithRetry asks the provider for the completion, timing each attempt
+// out and retrying transient failures with a jittered exponential backoff
+// shown in the spinner. A stream that failed after its first suggestions
+// arrived isn't retried, since they were already shown.
+func completeWithRetry(ctx context.Context, provider Provider, providerName, model, prompt string, schema openai.ResponseFormatJSONSchemaJSONSchemaParam, opts GenerateOptions, limit int64) (Completion, error) {
+	timeout := completionTimeout(providerName, model)
+	status.setTimeout(timeout)
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		var resp Completion
+		var err error
+		if streamer, ok := provider.(StreamingProvider); ok && opts.Partial != nil {
+			resp, err = streamer.CompleteStream(attemptCtx, model, prompt, schema, opts, limit, opts.Partial)
+		} else {
+			resp, err = provider.Complete(attemptCtx, model, prompt, schema, opts, limit)
+		}
+		cancel()
+		if err == nil || attempt == maxAttempts || resp.Content != "" || !shouldRetry(ctx, err) {
+			return resp, err
+		}
+
+		delay := retryDelay(err, attempt)
+		logVerbose("model %s failed, retrying in %s: %v", model, delay.Round(time.Millisecond), err)
+		status.set(fmt.Sprintf("retrying (%d/%d)…", attempt+1, maxAttempts))
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// shouldRetry reports whether the request failed on the provider's end in a
+// way that may pass on its own. A request that timed out already took as long
+// as one is allowed to, so it's left to the fallback models instead.
+func shouldRetry(ctx context.Context, err error) bool {
+	return providerFailed(ctx, err) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryDelay returns how long to wait before the attempt after the given one:
+// as long as the provider asked with Retry-After, or else a backoff doubling
+// with each attempt, randomly shortened by up to half so that clients rate
+// limited together don't retry together
+func retryDelay(err error, attempt int) time.Duration {
+	if requestErr := (&OpenAIRequestError{}); errors.As(err, &requestErr) && requestErr.RetryAfter > 0 {
+		return min(requestErr.RetryAfter, retryMaxDelay)
+	}
+	if apiErr := (&openai.Error{}); errors.As(err, &apiErr) && apiErr.Response != nil {
+		if delay := retryAfter(apiErr.Response.Header); delay > 0 {
+			return min(delay, retryMaxDelay)
+		}
+	}
+	backoff := min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	return backoff/2 + rand.N(backoff/2)
+}
+
+// retryAfter reads how long the provider asked to wait before retrying, from
+// OpenAI's retry-after-ms or the standard Retry-After in seconds or as a date
+func retryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Commands kept in the ring unless ring_size is set
+const defaultRingSize = 10
+
+// Label shown next to commands offered from the ring
+const ringBadge = "[ring]"
+
+func ringFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "ring.json")
+}
+
+func ringSize() int {
+	config, _ := LoadConfig()
+	if config.RingSize > 0 {
+		return config.RingSize
+	}
+	return defaultRingSize
+}
+
+// ReadRing returns the recently inserted commands, most recent first
+func ReadRing() ([]string, error) {
+	path := ringFilepath()
+	if path == "" {
+		return nil, fmt.Errorf("could not determine ring file path")
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ring []string
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ring in %s: %w", path, err)
+	}
+	return ring, nil
+}
+
+// PushRing moves the command to the front of the ring, dropping the oldest
+// once it is full
+func PushRing(cmd string) error {
+	ring, err := ReadRing()
+	if err != nil {
+		return err
+	}
+
+	ring = slices.DeleteFunc(ring, func(c string) bool { return c == cmd })
+	ring = slices.Insert(ring, 0, cmd)
+	if size := ringSize(); len(ring) > size {
+		ring = ring[:size]
+	}
+
+	path := ringFilepath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ring: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write ring file: %w", err)
+	}
+
+	return nil
+}
+
+// RingCmds turns the ring into suggestions for the selector
+func RingCmds(ring []string) []CmdEntry {
+	cmds := make([]CmdEntry, len(ring))
+	for i, cmd := range ring {
+		cmds[i] = CmdEntry{Cmd: cmd, Source: ringBadge}
+	}
+	return cmds
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Risk is how much damage a command can do if run carelessly
+type Risk int
+
+const (
+	RiskLow Risk = iota
+	RiskMedium
+	RiskHigh
+)
+
+var riskNames = map[Risk]string{
+	RiskLow:    "low",
+	RiskMedium: "medium",
+	RiskHigh:   "high",
+}
+
+func (r Risk) String() string {
+	return riskNames[r]
+}
+
+func ParseRisk(s string) (Risk, error) {
+	for risk, name := range riskNames {
+		if strings.EqualFold(s, name) {
+			return risk, nil
+		}
+	}
+	return RiskLow, fmt.Errorf("invalid risk level %q (expected low, medium or high)", s)
+}
+
+type riskRule struct {
+	pattern *regexp.Regexp
+	risk    Risk
+}
+
+// Patterns are matched against the whole command, so that pipelines and
+// chained commands are classified by their riskiest part
+var riskRules = []riskRule{
+	// Irreversible data loss or system-wide changes
+	{regexp.MustCompile(`\brm\s+(-\S*[rRf]|--recursive|--force)`), RiskHigh},
+	{regexp.MustCompile(`\b(mkfs(\.\w+)?|fdisk|parted|wipefs|shred)\b`), RiskHigh},
+	{regexp.MustCompile(`\bdd\b.*\bof=`), RiskHigh},
+	{regexp.MustCompile(`>\s*/dev/(sd|nvme|disk|hd)`), RiskHigh},
+	{regexp.MustCompile(`\bchmod\s+(-\S*R\S*\s+)?[0-7]*777\b`), RiskHigh},
+	{regexp.MustCompile(`\b(curl|wget)\b[^|]*\|\s*(sudo\s+)?(ba|z|da|k)?sh\b`), RiskHigh},
+	{regexp.MustCompile(`\b(ba|z|k)?sh\s+(<\(|-c\s+["']?\$\()\s*(curl|wget)\b`), RiskHigh},
+	{regexp.MustCompile(`\bgit\s+(push\s+.*(--force|-f\b)|reset\s+--hard|clean\s+-\S*f)`), RiskHigh},
+	{regexp.MustCompile(`(?i)\b(drop\s+(table|database)|truncate\s+table)\b`), RiskHigh},
+	{regexp.MustCompile(`\b(kubectl\s+delete|terraform\s+destroy|docker\s+(system|volume)\s+prune)\b`), RiskHigh},
+	{regexp.MustCompile(`\bfind\b.*\s-delete\b`), RiskHigh},
+	{regexp.MustCompile(`\b(shutdown|reboot|halt|poweroff)\b`), RiskHigh},
+	{regexp.MustCompile(`:\(\)\s*\{`), RiskHigh},
+	// Changes that are recoverable or scoped
+	{regexp.MustCompile(`\b(sudo|doas)\b`), RiskMedium},
+	{regexp.MustCompile(`\b(rm|rmdir|mv|chmod|chown|chgrp|truncate|unlink)\b`), RiskMedium},
+	{regexp.MustCompile(`\b(kill|pkill|killall)\b`), RiskMedium},
+	{regexp.MustCompile(`\bsystemctl\s+(stop|restart|disable|mask)\b`), RiskMedium},
+	{regexp.MustCompile(`\b(apt(-get)?|dnf|yum|brew|pacman|npm|pip3?)\s+(install|remove|uninstall|purge|upgrade)\b`), RiskMedium},
+	{regexp.MustCompile(`\bgit\s+(push|rebase|commit\s+--amend|branch\s+-D|stash\s+drop)\b`), RiskMedium},
+	{regexp.MustCompile(`\b(docker|podman)\s+(rm|rmi|kill|stop)\b`), RiskMedium},
+	{regexp.MustCompile(`\b(kubectl\s+(apply|scale|rollout)|terraform\s+apply)\b`), RiskMedium},
+	{regexp.MustCompile(`\bsed\s+(-\S*i|--in-place)`), RiskMedium},
+	{regexp.MustCompile(`[^>2&]>\s*[^&>\s]`), RiskMedium},
+}
+
+// ClassifyRisk returns the highest risk of all rules matching the command
+func ClassifyRisk(cmd string) Risk {
+	risk := RiskLow
+	bundle, _ := LoadBundle()
+	for _, rule := range append(riskRules[:len(riskRules):len(riskRules)], bundle.RiskRules...) {
+		if rule.risk > risk && rule.pattern.MatchString(cmd) {
+			risk = rule.risk
+		}
+	}
+	return risk
+}
+
+// FilterByRisk drops the suggestions whose risk is above the given one
+func FilterByRisk(cmds []CmdEntry, maxRisk Risk) []CmdEntry {
+	filtered := []CmdEntry{}
+	for _, entry := range cmds {
+		if entryRisk(entry) <= maxRisk {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+// Entries returned by searchHistory when no limit is given
+const defaultHistorySearchLimit = 20
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type getSuggestionsParams struct {
+	Question      string `json:"question"`
+	Lang          string `json:"lang"`
+	Deterministic bool   `json:"deterministic"`
+}
+
+type explainParams struct {
+	Cmd string `json:"cmd"`
+}
+
+type searchHistoryParams struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// ServeRPC answers JSON-RPC 2.0 requests for editor plugins, one per line, until
+// the input ends. The methods are getSuggestions, explain and searchHistory.
+func ServeRPC(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	encoder := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			encoder.Encode(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+
+		resp := handleRPC(req)
+		// Notifications get no response
+		if req.ID == nil {
+			continue
+		}
+		resp.JSONRPC = "2.0"
+		resp.ID = req.ID
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func handleRPC(req rpcRequest) rpcResponse {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcResponse{Error: &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}}
+	}
+
+	switch req.Method {
+	case "getSuggestions":
+		var params getSuggestionsParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Question == "" {
+			return rpcInvalidParamsResponse("question is required")
+		}
+		return rpcGetSuggestions(params)
+	case "explain":
+		var params explainParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Cmd == "" {
+			return rpcInvalidParamsResponse("cmd is required")
+		}
+		result, err := ExplainCmd(params.Cmd)
+		RecordUsage(result)
+		if err != nil {
+			return rpcServerErrorResponse(err)
+		}
+		return rpcResponse{Result: result.Message}
+	case "searchHistory":
+		var params searchHistoryParams
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return rpcInvalidParamsResponse(err.Error())
+			}
+		}
+		if params.Limit <= 0 {
+			params.Limit = defaultHistorySearchLimit
+		}
+		history, err := GetHistory()
+		if err != nil {
+			return rpcServerErrorResponse(err)
+		}
+		return rpcResponse{Result: map[string]any{"entries": SearchHistory(history, params.Query, params.Limit)}}
+	default:
+		return rpcResponse{Error: &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}}
+	}
+}
+
+func rpcGetSuggestions(params getSuggestionsParams) rpcResponse {
+	result, cmds, err := askForSuggestions(params)
+	if err != nil {
+		return rpcServerErrorResponse(err)
+	}
+	return rpcResponse{Result: map[string]any{"cmds": cmds, "model": result.Model, "cached": result.Cached}}
+}
+
+// askForSuggestions answers a question asked from outside the terminal, by an
+// editor plugin or the web interface, recording its cost and history like
+// one asked at the prompt
+func askForSuggestions(params getSuggestionsParams) (ChatResult[Cmds], []CmdEntry, error) {
+	opts := DefaultGenerateOptions()
+	if params.Deterministic {
+		opts = DeterministicGenerateOptions()
+	}
+	opts.Language = params.Lang
+
+	result, err := newGenerator(opts, false).Generate(context.Background(), params.Question)
+	RecordUsage(result)
+	if err != nil {
+		return result, nil, err
+	}
+
+	cmds := PairDownloadVerification(AdaptCmdsToShell(FixQuoting(SanitizeCmds(result.Message.Cmds), contextPaths(params.Question), currentShell()), currentShell()))
+	cmds = FilterDenied(SanitizeCmds(PostProcessCmds(StructureCmds(cmds), params.Question)))
+	AppendHistory(HistoryEntry{
+		Question:  params.Question,
+		Model:     result.Model,
+		RequestID: result.RequestID,
+		Tokens:    result.Tokens,
+		Cached:    result.Cached,
+		Cmds:      cmds,
+	})
+	return result, cmds, nil
+}
+
+func rpcInvalidParamsResponse(message string) rpcResponse {
+	return rpcResponse{Error: &rpcError{Code: rpcInvalidParams, Message: message}}
+}
+
+// rpcServerErrorResponse passes the error's details along so plugins can
+// decide whether to retry and show the request ID
+func rpcServerErrorResponse(err error) rpcResponse {
+	return rpcResponse{Error: &rpcError{Code: rpcServerError, Message: err.Error(), Data: DescribeError(err)}}
+}
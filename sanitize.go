@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Latin letters that Cyrillic and Greek ones are indistinguishable from in
+// most fonts, e.g. the Cyrillic с in a `сurl` that runs something else
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i',
+	'ј': 'j', 'ԁ': 'd', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y', 'һ': 'h',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O',
+	'Р': 'P', 'С': 'C', 'Т': 'T', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J',
+	// Greek
+	'ο': 'o', 'α': 'a', 'ν': 'v', 'ρ': 'p', 'τ': 't', 'ι': 'i', 'κ': 'k',
+	'υ': 'u', 'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I',
+	'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O', 'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y',
+	'Χ': 'X',
+}
+
+// SanitizeCmds removes what could make a suggestion run something other than
+// what's displayed, which a prompt injection could slip into the model's
+// output, and warns about the suggestions that had any of it
+func SanitizeCmds(cmds []CmdEntry) []CmdEntry {
+	sanitized := make([]CmdEntry, len(cmds))
+	for i, entry := range cmds {
+		cmd, cmdChanged := sanitizeText(entry.Cmd, true)
+		comment, commentChanged := sanitizeText(entry.Comment, false)
+		tradeoff, tradeoffChanged := sanitizeText(entry.Tradeoff, false)
+		entry.Cmd, entry.Comment, entry.Tradeoff = cmd, comment, tradeoff
+		// The structure is cleaned the same way, so it still matches
+		entry.Binary, _ = sanitizeText(entry.Binary, true)
+		entry.Args = slices.Clone(entry.Args)
+		for j, arg := range entry.Args {
+			entry.Args[j], _ = sanitizeText(arg, true)
+		}
+
+		if changed := slices.Concat(cmdChanged, commentChanged, tradeoffChanged); len(changed) > 0 {
+			entry.Warnings = append(entry.Warnings, "had hidden or look-alike characters, removed or replaced: "+describeRunes(changed))
+		}
+		sanitized[i] = entry
+	}
+	return sanitized
+}
+
+// sanitizeText drops control and invisible characters, turns unusual spaces
+// into plain ones and fullwidth forms into ASCII. In commands, letters that
+// look like Latin ones within Latin words are also replaced, and newlines and
+// tabs are kept. It returns the characters it changed.
+func sanitizeText(text string, cmd bool) (string, []rune) {
+	var changed []rune
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case cmd && (r == '\n' || r == '\t'):
+			b.WriteRune(r)
+		case unicode.IsControl(r) || isInvisible(r):
+			changed = append(changed, r)
+		case unicode.Is(unicode.Zs, r) && r != ' ':
+			changed = append(changed, r)
+			b.WriteRune(' ')
+		case 0xFF01 <= r && r <= 0xFF5E:
+			changed = append(changed, r)
+			b.WriteRune(r - 0xFEE0)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	sanitized := b.String()
+	if !cmd {
+		return sanitized, changed
+	}
+
+	// A word entirely in another script is left alone, e.g. a Greek file name
+	words := strings.FieldsFunc(sanitized, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if !hasASCIILetter(word) {
+			continue
+		}
+		replaced := strings.Map(func(r rune) rune {
+			if latin, ok := confusables[r]; ok {
+				changed = append(changed, r)
+				return latin
+			}
+			return r
+		}, word)
+		if replaced != word {
+			sanitized = strings.ReplaceAll(sanitized, word, replaced)
+		}
+	}
+	return sanitized, changed
+}
+
+// isInvisible reports whether the character renders as nothing: zero-width
+// characters, bidirectional overrides, tag characters and variation
+// selectors
+func isInvisible(r rune) bool {
+	return unicode.Is(unicode.Cf, r) ||
+		unicode.Is(unicode.Variation_Selector, r) ||
+		r == '\u115F' || r == '\u1160' || r == '\u3164' || r == '\uFFA0' // Hangul fillers
+}
+
+func hasASCIILetter(word string) bool {
+	for _, r := range word {
+		if r <= unicode.MaxASCII && unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRunes lists the distinct characters as code points, with the
+// visible ones shown as well, e.g. "U+200B, U+0441 (с)"
+func describeRunes(runes []rune) string {
+	seen := map[rune]bool{}
+	descriptions := []string{}
+	for _, r := range runes {
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+		description := fmt.Sprintf("U+%04X", r)
+		if unicode.IsGraphic(r) && !unicode.IsSpace(r) {
+			description += fmt.Sprintf(" (%c)", r)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret managers may wait on being unlocked, e.g. with a passphrase or a
+// fingerprint, before printing the key
+const apiKeyCommandTimeout = time.Minute
+
+// Entries of api_key_commands for the keys other than the providers'
+const (
+	keyCommandOpenAIAdmin = "openai_admin"
+	keyCommandServer      = "server"
+)
+
+// Keys printed by the api_key_commands entries, by command, which are only
+// ever kept in memory
+var (
+	commandAPIKeysMu sync.Mutex
+	commandAPIKeys   = map[string]string{}
+)
+
+// apiKeyCommand returns the command printing the provider's API key, set
+// through api_key_commands in the config file, e.g. op read
+// op://Private/OpenAI/credential
+func apiKeyCommand(provider string) string {
+	config, _ := LoadConfig()
+	return strings.TrimSpace(config.APIKeyCommands[provider])
+}
+
+// apiKeyOrCommand returns the key set in the environment, or else the one
+// printed by the provider's api_key_commands entry, run once per process. It
+// returns an empty key when neither is set.
+func apiKeyOrCommand(provider, key string) (string, error) {
+	if key != "" {
+		return key, nil
+	}
+	command := apiKeyCommand(provider)
+	if command == "" {
+		return "", nil
+	}
+
+	commandAPIKeysMu.Lock()
+	defer commandAPIKeysMu.Unlock()
+	if key, ok := commandAPIKeys[command]; ok {
+		return key, nil
+	}
+	key, err := runAPIKeyCommand(command)
+	if err != nil {
+		return "", &APIKeyCommandError{Provider: provider, Err: err}
+	}
+	logVerbose("read the %s API key from its api_key_commands entry", provider)
+	commandAPIKeys[command] = key
+	return key, nil
+}
+
+// runAPIKeyCommand runs the command with the shell and returns the first line
+// it prints, which is where pass keeps the password above other fields. The
+// terminal stays its input and error output, for secret managers prompting to
+// be unlocked.
+func runAPIKeyCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiKeyCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	key, _, _ := strings.Cut(string(output), "\n")
+	if key = strings.TrimSpace(key); key == "" {
+		return "", errors.New("it printed no key")
+	}
+	return key, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultServerAddr = "127.0.0.1:8787"
+	defaultUpstream   = "https://api.openai.com/v1"
+	// Large enough for a question with attached screenshots
+	maxServerRequestSize = 32 << 20
+)
+
+// Response headers passed back to clients, for request IDs and rate limits
+var forwardedHeaders = []string{"Content-Type", "X-Request-Id", "Retry-After"}
+
+// serverURL returns the team server set through CFOR_SERVER or server in the
+// config file, if any
+func serverURL() string {
+	if url := os.Getenv("CFOR_SERVER"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	config, _ := LoadConfig()
+	return strings.TrimSuffix(config.Server, "/")
+}
+
+// serverToken returns the member token identifying the user to the team server
+func serverToken() (string, error) {
+	return apiKeyOrCommand(keyCommandServer, os.Getenv("CFOR_SERVER_TOKEN"))
+}
+
+// teamCostsFilepath is where the server keeps the costs of each member
+func teamCostsFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "team_costs.json")
+}
+
+// TeamServer proxies the LLM requests of a team's members with the server's
+// API key, enforcing the org policy's model approvals and spend caps for each
+// member and tracking what each of them spends
+type TeamServer struct {
+	// Member names by token
+	members  map[string]string
+	apiKey   string
+	upstream string
+	client   *http.Client
+
+	mu sync.Mutex
+}
+
+// NewTeamServer loads the members file, mapping each member's name to their
+// token, and the provider key from the server's environment
+func NewTeamServer(membersPath string) (*TeamServer, error) {
+	data, err := os.ReadFile(membersPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read members file: %w", err)
+	}
+	var tokens map[string]string
+	if err := yaml.Unmarshal(data, &tokens); err != nil {
+		return nil, ConfigParseError{Path: membersPath, Err: err}
+	}
+
+	members := map[string]string{}
+	for name, token := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("member %s has no token", name)
+		}
+		members[token] = name
+	}
+
+	apiKey, err := openAIAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, &APIKeyMissingError{}
+	}
+
+	upstream := strings.TrimSuffix(upstreamBaseURL(), "/")
+	if upstream == "" {
+		upstream = defaultUpstream
+	}
+
+	return &TeamServer{
+		members:  members,
+		apiKey:   apiKey,
+		upstream: upstream,
+		client:   newHTTPClient(),
+	}, nil
+}
+
+func (s *TeamServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChat)
+	mux.HandleFunc("GET /v1/models/{model}", s.handleModel)
+	mux.HandleFunc("GET /cfor/costs", s.handleCosts)
+	return mux
+}
+
+// member identifies the caller by the token sent as their API key
+func (s *TeamServer) member(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	name, ok := s.members[token]
+	return name, ok
+}
+
+func (s *TeamServer) handleChat(w http.ResponseWriter, r *http.Request) {
+	member, ok := s.member(r)
+	if !ok {
+		writeServerError(w, http.StatusUnauthorized, "invalid_api_key", "unknown member token")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxServerRequestSize))
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeServerError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	policy, err := LoadPolicy()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, "invalid_policy", err.Error())
+		return
+	}
+	if !policy.ApprovesModel(req.Model) {
+		writeServerError(w, http.StatusForbidden, "model_not_approved", ModelNotApprovedError{Model: req.Model}.Error())
+		return
+	}
+	if err := policy.CheckSpend(s.memberCosts(member)); err != nil {
+		writeServerError(w, http.StatusForbidden, "spend_cap_exceeded", err.Error())
+		return
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, s.upstream+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	upstreamReq.Header.Set("Authorization", "Bearer "+s.apiKey)
+	upstreamReq.Header.Set("Content-Type", "application/json")
+	if org := openAIOrganization(); org != "" {
+		upstreamReq.Header.Set("OpenAI-Organization", org)
+	}
+	if project := openAIProject(); project != "" {
+		upstreamReq.Header.Set("OpenAI-Project", project)
+	}
+
+	resp, err := s.client.Do(upstreamReq)
+	if err != nil {
+		writeServerError(w, http.StatusBadGateway, "upstream_unreachable", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeServerError(w, http.StatusBadGateway, "upstream_unreachable", err.Error())
+		return
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		var completion struct {
+			Usage openai.CompletionUsage `json:"usage"`
+		}
+		if err := json.Unmarshal(respBody, &completion); err == nil {
+			if err := s.recordCost(member, req.Model, completion.Usage); err != nil {
+				logVerbose("failed to record the cost of %s: %v", member, err)
+			}
+		}
+	}
+	logVerbose("%s: %s %s", member, req.Model, resp.Status)
+
+	for _, header := range forwardedHeaders {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	for header, values := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(header), "x-ratelimit-") {
+			w.Header()[header] = values
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// handleModel answers the key check of cfor auth rotate without contacting
+// the provider
+func (s *TeamServer) handleModel(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.member(r); !ok {
+		writeServerError(w, http.StatusUnauthorized, "invalid_api_key", "unknown member token")
+		return
+	}
+	policy, _ := LoadPolicy()
+	model := r.PathValue("model")
+	if !policy.ApprovesModel(model) {
+		writeServerError(w, http.StatusNotFound, "model_not_found", ModelNotApprovedError{Model: model}.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"id": model, "object": "model", "owned_by": "cfor"})
+}
+
+// handleCosts returns this month's spend of every member
+func (s *TeamServer) handleCosts(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.member(r); !ok {
+		writeServerError(w, http.StatusUnauthorized, "invalid_api_key", "unknown member token")
+		return
+	}
+
+	s.mu.Lock()
+	costs, err := readTeamCosts()
+	s.mu.Unlock()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	month := time.Now().Format("2006-01")
+	totals := map[string]Cost{}
+	for member, days := range costs {
+		for day, cost := range days {
+			if strings.HasPrefix(string(day), month) {
+				totals[member] += cost
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totals)
+}
+
+func (s *TeamServer) memberCosts(member string) Costs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	costs, _ := readTeamCosts()
+	return costs[member]
+}
+
+func (s *TeamServer) recordCost(member, model string, usage openai.CompletionUsage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	costs, err := readTeamCosts()
+	if err != nil {
+		return err
+	}
+	if costs[member] == nil {
+		costs[member] = Costs{}
+	}
+	costs[member][Today(time.Now().Format("2006-01-02"))] += EstimateCost(model, usage)
+
+	path := teamCostsFilepath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	data, err := json.MarshalIndent(costs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal team costs: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write team costs file: %w", err)
+	}
+	return nil
+}
+
+func readTeamCosts() (map[string]Costs, error) {
+	path := teamCostsFilepath()
+	if path == "" {
+		return nil, fmt.Errorf("could not determine team costs file path")
+	}
+
+	costs := map[string]Costs{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return costs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &costs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal team costs: %w", err)
+	}
+	return costs, nil
+}
+
+// writeServerError responds in the provider's error format, so clients
+// surface the message as they would the provider's
+func writeServerError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message, "type": "cfor_server_error", "code": code},
+	})
+}
+
+// GetTeamCosts asks the team server for this month's spend of every member
+func GetTeamCosts() (map[string]Cost, error) {
+	server := serverURL()
+	if server == "" {
+		return nil, fmt.Errorf("no team server is set; set CFOR_SERVER or server in the config file")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server+"/cfor/costs", nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := serverToken()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", server, resp.Status)
+	}
+	var totals map[string]Cost
+	if err := json.NewDecoder(resp.Body).Decode(&totals); err != nil {
+		return nil, fmt.Errorf("failed to decode team costs: %w", err)
+	}
+	return totals, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+// currentShell returns the name of the user's shell, e.g. "zsh" or "fish"
+func currentShell() string {
+	return filepath.Base(userShell())
+}
+
+// Start of a simple command: the beginning of the line or after a separator
+const commandStart = `(^|;\s*|&&\s*|\|\|\s*)`
+
+// A shell word, possibly quoted
+const shellWord = `("[^"]*"|'[^']*'|[^\s;&|]+)`
+
+type shellRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Mechanical rewrites of POSIX-isms into other shell dialects. Anything that
+// can't be rewritten reliably is left to the model following the prompt.
+var shellRewrites = map[string][]shellRewrite{
+	"fish": {
+		{regexp.MustCompile(commandStart + `export\s+([A-Za-z_]\w*)=` + shellWord), "${1}set -x $2 $3"},
+		{regexp.MustCompile(commandStart + `unset\s+([A-Za-z_]\w*)`), "${1}set -e $2"},
+		{regexp.MustCompile(commandStart + `([A-Za-z_]\w*)=` + shellWord + `\s*($|;|&&|\|\|)`), "${1}set $2 $3$4"},
+		// Backticks are not supported by fish at all; only unquoted ones
+		// are rewritten as fish doesn't substitute inside quotes
+		{regexp.MustCompile("^([^\"']*)`([^`\"']*)`"), "$1($2)"},
+	},
+	"csh": {
+		{regexp.MustCompile(commandStart + `export\s+([A-Za-z_]\w*)=` + shellWord), "${1}setenv $2 $3"},
+		{regexp.MustCompile(commandStart + `unset\s+([A-Za-z_]\w*)`), "${1}unsetenv $2"},
+	},
+}
+
+func init() {
+	shellRewrites["tcsh"] = shellRewrites["csh"]
+}
+
+// AdaptToShell rewrites the command into the dialect of the given shell
+func AdaptToShell(cmd, shell string) string {
+	for _, rewrite := range shellRewrites[shell] {
+		// Rewrites are repeated until stable, as each pass only rewrites
+		// non-overlapping matches
+		for {
+			rewritten := rewrite.pattern.ReplaceAllString(cmd, rewrite.replacement)
+			if rewritten == cmd {
+				break
+			}
+			cmd = rewritten
+		}
+	}
+	return cmd
+}
+
+// AdaptCmdsToShell rewrites each suggestion into the dialect of the shell
+func AdaptCmdsToShell(cmds []CmdEntry, shell string) []CmdEntry {
+	adapted := make([]CmdEntry, len(cmds))
+	for i, entry := range cmds {
+		entry.Cmd = AdaptToShell(entry.Cmd, shell)
+		adapted[i] = entry
+	}
+	return adapted
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Snippet is a curated command saved for reuse, either locally or in a
+// team-shared directory
+type Snippet struct {
+	Question string   `json:"question"`
+	Cmd      string   `json:"cmd"`
+	Comment  string   `json:"comment"`
+	Tags     []string `json:"tags,omitempty"`
+	Shared   bool     `json:"-"`
+}
+
+// Labels shown next to suggestions coming from the team-shared store and from
+// the local one
+const (
+	sharedSnippetBadge = "[team]"
+	localSnippetBadge  = "[snippet]"
+)
+
+// Fraction of a snippet's keywords that must appear in the question
+const snippetMatchThreshold = 0.5
+
+func localSnippetsFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "snippets.json")
+}
+
+// sharedSnippetsDir returns the team-shared snippet directory, typically a
+// checkout of a git repository curated by the team
+func sharedSnippetsDir() string {
+	return os.Getenv("CFOR_SNIPPETS_DIR")
+}
+
+// preferSnippets reports whether matching shared snippets should be used
+// instead of asking the API
+func preferSnippets() bool {
+	value := strings.ToLower(os.Getenv("CFOR_PREFER_SNIPPETS"))
+	return value == "1" || value == "true"
+}
+
+// GetSnippets loads the local snippets followed by all shared ones
+func GetSnippets() ([]Snippet, error) {
+	snippets := []Snippet{}
+
+	if path := localSnippetsFilepath(); path != "" {
+		local, err := readSnippetFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		snippets = append(snippets, local...)
+	}
+
+	if dir := sharedSnippetsDir(); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			shared, err := readSnippetFile(path)
+			if err != nil {
+				return nil, err
+			}
+			for i := range shared {
+				shared[i].Shared = true
+			}
+			snippets = append(snippets, shared...)
+		}
+	}
+
+	return snippets, nil
+}
+
+// AddSnippet appends a snippet to the local store, or to snippets.json in the
+// shared directory so that it can be committed for the team
+func AddSnippet(snippet Snippet, shared bool) error {
+	path := localSnippetsFilepath()
+	if shared {
+		dir := sharedSnippetsDir()
+		if dir == "" {
+			return fmt.Errorf("CFOR_SNIPPETS_DIR is not set")
+		}
+		path = filepath.Join(dir, "snippets.json")
+	}
+	if path == "" {
+		return fmt.Errorf("could not determine snippets file path")
+	}
+
+	snippets, err := readSnippetFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return writeSnippetFile(path, append(snippets, snippet))
+}
+
+// RemoveLocalSnippet removes the local snippet at the index, as listed by
+// GetSnippets, whose local snippets come first
+func RemoveLocalSnippet(index int) error {
+	path := localSnippetsFilepath()
+	if path == "" {
+		return fmt.Errorf("could not determine snippets file path")
+	}
+
+	snippets, err := readSnippetFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if index < 0 || index >= len(snippets) {
+		return fmt.Errorf("no local snippet %d", index)
+	}
+	return writeSnippetFile(path, slices.Delete(snippets, index, index+1))
+}
+
+func writeSnippetFile(path string, snippets []Snippet) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(snippets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snippets: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snippets file: %w", err)
+	}
+
+	return nil
+}
+
+// SyncSharedSnippets fast-forwards the shared directory when it is a git
+// checkout
+func SyncSharedSnippets() error {
+	if err := checkOnline("Syncing snippets"); err != nil {
+		return err
+	}
+	dir := sharedSnippetsDir()
+	if dir == "" {
+		return fmt.Errorf("CFOR_SNIPPETS_DIR is not set")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		return fmt.Errorf("%s is not a git repository", dir)
+	}
+
+	out, err := exec.Command("git", "-C", dir, "pull", "--ff-only").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git pull failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// MatchSnippets returns the snippets whose question and tags are mostly
+// covered by the words of the given question
+func MatchSnippets(snippets []Snippet, question string) []Snippet {
+	words := map[string]bool{}
+	for _, word := range keywords(question) {
+		words[word] = true
+	}
+
+	matches := []Snippet{}
+	for _, snippet := range snippets {
+		snippetWords := keywords(snippet.Question + " " + strings.Join(snippet.Tags, " "))
+		if len(snippetWords) == 0 {
+			continue
+		}
+
+		hits := 0
+		for _, word := range snippetWords {
+			if words[word] {
+				hits++
+			}
+		}
+		if float64(hits)/float64(len(snippetWords)) >= snippetMatchThreshold {
+			matches = append(matches, snippet)
+		}
+	}
+	return matches
+}
+
+// Words too common to tell two questions apart
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "from": true,
+	"into": true, "all": true, "how": true, "what": true, "this": true,
+	"that": true,
+}
+
+func keywords(text string) []string {
+	words := []string{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}) {
+		if len(word) < 3 || stopWords[word] {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// SnippetCmds converts snippets into suggestions, badging the shared ones
+func SnippetCmds(snippets []Snippet) []CmdEntry {
+	cmds := make([]CmdEntry, len(snippets))
+	for i, snippet := range snippets {
+		cmds[i] = CmdEntry{Cmd: snippet.Cmd, Comment: snippet.Comment, Source: localSnippetBadge}
+		if snippet.Shared {
+			cmds[i].Source = sharedSnippetBadge
+		}
+	}
+	return cmds
+}
+
+func readSnippetFile(path string) ([]Snippet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var snippets []Snippet
+	if err := json.Unmarshal(data, &snippets); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snippets in %s: %w", path, err)
+	}
+	return snippets, nil
+}
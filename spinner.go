@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/openai/openai-go/option"
+)
+
+// requestStatus tracks the stage of the request in flight, so that slow
+// requests show progress instead of looking like a hang
+type requestStatus struct {
+	mu             sync.Mutex
+	stage          string
+	attemptStarted time.Time
+	// How long each attempt may take, the API's timeout unless set
+	timeout time.Duration
+}
+
+var status = &requestStatus{stage: "contacting api…"}
+
+func (s *requestStatus) set(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stage = stage
+}
+
+func (s *requestStatus) startAttempt(stage string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stage = stage
+	s.attemptStarted = time.Now()
+}
+
+func (s *requestStatus) setTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeout = timeout
+}
+
+func (s *requestStatus) get() (string, time.Time, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timeout == 0 {
+		return s.stage, s.attemptStarted, apiTimeout()
+	}
+	return s.stage, s.attemptStarted, s.timeout
+}
+
+// statusMiddleware reports the progress of each request attempt, keeping
+// the stage of a retry, e.g. "retrying (2/3)…", until the request is sent
+func statusMiddleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	stage, _, _ := status.get()
+	if !strings.HasPrefix(stage, "retrying") {
+		stage = "contacting api…"
+	}
+	status.startAttempt(stage)
+
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			status.set("generating…")
+		},
+	}
+	return next(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// StatusSpinner is a spinner showing the stage of the request in flight, the
+// elapsed time and a countdown to the request timeout
+type StatusSpinner struct {
+	*spinner.Spinner
+	done chan struct{}
+	// The stage in the terminal title, only rewritten when it changes
+	titleStage string
+}
+
+func NewStatusSpinner() *StatusSpinner {
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix += " "
+	s.Color("fgGreen")
+	return &StatusSpinner{Spinner: s}
+}
+
+func (s *StatusSpinner) Start() {
+	s.done = make(chan struct{})
+	started := time.Now()
+	status.startAttempt("contacting api…")
+
+	startTerminalProgress()
+	s.update(started)
+	s.Spinner.Start()
+
+	// As often as the spinner turns, so that stages as short as the delay
+	// before a retry still show
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.update(started)
+			}
+		}
+	}()
+}
+
+func (s *StatusSpinner) update(started time.Time) {
+	stage, attemptStarted, timeout := status.get()
+	elapsed := time.Since(started).Round(time.Second)
+	remaining := max(timeout-time.Since(attemptStarted), 0).Round(time.Second)
+
+	s.Lock()
+	s.Suffix = fmt.Sprintf(" %s %s (timeout in %s)", stage, elapsed, remaining)
+	// Under the spinner's lock, so the title isn't written mid-frame
+	if stage != s.titleStage {
+		setTerminalTitle("cfor: " + stage)
+		s.titleStage = stage
+	}
+	s.Unlock()
+}
+
+func (s *StatusSpinner) Stop() {
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+		// Only restore the title when the spinner had set it
+		defer stopTerminalProgress()
+	}
+	s.Spinner.Stop()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const sshHostsPrompt = "These hosts in the question are aliases from the user's SSH config; use their real host name, user and port in ssh, scp and rsync commands:\n%s\n\n"
+
+// SSHHost is what an alias from the SSH config resolves to
+type SSHHost struct {
+	Alias     string
+	HostName  string
+	User      string
+	Port      string
+	ProxyJump string
+}
+
+// sshHostBlock is a Host section of the SSH config with its settings, keyed
+// by lowercase keyword
+type sshHostBlock struct {
+	patterns []string
+	settings map[string]string
+}
+
+// Describe renders the host for the prompt
+func (h SSHHost) Describe() string {
+	parts := []string{}
+	if h.HostName != "" {
+		parts = append(parts, "host "+h.HostName)
+	}
+	if h.User != "" {
+		parts = append(parts, "user "+h.User)
+	}
+	if h.Port != "" {
+		parts = append(parts, "port "+h.Port)
+	}
+	if h.ProxyJump != "" {
+		parts = append(parts, "via "+h.ProxyJump)
+	}
+	return fmt.Sprintf("%s: %s", h.Alias, strings.Join(parts, ", "))
+}
+
+// sshConfigBlocks reads ~/.ssh/config once, following Include directives
+var sshConfigBlocks = sync.OnceValue(func() []sshHostBlock {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return readSSHConfig(filepath.Join(homeDir, ".ssh", "config"), filepath.Join(homeDir, ".ssh"), 0)
+})
+
+// Include depth ssh itself allows
+const maxSSHIncludeDepth = 16
+
+func readSSHConfig(path, sshDir string, depth int) []sshHostBlock {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	// Settings before the first Host apply to every host
+	blocks := []sshHostBlock{{patterns: []string{"*"}, settings: map[string]string{}}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Both "Key Value" and "Key=Value" are allowed
+		keyword, value, _ := strings.Cut(strings.Replace(line, "=", " ", 1), " ")
+		keyword = strings.ToLower(keyword)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		switch keyword {
+		case "host":
+			blocks = append(blocks, sshHostBlock{patterns: strings.Fields(value), settings: map[string]string{}})
+		case "match":
+			// Conditions can't be evaluated without connecting; skip the section
+			blocks = append(blocks, sshHostBlock{settings: map[string]string{}})
+		case "include":
+			if depth >= maxSSHIncludeDepth {
+				continue
+			}
+			for _, pattern := range strings.Fields(value) {
+				if !filepath.IsAbs(pattern) && !strings.HasPrefix(pattern, "~") {
+					pattern = filepath.Join(sshDir, pattern)
+				}
+				if rest, ok := strings.CutPrefix(pattern, "~"); ok {
+					pattern = filepath.Join(filepath.Dir(sshDir), rest)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					blocks = append(blocks, readSSHConfig(match, sshDir, depth+1)...)
+				}
+			}
+		default:
+			settings := blocks[len(blocks)-1].settings
+			// The first value obtained is used
+			if _, ok := settings[keyword]; !ok {
+				settings[keyword] = value
+			}
+		}
+	}
+	return blocks
+}
+
+// matches reports whether the block applies to the alias, following ssh's
+// patterns: * and ? wildcards, and !negations
+func (b sshHostBlock) matches(alias string) bool {
+	matched := false
+	for _, pattern := range b.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := filepath.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), strings.ToLower(alias))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// ResolveSSHHost applies the settings of every block matching the alias, the
+// first value of each setting winning as in ssh
+func ResolveSSHHost(alias string) SSHHost {
+	host := SSHHost{Alias: alias}
+	for _, block := range sshConfigBlocks() {
+		if !block.matches(alias) {
+			continue
+		}
+		for keyword, field := range map[string]*string{
+			"hostname":  &host.HostName,
+			"user":      &host.User,
+			"port":      &host.Port,
+			"proxyjump": &host.ProxyJump,
+		} {
+			if *field == "" {
+				*field = block.settings[keyword]
+			}
+		}
+	}
+	// %h in HostName stands for the alias
+	host.HostName = strings.ReplaceAll(host.HostName, "%h", alias)
+	return host
+}
+
+// MentionedSSHHosts returns the aliases from the SSH config that appear in the
+// question as whole words. Wildcard patterns aren't aliases.
+func MentionedSSHHosts(question string) []SSHHost {
+	hosts := []SSHHost{}
+	seen := map[string]bool{}
+	for _, block := range sshConfigBlocks() {
+		for _, alias := range block.patterns {
+			if strings.ContainsAny(alias, "*?!") || seen[strings.ToLower(alias)] {
+				continue
+			}
+			mention := regexp.MustCompile(`(?i)(^|[^\w.-])` + regexp.QuoteMeta(alias) + `($|[^\w-])`)
+			if !mention.MatchString(question) {
+				continue
+			}
+			seen[strings.ToLower(alias)] = true
+			if host := ResolveSSHHost(alias); host.HostName != "" || host.User != "" || host.Port != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
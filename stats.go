@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Number of topics shown in the history stats
+const maxStatsTopics = 10
+
+type TopicCount struct {
+	Topic string
+	Count int
+}
+
+// HistoryStats summarizes how cfor has been used, from the local history only
+type HistoryStats struct {
+	Questions int
+	Topics    []TopicCount
+	// Questions asked per hour of the day
+	Hours          [24]int
+	AverageTokens  float64
+	CacheHitRate   float64
+	AcceptanceRate float64
+}
+
+func ComputeHistoryStats(entries []HistoryEntry) HistoryStats {
+	stats := HistoryStats{Questions: len(entries)}
+	if len(entries) == 0 {
+		return stats
+	}
+
+	topics := map[string]int{}
+	var tokens int64
+	var requests, cached, answered, accepted int
+	for _, entry := range entries {
+		for _, word := range keywords(entry.Question) {
+			topics[word]++
+		}
+
+		stats.Hours[entry.Time.Hour()]++
+
+		if entry.Cached {
+			cached++
+		} else if entry.Tokens > 0 {
+			requests++
+			tokens += entry.Tokens
+		}
+
+		if len(entry.Cmds) > 0 {
+			answered++
+			if entry.Selected != "" {
+				accepted++
+			}
+		}
+	}
+
+	for topic, count := range topics {
+		stats.Topics = append(stats.Topics, TopicCount{Topic: topic, Count: count})
+	}
+	sort.Slice(stats.Topics, func(i, j int) bool {
+		if stats.Topics[i].Count != stats.Topics[j].Count {
+			return stats.Topics[i].Count > stats.Topics[j].Count
+		}
+		return stats.Topics[i].Topic < stats.Topics[j].Topic
+	})
+	if len(stats.Topics) > maxStatsTopics {
+		stats.Topics = stats.Topics[:maxStatsTopics]
+	}
+
+	if requests > 0 {
+		stats.AverageTokens = float64(tokens) / float64(requests)
+	}
+	if requests+cached > 0 {
+		stats.CacheHitRate = float64(cached) / float64(requests+cached)
+	}
+	if answered > 0 {
+		stats.AcceptanceRate = float64(accepted) / float64(answered)
+	}
+
+	return stats
+}
+
+// Bars of increasing height used to chart the busiest hours
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// hoursSparkline charts the questions per hour of the day, one bar per hour
+func hoursSparkline(hours [24]int) string {
+	busiest := 0
+	for _, count := range hours {
+		busiest = max(busiest, count)
+	}
+
+	var b strings.Builder
+	for _, count := range hours {
+		if count == 0 || busiest == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(sparkBars[(count*(len(sparkBars)-1))/busiest])
+	}
+	return b.String()
+}
+
+// busiestHour returns the hour of the day with the most questions
+func busiestHour(hours [24]int) int {
+	busiest := 0
+	for hour, count := range hours {
+		if count > hours[busiest] {
+			busiest = hour
+		}
+	}
+	return busiest
+}
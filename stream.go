@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// CmdsUpdate is the list of suggestions as it grows while they're generated.
+// The last update is Done, or carries the error that ended generating them.
+type CmdsUpdate struct {
+	Cmds []CmdEntry
+	Done bool
+	Err  error
+}
+
+// PartialCmds returns the suggestions complete so far in a response being
+// streamed, e.g. the first two of {"cmds":[{...},{...},{"cmd":"ls -
+func PartialCmds(content string) []CmdEntry {
+	key := strings.Index(content, `"cmds"`)
+	if key < 0 {
+		return nil
+	}
+	start := strings.Index(content[key:], "[")
+	if start < 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(content[key+start:]))
+	if _, err := decoder.Token(); err != nil {
+		return nil
+	}
+	cmds := []CmdEntry{}
+	for decoder.More() {
+		var entry CmdEntry
+		// The last one is usually cut off
+		if err := decoder.Decode(&entry); err != nil {
+			break
+		}
+		cmds = append(cmds, entry)
+	}
+	return cmds
+}
+
+// streamingAvailable reports whether the provider can stream its responses
+func streamingAvailable() bool {
+	provider, err := sharedProvider()
+	if err != nil {
+		return false
+	}
+	_, ok := provider.(StreamingProvider)
+	return ok
+}
+
+// StreamedSelection is the outcome of selecting a suggestion while they're
+// generated
+type StreamedSelection struct {
+	Result ChatResult[Cmds]
+	// The suggestions as last shown
+	Cmds     []CmdEntry
+	Selected string
+	// Why no suggestion was selected, e.g. QuitError or RerunError
+	SelectErr error
+	// Why the suggestions couldn't be generated, which ended the selection
+	GenerateErr error
+}
+
+// SelectStreamed opens the selector right away and fills it in as the
+// suggestions arrive: partial prepares those complete so far, and build the
+// final list once the response is. Picking one before then stops generating
+// the rest.
+func SelectStreamed(request string, opts GenerateOptions, refresh bool, selectOpts SelectOptions, partial func([]CmdEntry) []CmdEntry, build func(ChatResult[Cmds]) ([]CmdEntry, error)) StreamedSelection {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan CmdsUpdate)
+	var selection StreamedSelection
+	// The selector may be gone by the time an update is ready
+	send := func(update CmdsUpdate) {
+		select {
+		case updates <- update:
+			if update.Err == nil {
+				selection.Cmds = update.Cmds
+			}
+		case <-ctx.Done():
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streamOpts := opts
+		streamOpts.Partial = func(content string) {
+			send(CmdsUpdate{Cmds: partial(PartialCmds(content))})
+		}
+		result, err := newGenerator(streamOpts, refresh).Generate(ctx, request)
+		selection.Result = result
+		if err == nil {
+			var cmds []CmdEntry
+			if cmds, err = build(result); err == nil {
+				send(CmdsUpdate{Cmds: cmds, Done: true})
+				return
+			}
+		}
+		selection.GenerateErr = err
+		send(CmdsUpdate{Err: err})
+	}()
+
+	selectOpts.Updates = updates
+	selection.Selected, selection.SelectErr = SelectCmd(nil, selectOpts)
+	cancel()
+	<-done
+
+	// Generating was only cut short because the user was done
+	if selection.GenerateErr != nil && !errors.Is(selection.SelectErr, selection.GenerateErr) {
+		selection.GenerateErr = nil
+	} else if selection.GenerateErr != nil {
+		selection.SelectErr = nil
+	}
+	return selection
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Platforms a suggestion can be limited to, as the model names them
+var platformNames = map[string]string{"darwin": "macos", "macos": "macos", "osx": "macos", "linux": "linux", "windows": "windows"}
+
+// currentPlatform names the platform cfor runs on as suggestions name it
+func currentPlatform() string {
+	if platform, ok := platformNames[runtime.GOOS]; ok {
+		return platform
+	}
+	return runtime.GOOS
+}
+
+// StructureCmds makes the structured fields of each suggestion describe its
+// command. Those the model gave are kept, unless the command was rewritten
+// since, e.g. by a quoting fix or a post-processor; those missing, as for
+// snippets, history and older cached answers, are derived from the command.
+// Suggestions are warned when they're made for another platform or need root
+// without asking for it.
+func StructureCmds(cmds []CmdEntry) []CmdEntry {
+	structured := make([]CmdEntry, len(cmds))
+	for i, entry := range cmds {
+		binary, args, sudo := parseCmdStructure(entry.Cmd)
+		if entry.Binary != binary || !argsInCmd(entry.Cmd, entry.Args) {
+			entry.Binary, entry.Args = binary, args
+		}
+		if entry.Args == nil {
+			entry.Args = []string{}
+		}
+		if entry.NeedsSudo && !sudo && os.Geteuid() != 0 {
+			entry.Warnings = appendWarning(entry.Warnings, "needs root, e.g. through sudo")
+		}
+		entry.NeedsSudo = entry.NeedsSudo || sudo
+		entry.Destructive = entry.Destructive || ClassifyRisk(entry.Cmd) == RiskHigh
+
+		platforms := []string{}
+		for _, platform := range entry.Platforms {
+			platform = strings.ToLower(strings.TrimSpace(platform))
+			if name, ok := platformNames[platform]; ok {
+				platform = name
+			}
+			if platform != "" && !slices.Contains(platforms, platform) {
+				platforms = append(platforms, platform)
+			}
+		}
+		entry.Platforms = platforms
+		if len(platforms) > 0 && !slices.Contains(platforms, currentPlatform()) {
+			entry.Warnings = appendWarning(entry.Warnings, fmt.Sprintf("made for %s, not %s", strings.Join(platforms, " and "), currentPlatform()))
+		}
+		structured[i] = entry
+	}
+	return structured
+}
+
+// appendWarning adds the warning unless a previous pass already did
+func appendWarning(warnings []string, warning string) []string {
+	if slices.Contains(warnings, warning) {
+		return warnings
+	}
+	return append(warnings, warning)
+}
+
+// parseCmdStructure returns the binary a command line runs first, past any
+// variable assignments and wrappers such as sudo or env, and its arguments,
+// and whether any part of it runs through sudo or doas
+func parseCmdStructure(cmd string) (string, []string, bool) {
+	binary, args, sudo := "", []string{}, false
+	for _, segment := range commandSeparatorPattern.Split(cmd, -1) {
+		fields := shellFields(segment)
+		for len(fields) > 0 && (envAssignmentPattern.MatchString(fields[0]) || commandWrappers[fields[0]]) {
+			sudo = sudo || fields[0] == "sudo" || fields[0] == "doas"
+			fields = fields[1:]
+		}
+		if binary == "" && len(fields) > 0 {
+			binary, args = fields[0], fields[1:]
+		}
+	}
+	return binary, args, sudo
+}
+
+// argsInCmd reports whether the arguments still appear in the command, in
+// order, which those given for a command rewritten since may not
+func argsInCmd(cmd string, args []string) bool {
+	for _, arg := range args {
+		i := strings.Index(cmd, arg)
+		if i < 0 {
+			return false
+		}
+		cmd = cmd[i+len(arg):]
+	}
+	return true
+}
+
+// entryRisk is the risk of the suggestion: that of its command, raised for
+// one the model said is destructive or needs root
+func entryRisk(entry CmdEntry) Risk {
+	risk := ClassifyRisk(entry.Cmd)
+	if entry.Destructive {
+		risk = RiskHigh
+	}
+	if entry.NeedsSudo && risk < RiskMedium {
+		risk = RiskMedium
+	}
+	return risk
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Records the day the weekly spend summary was last shown
+func summaryShownFilepath() string {
+	dir := stateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "summary_shown")
+}
+
+// startOfWeek returns midnight of the Monday starting the week of t
+func startOfWeek(t time.Time) time.Time {
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, t.Location())
+}
+
+// WeeklySpend totals the cost and number of requests since Monday
+func WeeklySpend(entries []CostEntry, now time.Time) (Cost, int) {
+	since := startOfWeek(now)
+
+	var total Cost
+	queries := 0
+	for _, entry := range entries {
+		if entry.Time.Before(since) {
+			continue
+		}
+		total += entry.Cost
+		queries++
+	}
+	return total, queries
+}
+
+// PrintWeeklySummary prints this week's spend to stderr, at most once a day
+func PrintWeeklySummary() {
+	shownPath := summaryShownFilepath()
+	if shownPath == "" {
+		return
+	}
+
+	today := time.Now().Format("2006-01-02")
+	if shown, err := os.ReadFile(shownPath); err == nil && strings.TrimSpace(string(shown)) == today {
+		return
+	}
+
+	entries, err := GetCostEntries()
+	if err != nil {
+		return
+	}
+
+	total, queries := WeeklySpend(entries, time.Now())
+	fmt.Fprintf(os.Stderr, "This week: $%.2f across %d queries\n", total, queries)
+
+	os.MkdirAll(filepath.Dir(shownPath), 0755)
+	os.WriteFile(shownPath, []byte(today), 0644)
+}
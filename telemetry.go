@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Kinds of metric events
+const (
+	MetricCommand = "command"
+	MetricRequest = "request"
+)
+
+// Categories of metrics that can be shared, each only when listed under
+// telemetry.share in the config file
+const (
+	TelemetryCommands = "commands"
+	TelemetryLatency  = "latency"
+	TelemetryCache    = "cache"
+)
+
+var telemetryCategories = []string{TelemetryCommands, TelemetryLatency, TelemetryCache}
+
+// Telemetry configuration
+const (
+	// Reports are shared at most this often
+	telemetryInterval = 24 * time.Hour
+	// Sharing holds up the command it's due on, once a day, for at most this
+	// long
+	telemetryTimeout = 2 * time.Second
+)
+
+// MetricEvent is a locally recorded measurement. It never holds a question, a
+// command line or anything else typed by the user.
+type MetricEvent struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	// The cfor command run, e.g. "cfor explain", for command events
+	Command string `json:"command,omitempty"`
+	// How long the provider took to answer, for requests that weren't cached
+	Latency time.Duration `json:"latency,omitempty"`
+	Cached  bool          `json:"cached,omitempty"`
+}
+
+// TelemetryConfig selects the metrics shared with the maintainers. Nothing is
+// shared unless both are set.
+type TelemetryConfig struct {
+	// Categories of metrics shared: commands, latency and cache
+	Share []string `yaml:"share"`
+	// URL the daily report is posted to
+	Endpoint string `yaml:"endpoint"`
+}
+
+func metricsFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "metrics.jsonl")
+}
+
+// Records when metrics were last shared, which starts the next report
+func metricsSharedFilepath() string {
+	dir := stateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "metrics_shared")
+}
+
+// AppendMetric records an event in the local metrics, timestamping it
+func AppendMetric(event MetricEvent) error {
+	path := metricsFilepath()
+	if path == "" {
+		return fmt.Errorf("could not determine metrics file path")
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metric: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open metrics file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+// GetMetrics returns the recorded events, oldest first
+func GetMetrics() ([]MetricEvent, error) {
+	path := metricsFilepath()
+	if path == "" {
+		return nil, fmt.Errorf("could not determine metrics file path")
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events := []MetricEvent{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var event MetricEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metric: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// RecordCommandMetric counts the command about to run, and shares the
+// metrics when due. Shell completion isn't counted.
+func RecordCommandMetric(cmd *cobra.Command) {
+	if cmd.Hidden || strings.HasPrefix(cmd.Name(), "__") {
+		return
+	}
+	AppendMetric(MetricEvent{Kind: MetricCommand, Command: cmd.CommandPath()})
+	ShareMetrics()
+}
+
+type CommandCount struct {
+	Command string `json:"command"`
+	Count   int    `json:"count"`
+}
+
+// MetricsSummary aggregates the events of a period
+type MetricsSummary struct {
+	Commands []CommandCount
+	// Requests answered by the provider, i.e. cache misses
+	Requests   int
+	CacheHits  int
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+}
+
+func (s MetricsSummary) CacheHitRate() float64 {
+	if s.Requests+s.CacheHits == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(s.Requests+s.CacheHits)
+}
+
+// SummarizeMetrics aggregates the events in [since, until)
+func SummarizeMetrics(events []MetricEvent, since, until time.Time) MetricsSummary {
+	var summary MetricsSummary
+	counts := map[string]int{}
+	var latencies []time.Duration
+	for _, event := range events {
+		if event.Time.Before(since) || !event.Time.Before(until) {
+			continue
+		}
+		switch event.Kind {
+		case MetricCommand:
+			counts[event.Command]++
+		case MetricRequest:
+			if event.Cached {
+				summary.CacheHits++
+			} else {
+				summary.Requests++
+				latencies = append(latencies, event.Latency)
+			}
+		}
+	}
+
+	for command, count := range counts {
+		summary.Commands = append(summary.Commands, CommandCount{Command: command, Count: count})
+	}
+	sort.Slice(summary.Commands, func(i, j int) bool {
+		if summary.Commands[i].Count != summary.Commands[j].Count {
+			return summary.Commands[i].Count > summary.Commands[j].Count
+		}
+		return summary.Commands[i].Command < summary.Commands[j].Command
+	})
+
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		summary.LatencyP50 = latencies[(len(latencies)-1)*50/100]
+		summary.LatencyP95 = latencies[(len(latencies)-1)*95/100]
+	}
+	return summary
+}
+
+// MetricsReport is exactly what's shared: the cfor version, the period and
+// the aggregates of the categories opted into. Dates rather than times are
+// sent, and there is no identifier of the user or machine.
+type MetricsReport struct {
+	Version  string          `json:"version"`
+	Since    string          `json:"since"`
+	Until    string          `json:"until"`
+	Commands []CommandCount  `json:"commands,omitempty"`
+	Latency  *LatencyMetrics `json:"latency,omitempty"`
+	Cache    *CacheMetrics   `json:"cache,omitempty"`
+}
+
+type LatencyMetrics struct {
+	Requests int   `json:"requests"`
+	P50Ms    int64 `json:"p50_ms"`
+	P95Ms    int64 `json:"p95_ms"`
+}
+
+type CacheMetrics struct {
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
+
+// NewMetricsReport keeps the categories of the summary that are shared
+func NewMetricsReport(summary MetricsSummary, categories []string, since, until time.Time) MetricsReport {
+	report := MetricsReport{
+		Version: Version,
+		Since:   since.Format("2006-01-02"),
+		Until:   until.Format("2006-01-02"),
+	}
+	if slices.Contains(categories, TelemetryCommands) {
+		report.Commands = summary.Commands
+	}
+	if slices.Contains(categories, TelemetryLatency) {
+		report.Latency = &LatencyMetrics{
+			Requests: summary.Requests,
+			P50Ms:    summary.LatencyP50.Milliseconds(),
+			P95Ms:    summary.LatencyP95.Milliseconds(),
+		}
+	}
+	if slices.Contains(categories, TelemetryCache) {
+		report.Cache = &CacheMetrics{Hits: summary.CacheHits, Misses: summary.Requests}
+	}
+	return report
+}
+
+// sharedMetricCategories returns the categories opted into, or none when
+// sharing is off: without an endpoint, with DO_NOT_TRACK set, or in offline
+// mode
+func sharedMetricCategories() ([]string, string) {
+	config, _ := LoadConfig()
+	endpoint := config.Telemetry.Endpoint
+	if endpoint == "" || offlineMode() {
+		return nil, ""
+	}
+	if value := os.Getenv("DO_NOT_TRACK"); value != "" && value != "0" {
+		return nil, ""
+	}
+
+	var categories []string
+	for _, category := range config.Telemetry.Share {
+		category = strings.ToLower(category)
+		if slices.Contains(telemetryCategories, category) && !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		return nil, ""
+	}
+	return categories, endpoint
+}
+
+// lastMetricsShare returns when metrics were last shared, if ever
+func lastMetricsShare() (time.Time, bool) {
+	data, err := os.ReadFile(metricsSharedFilepath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	shared, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	return shared, err == nil
+}
+
+func markMetricsShared(at time.Time) {
+	path := metricsSharedFilepath()
+	if path == "" {
+		return
+	}
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, []byte(at.Format(time.RFC3339)), 0644)
+}
+
+// PendingMetricsReport returns the report due to be shared next, covering the
+// events since the last one
+func PendingMetricsReport(now time.Time) (MetricsReport, bool) {
+	categories, _ := sharedMetricCategories()
+	if len(categories) == 0 {
+		return MetricsReport{}, false
+	}
+	since, ok := lastMetricsShare()
+	if !ok {
+		// Nothing recorded before opting in is shared
+		since = now
+	}
+	events, err := GetMetrics()
+	if err != nil {
+		return MetricsReport{}, false
+	}
+	return NewMetricsReport(SummarizeMetrics(events, since, now), categories, since, now), true
+}
+
+// ShareMetrics posts the report of the categories opted into, at most once a
+// day. A report that fails to send is included in the next one.
+func ShareMetrics() {
+	categories, endpoint := sharedMetricCategories()
+	if len(categories) == 0 {
+		return
+	}
+
+	now := time.Now()
+	last, ok := lastMetricsShare()
+	if !ok {
+		// Opted in just now; the first report starts here
+		markMetricsShared(now)
+		return
+	}
+	if now.Sub(last) < telemetryInterval {
+		return
+	}
+
+	report, ok := PendingMetricsReport(now)
+	if !ok {
+		return
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), telemetryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		logVerbose("failed to share metrics: %v", err)
+		return
+	}
+	req.Header.Set("content-type", "application/json")
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		logVerbose("failed to share metrics: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logVerbose("failed to share metrics: %s", resp.Status)
+		return
+	}
+	markMetricsShared(now)
+}
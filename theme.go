@@ -0,0 +1,114 @@
+//go:build !notui
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Themes selectable through CFOR_THEME or theme in the config file
+const (
+	ThemeDefault      = "default"
+	ThemeColorblind   = "colorblind"
+	ThemeHighContrast = "high-contrast"
+)
+
+const defaultTheme = ThemeDefault
+
+// Theme is the palette of the interactive interface. Each color has a variant
+// for light and one for dark backgrounds, picked by the background the
+// terminal reports.
+type Theme struct {
+	// Help text
+	Muted lipgloss.AdaptiveColor
+	// Keys in the help, and emphasized parts such as those of an explanation
+	Accent lipgloss.AdaptiveColor
+	// Warnings and notices
+	Notice lipgloss.AdaptiveColor
+	// Pinned suggestions and table headers
+	Positive  lipgloss.AdaptiveColor
+	Unchecked lipgloss.AdaptiveColor
+	// The highlighted item, and its text
+	Selected     lipgloss.AdaptiveColor
+	SelectedText lipgloss.AdaptiveColor
+	Border       lipgloss.AdaptiveColor
+	BoldNotices  bool
+}
+
+var themes = map[string]Theme{
+	ThemeDefault: {
+		Muted:        lipgloss.AdaptiveColor{Light: "#52525B", Dark: "#A1A1AA"},
+		Accent:       lipgloss.AdaptiveColor{Light: "#B45309", Dark: "#F4A261"},
+		Notice:       lipgloss.AdaptiveColor{Light: "#B45309", Dark: "#F4A261"},
+		Positive:     lipgloss.AdaptiveColor{Light: "#1E7B4A", Dark: "#6FCF97"},
+		Unchecked:    lipgloss.AdaptiveColor{Light: "#757575", Dark: "#BDBDBD"},
+		Selected:     lipgloss.AdaptiveColor{Light: "#64748B", Dark: "#64748B"},
+		SelectedText: lipgloss.AdaptiveColor{Light: "#FFFFFF", Dark: "#FFFFFF"},
+		Border:       lipgloss.AdaptiveColor{Light: "#64748B", Dark: "#64748B"},
+	},
+	// The Okabe-Ito palette, which stays distinct with deuteranopia and
+	// protanopia: blue instead of green, and orange, yellow and reddish
+	// purple that don't depend on telling red from green
+	ThemeColorblind: {
+		Muted:        lipgloss.AdaptiveColor{Light: "#52525B", Dark: "#A1A1AA"},
+		Accent:       lipgloss.AdaptiveColor{Light: "#CC79A7", Dark: "#E69F00"},
+		Notice:       lipgloss.AdaptiveColor{Light: "#D55E00", Dark: "#F0E442"},
+		Positive:     lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#56B4E9"},
+		Unchecked:    lipgloss.AdaptiveColor{Light: "#757575", Dark: "#BDBDBD"},
+		Selected:     lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#0072B2"},
+		SelectedText: lipgloss.AdaptiveColor{Light: "#FFFFFF", Dark: "#FFFFFF"},
+		Border:       lipgloss.AdaptiveColor{Light: "#0072B2", Dark: "#56B4E9"},
+		BoldNotices:  true,
+	},
+	// Black and white with the most saturated accents, and no dimmed text
+	ThemeHighContrast: {
+		Muted:        lipgloss.AdaptiveColor{Light: "#000000", Dark: "#FFFFFF"},
+		Accent:       lipgloss.AdaptiveColor{Light: "#00008B", Dark: "#FFFF00"},
+		Notice:       lipgloss.AdaptiveColor{Light: "#8B0000", Dark: "#FF8C00"},
+		Positive:     lipgloss.AdaptiveColor{Light: "#00008B", Dark: "#00FFFF"},
+		Unchecked:    lipgloss.AdaptiveColor{Light: "#000000", Dark: "#FFFFFF"},
+		Selected:     lipgloss.AdaptiveColor{Light: "#000000", Dark: "#FFFFFF"},
+		SelectedText: lipgloss.AdaptiveColor{Light: "#FFFFFF", Dark: "#000000"},
+		Border:       lipgloss.AdaptiveColor{Light: "#000000", Dark: "#FFFFFF"},
+		BoldNotices:  true,
+	},
+}
+
+// themeName returns the theme selected through CFOR_THEME or theme in the
+// config file, the default one otherwise
+func themeName() string {
+	name := os.Getenv("CFOR_THEME")
+	if name == "" {
+		config, _ := LoadConfig()
+		name = config.Theme
+	}
+	if name == "" {
+		return defaultTheme
+	}
+	return strings.ToLower(name)
+}
+
+// useConfiguredTheme sets the styles from the selected theme before an
+// interface is first drawn. Rendering them asks the terminal for its
+// background, so it isn't done at startup, which commands that print plain
+// text would wait on.
+var useConfiguredTheme = sync.OnceFunc(func() {
+	name := themeName()
+	theme, ok := themes[name]
+	if !ok {
+		theme = themes[defaultTheme]
+		names := []string{}
+		for name := range themes {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		fmt.Fprintf(os.Stderr, "Warning: unknown theme %q (expected %s); using the default one.\n", name, strings.Join(names, ", "))
+	}
+	applyTheme(theme)
+})
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Set by --timeout, which takes precedence over CFOR_TIMEOUT and timeout in
+// the config file
+var timeoutFlag time.Duration
+
+// configuredTimeout returns the timeout set through --timeout, CFOR_TIMEOUT
+// or timeout in the config file, or 0 when none is. CFOR_TIMEOUT takes a
+// duration such as 30s or 2m, or a number of seconds.
+var configuredTimeout = sync.OnceValue(func() time.Duration {
+	if timeoutFlag > 0 {
+		return timeoutFlag
+	}
+	if env := os.Getenv("CFOR_TIMEOUT"); env != "" {
+		if d, err := time.ParseDuration(env); err == nil && d > 0 {
+			return d
+		}
+		if seconds, err := strconv.ParseFloat(env, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: invalid CFOR_TIMEOUT %q (expected a duration such as 30s or 2m); ignoring it.\n", env)
+	}
+	config, _ := LoadConfig()
+	return max(config.Timeout, 0)
+})
+
+// apiTimeout returns how long a call to the provider's API other than a
+// completion may take, e.g. validating a key or looking up a model
+func apiTimeout() time.Duration {
+	if configured := configuredTimeout(); configured > 0 {
+		return configured
+	}
+	return defaultTimeout
+}
+
+// completionTimeout returns how long each attempt at a completion of the
+// model may take: the configured timeout, or else one long enough for the
+// model, since local models and those reasoning first are slower to answer
+func completionTimeout(provider, model string) time.Duration {
+	if configured := configuredTimeout(); configured > 0 {
+		return configured
+	}
+	switch {
+	case provider == ProviderOllama:
+		return ollamaTimeout
+	case isReasoningModel(model):
+		return reasoningTimeout
+	}
+	return defaultTimeout
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Output kept per executed command, from the end where errors usually are
+const maxTranscriptOutput = 64 * 1024
+
+// TranscriptEntry records a command run with --exec and what it printed
+type TranscriptEntry struct {
+	Time     time.Time     `json:"time"`
+	Question string        `json:"question,omitempty"`
+	Cmd      string        `json:"cmd"`
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+	// Interrupted with Ctrl+C
+	Interrupted bool `json:"interrupted,omitempty"`
+	// The end of the combined stdout and stderr, with the policy's redactions
+	// applied
+	Output string `json:"output,omitempty"`
+}
+
+func transcriptFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "transcript.jsonl")
+}
+
+// AppendTranscript records an executed command
+func AppendTranscript(entry TranscriptEntry) error {
+	path := transcriptFilepath()
+	if path == "" {
+		return fmt.Errorf("could not determine transcript file path")
+	}
+
+	if policy, err := LoadPolicy(); err == nil {
+		entry.Output = policy.RedactText(entry.Output)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript entry: %w", err)
+	}
+
+	// Output can hold anything the command printed, so it's kept private
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open transcript file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write transcript file: %w", err)
+	}
+	return nil
+}
+
+// tailBuffer keeps the last bytes written to it. Stdout and stderr are copied
+// into it concurrently.
+type tailBuffer struct {
+	mu   sync.Mutex
+	data []byte
+	max  int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.max; over > 0 {
+		b.data = b.data[over:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
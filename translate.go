@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+const translatePrompt = `For the **%s** operating system and the **%s** shell, translate this command
+into the equivalent using **%s** instead:
+
+%s
+
+Keep the behavior identical: same inputs, outputs, side effects and exit
+status where possible. Every suggestion must use %s as its main tool. If part
+of the command has no direct equivalent, say so in the comment.`
+
+// TranslateCmd converts an existing command into the equivalent for another
+// tool, such as sed to awk or docker to podman. The target tool must be
+// installed, since a translation that can't be run is of little use.
+func TranslateCmd(command, tool string) (ChatResult[Cmds], error) {
+	if _, err := exec.LookPath(tool); err != nil {
+		return ChatResult[Cmds]{}, &ToolNotInstalledError{Tool: tool}
+	}
+
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	prompt := fmt.Sprintf(translatePrompt, runtime.GOOS, currentShell(), tool, command, tool)
+	return chatCmds(context.Background(), model, prompt, DefaultGenerateOptions())
+}
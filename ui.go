@@ -1,125 +1,508 @@
+//go:build !notui
+
 package main
 
 import (
 	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
 type CmdSelector struct {
 	cmds     []string
+	entries  []CmdEntry
 	cursor   int
 	selected string
 	quit     bool
 	rerun    bool
+
+	// Explanations fetched so far, by command, which stay with their
+	// suggestion as the list changes
+	explanations    map[string]Explanation
+	showExplanation bool
+	explaining      bool
+	explainErr      error
+	// Only allow selecting suggestions whose explanation was viewed
+	requireExplanation bool
+	notice             string
+
+	// Previews of package transactions run so far, by command
+	previews    map[string]PackagePreview
+	showPreview bool
+	previewing  bool
+
+	// The model picker, for asking again with another model
+	currentModel string
+	models       []string
+	pickingModel bool
+	modelCursor  int
+	model        string
+
+	// The terminal's width, and whether the details of the suggestion under
+	// the cursor are shown beside the list, which wide terminals default to
+	width int
+	split bool
+
+	// The suggestion the cursor was on before asking again, which it returns
+	// to once the suggestion arrives
+	restoreCursor string
+
+	// Suggestions still arriving while they're generated, and the error that
+	// ended generating them
+	updates   <-chan CmdsUpdate
+	streaming bool
+	streamErr error
+}
+
+// Terminals at least this wide get the list and the details side by side
+const splitLayoutMinWidth = 120
+
+func NewCmdSelector(entries []CmdEntry, opts SelectOptions) *CmdSelector {
+	state := opts.State
+	m := &CmdSelector{
+		cmds:               commentCmds(entries),
+		entries:            slices.Clone(entries),
+		cursor:             max(slices.IndexFunc(entries, func(entry CmdEntry) bool { return entry.Cmd == state.Cursor }), 0),
+		selected:           "",
+		quit:               false,
+		rerun:              false,
+		explanations:       map[string]Explanation{},
+		showExplanation:    state.ShowExplanation,
+		previews:           map[string]PackagePreview{},
+		showPreview:        state.ShowPreview,
+		requireExplanation: opts.RequireExplanation,
+		currentModel:       opts.Model,
+		width:              state.Width,
+		split:              state.Split,
+		restoreCursor:      state.Cursor,
+		updates:            opts.Updates,
+		streaming:          opts.Updates != nil,
+	}
+	maps.Copy(m.explanations, state.Explanations)
+	maps.Copy(m.previews, state.Previews)
+	return m
 }
 
-func NewCmdSelector(cmds []string) *CmdSelector {
-	return &CmdSelector{
-		cmds:     cmds,
-		cursor:   0,
-		selected: "",
-		quit:     false,
-		rerun:    false,
+// state returns how the selector was left, for the one asking again to
+// restore
+func (m *CmdSelector) state() SelectorState {
+	cursor := ""
+	if m.cursor < len(m.entries) {
+		cursor = m.entries[m.cursor].Cmd
+	}
+	return SelectorState{
+		Cursor:          cursor,
+		ShowExplanation: m.showExplanation,
+		ShowPreview:     m.showPreview,
+		Split:           m.split,
+		Width:           m.width,
+		Explanations:    m.explanations,
+		Previews:        m.previews,
 	}
 }
 
 func (m *CmdSelector) Init() tea.Cmd {
-	return nil
+	return m.waitForUpdate()
+}
+
+// waitForUpdate receives the next list of suggestions while they're generated
+func (m *CmdSelector) waitForUpdate() tea.Cmd {
+	if !m.streaming {
+		return nil
+	}
+	updates := m.updates
+	return func() tea.Msg {
+		update, ok := <-updates
+		if !ok {
+			return streamEndedMsg{}
+		}
+		return update
+	}
+}
+
+// streamEndedMsg tells that no more suggestions will arrive
+type streamEndedMsg struct{}
+
+// setEntries replaces the suggestions, keeping the cursor and the pins on
+// the same commands, wherever they moved
+func (m *CmdSelector) setEntries(entries []CmdEntry) {
+	entries = slices.Clone(entries)
+	pinned := map[string]bool{}
+	for _, entry := range m.entries {
+		pinned[entry.Cmd] = entry.Pinned
+	}
+	current := m.restoreCursor
+	if m.cursor < len(m.entries) {
+		current = m.entries[m.cursor].Cmd
+	}
+
+	m.cursor = 0
+	for i := range entries {
+		entries[i].Pinned = entries[i].Pinned || pinned[entries[i].Cmd]
+		if entries[i].Cmd == current {
+			m.cursor = i
+		}
+	}
+	m.entries = entries
+	m.cmds = commentCmds(entries)
+}
+
+// explanationMsg carries the explanation of the command
+type explanationMsg struct {
+	cmd         string
+	explanation Explanation
+	err         error
+}
+
+func explain(cmd string) tea.Cmd {
+	return func() tea.Msg {
+		result, err := ExplainCmd(cmd)
+		RecordUsage(result)
+		return explanationMsg{cmd: cmd, explanation: result.Message, err: err}
+	}
+}
+
+// fetchExplanation requests the explanation of the highlighted suggestion
+// when the pane is open and it hasn't been fetched yet
+func (m *CmdSelector) fetchExplanation() tea.Cmd {
+	if !m.showExplanation || m.explaining || len(m.entries) == 0 {
+		return nil
+	}
+	if _, ok := m.explanations[m.entries[m.cursor].Cmd]; ok {
+		return nil
+	}
+	m.explaining = true
+	m.explainErr = nil
+	return explain(m.entries[m.cursor].Cmd)
+}
+
+// packagePreviewMsg carries the preview of the command
+type packagePreviewMsg struct {
+	cmd    string
+	output string
+	err    error
+}
+
+func previewPackages(cmd string) tea.Cmd {
+	return func() tea.Msg {
+		output, err := PreviewPackageCmd(cmd)
+		return packagePreviewMsg{cmd: cmd, output: output, err: err}
+	}
+}
+
+// fetchPreview runs the package preview of the highlighted suggestion when
+// the pane is open and it hasn't been run yet
+func (m *CmdSelector) fetchPreview() tea.Cmd {
+	if !m.showPreview || m.previewing || len(m.entries) == 0 {
+		return nil
+	}
+	if _, ok := m.previews[m.entries[m.cursor].Cmd]; ok {
+		return nil
+	}
+	m.previewing = true
+	return previewPackages(m.entries[m.cursor].Cmd)
+}
+
+// editorFinishedMsg carries the command as saved in the external editor
+type editorFinishedMsg struct {
+	cmd string
+	err error
 }
 
 func (m *CmdSelector) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case editorFinishedMsg:
+		// Stay in the selector if the editor failed or the command was emptied
+		if msg.err != nil || msg.cmd == "" {
+			return m, nil
+		}
+		m.selected = msg.cmd
+		return m, tea.Quit
+	case explanationMsg:
+		m.explaining = false
+		if msg.err != nil {
+			m.explainErr = msg.err
+			return m, nil
+		}
+		m.explanations[msg.cmd] = msg.explanation
+		// The cursor may have moved on while waiting
+		return m, m.fetchExplanation()
+	case packagePreviewMsg:
+		m.previewing = false
+		m.previews[msg.cmd] = PackagePreview{Output: msg.output, Err: msg.err}
+		return m, m.fetchPreview()
+	case streamEndedMsg:
+		m.streaming = false
+		return m, nil
+	case CmdsUpdate:
+		if msg.Err != nil {
+			m.streamErr = msg.Err
+			return m, tea.Quit
+		}
+		// A response cut off at the token limit is asked for again, which
+		// starts over; the suggestions shown so far stay until it catches up
+		if msg.Done || len(msg.Cmds) >= len(m.entries) {
+			m.setEntries(msg.Cmds)
+		}
+		m.streaming = !msg.Done
+		return m, tea.Batch(m.waitForUpdate(), m.fetchExplanation(), m.fetchPreview())
+	case tea.WindowSizeMsg:
+		// The layout follows the width until switched by hand
+		if m.width == 0 || (m.width >= splitLayoutMinWidth) == m.split {
+			m.split = msg.Width >= splitLayoutMinWidth
+		}
+		m.width = msg.Width
+		return m, nil
 	case tea.KeyMsg:
+		m.notice = ""
+		if m.pickingModel {
+			return m.updateModelPicker(msg)
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
 			m.quit = true
 			return m, tea.Quit
+		}
+		// Only quitting is possible until the first suggestion arrives
+		if len(m.entries) == 0 {
+			return m, nil
+		}
+		switch msg.String() {
 		case "up", "k":
 			if m.cursor > 0 {
 				m.cursor--
 			} else {
 				m.cursor = len(m.cmds) - 1
 			}
+			return m, tea.Batch(m.fetchExplanation(), m.fetchPreview())
 		case "down", "j":
 			if m.cursor < len(m.cmds)-1 {
 				m.cursor++
 			} else {
 				m.cursor = 0
 			}
+			return m, tea.Batch(m.fetchExplanation(), m.fetchPreview())
 		case "r":
 			m.rerun = true
 			return m, tea.Quit
+		case "p":
+			m.entries[m.cursor].Pinned = !m.entries[m.cursor].Pinned
+			return m, nil
+		case "m":
+			m.models = SelectableModels()
+			m.modelCursor = max(slices.Index(m.models, m.currentModel), 0)
+			m.pickingModel = true
+			return m, nil
+		case "v":
+			return m, openInEditor(m.entries[m.cursor].Cmd)
+		case "x":
+			m.showExplanation = !m.showExplanation
+			return m, m.fetchExplanation()
+		case "i":
+			m.showPreview = !m.showPreview
+			return m, m.fetchPreview()
+		case "l":
+			m.split = !m.split
+			return m, nil
 		case "enter", " ":
-			m.selected = m.cmds[m.cursor]
+			if _, viewed := m.explanations[m.entries[m.cursor].Cmd]; m.requireExplanation && !viewed {
+				m.notice = "View the explanation before running this command."
+				return m, nil
+			}
+			m.selected = m.entries[m.cursor].Cmd
 			return m, tea.Quit
 		}
 	}
 	return m, nil
 }
 
-// Colors
-var (
-	MutedGray       = lipgloss.Color("#A1A1AA")
-	MutedPurpleBlue = lipgloss.Color("#5A3FC0")
-	NeuralGrey      = lipgloss.Color("#BDBDBD")
-	SlateBlue       = lipgloss.Color("#64748B")
-	SoftGreen       = lipgloss.Color("#6FCF97")
-	WarmOrange      = lipgloss.Color("#F4A261")
-	White           = lipgloss.Color("#FFFFFF")
-)
+// updateModelPicker handles the keys while choosing a model to ask again
+func (m *CmdSelector) updateModelPicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		m.quit = true
+		return m, tea.Quit
+	case "esc", "m":
+		m.pickingModel = false
+	case "up", "k":
+		if m.modelCursor > 0 {
+			m.modelCursor--
+		} else {
+			m.modelCursor = len(m.models) - 1
+		}
+	case "down", "j":
+		if m.modelCursor < len(m.models)-1 {
+			m.modelCursor++
+		} else {
+			m.modelCursor = 0
+		}
+	case "enter", " ":
+		if len(m.models) == 0 {
+			m.pickingModel = false
+			return m, nil
+		}
+		m.model = m.models[m.modelCursor]
+		m.rerun = true
+		return m, tea.Quit
+	}
+	return m, nil
+}
 
-// Styles
+// openInEditor suspends the selector and opens the command in $VISUAL or
+// $EDITOR through a temporary file, for heavier editing of long one-liners
+func openInEditor(cmd string) tea.Cmd {
+	file, err := os.CreateTemp("", "cfor-*.sh")
+	if err != nil {
+		return func() tea.Msg { return editorFinishedMsg{err: err} }
+	}
+	path := file.Name()
+	_, err = file.WriteString(cmd + "\n")
+	file.Close()
+	if err != nil {
+		os.Remove(path)
+		return func() tea.Msg { return editorFinishedMsg{err: err} }
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), path)
+
+	c := exec.Command(args[0], args[1:]...)
+	return tea.ExecProcess(c, func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return editorFinishedMsg{err: err}
+		}
+		content, err := os.ReadFile(path)
+		return editorFinishedMsg{cmd: strings.TrimSpace(string(content)), err: err}
+	})
+}
+
+// Styles, set from the theme when an interface starts
 var (
-	TitleStyle        = lipgloss.NewStyle()
-	ItemStyle         = lipgloss.NewStyle().Padding(0, 1)
-	SelectedItemStyle = lipgloss.NewStyle().Foreground(White).Background(SlateBlue).Padding(0, 1)
-	CheckedStyle      = lipgloss.NewStyle().Foreground(SoftGreen)
-	UncheckedStyle    = lipgloss.NewStyle().Foreground(NeuralGrey)
-	HelpStyle         = lipgloss.NewStyle().Foreground(MutedGray)
-	KeyStyle          = lipgloss.NewStyle().Foreground(WarmOrange).Bold(true)
-	TableHeaderStyle  = lipgloss.NewStyle().Foreground(SoftGreen).Bold(true)
+	TitleStyle        lipgloss.Style
+	ItemStyle         lipgloss.Style
+	SelectedItemStyle lipgloss.Style
+	CheckedStyle      lipgloss.Style
+	UncheckedStyle    lipgloss.Style
+	HelpStyle         lipgloss.Style
+	KeyStyle          lipgloss.Style
+	TableHeaderStyle  lipgloss.Style
+	PaneStyle         lipgloss.Style
+	NoticeStyle       lipgloss.Style
 )
 
 // keybindings
 var (
-	NavigateKey1 = KeyStyle.Render("↑/↓")
-	NavigateKey2 = KeyStyle.Render("k/j")
-	ProceedKey   = KeyStyle.Render("Enter")
-	RerunKey     = KeyStyle.Render("r")
-	DeleteKey1   = KeyStyle.Render("Backspace")
-	DeleteKey2   = KeyStyle.Render("d")
-	ExitKey1     = KeyStyle.Render("Ctrl+c")
-	ExitKey2     = KeyStyle.Render("q")
+	NavigateKey1, NavigateKey2, ProceedKey, RerunKey, EditorKey, ExplainKey,
+	PreviewKey, PinKey, DeleteKey1, DeleteKey2, NextKey, ModelKey, BackKey,
+	ExitKey1, ExitKey2, LayoutKey string
 )
 
 // words
 var (
-	Use        = HelpStyle.Render("Use")
-	Press      = HelpStyle.Render("Press")
-	Or         = HelpStyle.Render("or")
-	ToNavigate = HelpStyle.Render("to navigate")
-	ToProceed  = HelpStyle.Render("to proceed")
-	ToExit     = HelpStyle.Render("to exit")
-	ToDelete   = HelpStyle.Render("to delete entry")
-	ToRerun    = HelpStyle.Render("to rerun")
+	Use, Press, Or, ToNavigate, ToProceed, ToExit, ToDelete, ToRerun, ToEdit,
+	ToExplain, ToPreview, ToPin, ToModel, ToAskWith, ToBack, ToNext, ToAnswer,
+	ToLayout string
 )
 
 // help messages
 var (
-	Navigate = fmt.Sprintf("  %s %s %s %s %s\n", Use, NavigateKey1, Or, NavigateKey2, ToNavigate)
-	Proceed  = fmt.Sprintf("  %s %s %s\n", Press, ProceedKey, ToProceed)
-	Rerun    = fmt.Sprintf("  %s %s %s\n", Press, RerunKey, ToRerun)
-	Delete   = fmt.Sprintf("  %s %s %s %s %s\n", Press, DeleteKey1, Or, DeleteKey2, ToDelete)
-	Exit     = fmt.Sprintf("  %s %s %s %s %s\n", Press, ExitKey1, Or, ExitKey2, ToExit)
+	Navigate, Proceed, Rerun, Edit, Explain, Preview, Pin, Model, AskWith, Back,
+	Delete, Next, Answer, Layout, ReadOnly, Exit string
 )
 
+// applyTheme sets the styles, and renders the keybindings and help messages
+// with them
+func applyTheme(theme Theme) {
+	TitleStyle = lipgloss.NewStyle()
+	ItemStyle = lipgloss.NewStyle().Padding(0, 1)
+	SelectedItemStyle = lipgloss.NewStyle().Foreground(theme.SelectedText).Background(theme.Selected).Padding(0, 1)
+	CheckedStyle = lipgloss.NewStyle().Foreground(theme.Positive)
+	UncheckedStyle = lipgloss.NewStyle().Foreground(theme.Unchecked)
+	HelpStyle = lipgloss.NewStyle().Foreground(theme.Muted)
+	KeyStyle = lipgloss.NewStyle().Foreground(theme.Accent).Bold(true)
+	TableHeaderStyle = lipgloss.NewStyle().Foreground(theme.Positive).Bold(true)
+	PaneStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(theme.Border).Padding(0, 1)
+	NoticeStyle = lipgloss.NewStyle().Foreground(theme.Notice).Bold(theme.BoldNotices)
+
+	NavigateKey1 = KeyStyle.Render("↑/↓")
+	NavigateKey2 = KeyStyle.Render("k/j")
+	ProceedKey = KeyStyle.Render("Enter")
+	RerunKey = KeyStyle.Render("r")
+	EditorKey = KeyStyle.Render("v")
+	ExplainKey = KeyStyle.Render("x")
+	PreviewKey = KeyStyle.Render("i")
+	PinKey = KeyStyle.Render("p")
+	DeleteKey1 = KeyStyle.Render("Backspace")
+	DeleteKey2 = KeyStyle.Render("d")
+	NextKey = KeyStyle.Render("Tab")
+	ModelKey = KeyStyle.Render("m")
+	BackKey = KeyStyle.Render("Esc")
+	ExitKey1 = KeyStyle.Render("Ctrl+c")
+	ExitKey2 = KeyStyle.Render("q")
+	LayoutKey = KeyStyle.Render("l")
+
+	Use = HelpStyle.Render("Use")
+	Press = HelpStyle.Render("Press")
+	Or = HelpStyle.Render("or")
+	ToNavigate = HelpStyle.Render("to navigate")
+	ToProceed = HelpStyle.Render("to proceed")
+	ToExit = HelpStyle.Render("to exit")
+	ToDelete = HelpStyle.Render("to delete entry")
+	ToRerun = HelpStyle.Render("to rerun")
+	ToEdit = HelpStyle.Render("to edit in $EDITOR")
+	ToExplain = HelpStyle.Render("to toggle the explanation")
+	ToPreview = HelpStyle.Render("to preview package changes")
+	ToPin = HelpStyle.Render("to keep a suggestion when rerunning")
+	ToModel = HelpStyle.Render("to ask again with another model")
+	ToAskWith = HelpStyle.Render("to ask again with this model")
+	ToBack = HelpStyle.Render("to go back")
+	ToNext = HelpStyle.Render("to move between questions")
+	ToAnswer = HelpStyle.Render("to answer (leave blank to skip)")
+	ToLayout = HelpStyle.Render("to switch between one and two panes")
+
+	Navigate = fmt.Sprintf("  %s %s %s %s %s\n", Use, NavigateKey1, Or, NavigateKey2, ToNavigate)
+	Proceed = fmt.Sprintf("  %s %s %s\n", Press, ProceedKey, ToProceed)
+	Rerun = fmt.Sprintf("  %s %s %s\n", Press, RerunKey, ToRerun)
+	Edit = fmt.Sprintf("  %s %s %s\n", Press, EditorKey, ToEdit)
+	Explain = fmt.Sprintf("  %s %s %s\n", Press, ExplainKey, ToExplain)
+	Preview = fmt.Sprintf("  %s %s %s\n", Press, PreviewKey, ToPreview)
+	Pin = fmt.Sprintf("  %s %s %s\n", Press, PinKey, ToPin)
+	Model = fmt.Sprintf("  %s %s %s\n", Press, ModelKey, ToModel)
+	AskWith = fmt.Sprintf("  %s %s %s\n", Press, ProceedKey, ToAskWith)
+	Back = fmt.Sprintf("  %s %s %s\n", Press, BackKey, ToBack)
+	Delete = fmt.Sprintf("  %s %s %s %s %s\n", Press, DeleteKey1, Or, DeleteKey2, ToDelete)
+	Next = fmt.Sprintf("  %s %s %s\n", Press, NextKey, ToNext)
+	Answer = fmt.Sprintf("  %s %s %s\n", Press, ProceedKey, ToAnswer)
+	Layout = fmt.Sprintf("  %s %s %s\n", Press, LayoutKey, ToLayout)
+	ReadOnly = fmt.Sprintf("  %s\n", HelpStyle.Render("Read-only: entries can't be deleted"))
+	Exit = fmt.Sprintf("  %s %s %s %s %s\n", Press, ExitKey1, Or, ExitKey2, ToExit)
+}
+
 func (m *CmdSelector) View() string {
+	if m.pickingModel {
+		return m.modelPickerView()
+	}
+	if m.split && m.width > 0 && len(m.entries) > 0 {
+		return m.splitView()
+	}
+
 	s := "\nChoose a command:\n"
 	for i, choice := range m.cmds {
 		cursor := " "
@@ -130,31 +513,202 @@ func (m *CmdSelector) View() string {
 			style = SelectedItemStyle
 		}
 
-		s += fmt.Sprintf("%s %s\n", cursor, style.Render(choice))
+		pin := " "
+		if m.entries[i].Pinned {
+			pin = CheckedStyle.Render("●")
+		}
+
+		s += fmt.Sprintf("%s%s %s\n", cursor, pin, style.Render(choice))
+	}
+	if m.streaming {
+		s += "  " + HelpStyle.Render("Generating suggestions…") + "\n"
+	}
+
+	if m.showExplanation && len(m.entries) > 0 {
+		s += "\n" + m.explanationView() + "\n"
 	}
 
-	return s + "\n\n" + Navigate + Rerun + Proceed + Exit
+	if m.showPreview && len(m.entries) > 0 {
+		s += "\n" + m.previewView() + "\n"
+	}
+
+	if m.notice != "" {
+		s += "\n" + NoticeStyle.Render(m.notice) + "\n"
+	}
+
+	return s + "\n\n" + m.help()
+}
+
+func (m *CmdSelector) help() string {
+	help := Navigate + Rerun + Pin + Model + Edit + Explain + Preview
+	if m.width > 0 {
+		help += Layout
+	}
+	return help + Proceed + Exit
 }
 
-func SelectCmd(cmds []CmdEntry) (string, error) {
-	maxCmdLength := 0
-	for _, entry := range cmds {
-		if len(entry.Cmd) > maxCmdLength {
-			maxCmdLength = len(entry.Cmd)
+// splitView lists the commands alone on the left, and shows the details of
+// the one under the cursor on the right: the whole command, its comment and
+// tradeoff, warnings, and the explanation and preview when toggled
+func (m *CmdSelector) splitView() string {
+	listWidth := m.width * 2 / 5
+	// The cursor, pin, item padding and a gap before the pane
+	itemWidth := listWidth - 6
+
+	list := []string{}
+	for i, entry := range m.entries {
+		cursor := " "
+		style := ItemStyle
+		if i == m.cursor {
+			cursor = ">"
+			style = SelectedItemStyle
+		}
+		pin := " "
+		if entry.Pinned {
+			pin = CheckedStyle.Render("●")
 		}
+		list = append(list, fmt.Sprintf("%s%s %s", cursor, pin, style.Render(truncateLine(entry.Cmd, itemWidth))))
 	}
+	if m.streaming {
+		list = append(list, "  "+HelpStyle.Render("Generating suggestions…"))
+	}
+	left := lipgloss.NewStyle().Width(listWidth).Render(strings.Join(list, "\n"))
 
-	commentedCmds := make([]string, len(cmds))
-	for i, entry := range cmds {
-		if entry.Comment != "" {
-			padding := strings.Repeat(" ", maxCmdLength-len(entry.Cmd)+2)
-			commentedCmds[i] = fmt.Sprintf("%s%s# %s", entry.Cmd, padding, entry.Comment)
-		} else {
-			commentedCmds[i] = entry.Cmd
+	// The border takes a column on each side
+	right := PaneStyle.Width(m.width - listWidth - 2).Render(m.detailView())
+
+	s := "\nChoose a command:\n" + lipgloss.JoinHorizontal(lipgloss.Top, left, right) + "\n"
+	if m.notice != "" {
+		s += "\n" + NoticeStyle.Render(m.notice) + "\n"
+	}
+	return s + "\n\n" + m.help()
+}
+
+// detailView describes the suggestion under the cursor for the right pane
+func (m *CmdSelector) detailView() string {
+	entry := m.entries[m.cursor]
+	lines := []string{KeyStyle.Render(entry.Cmd)}
+	if entry.Comment != "" {
+		lines = append(lines, "", entry.Comment)
+	}
+	if entry.Tradeoff != "" {
+		lines = append(lines, HelpStyle.Render("Tradeoff: ")+entry.Tradeoff)
+	}
+	if entry.Workdir != "" {
+		lines = append(lines, HelpStyle.Render("Run from: ")+entry.Workdir)
+	}
+	if entry.Source != "" {
+		lines = append(lines, HelpStyle.Render("Source: ")+entry.Source)
+	}
+	for _, warning := range entry.Warnings {
+		lines = append(lines, NoticeStyle.Render("⚠ "+warning))
+	}
+	if m.showExplanation {
+		lines = append(lines, "", m.explanationText())
+	}
+	if m.showPreview {
+		lines = append(lines, "", m.previewText())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncateLine shortens the text to the width, marking that it was cut
+func truncateLine(text string, width int) string {
+	text = strings.ReplaceAll(text, "\n", " ")
+	runes := []rune(text)
+	if width < 1 || len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func (m *CmdSelector) modelPickerView() string {
+	s := "\nAsk again with:\n"
+	for i, model := range m.models {
+		cursor := " "
+		style := ItemStyle
+		if i == m.modelCursor {
+			cursor = ">"
+			style = SelectedItemStyle
 		}
+
+		current := ""
+		if model == m.currentModel {
+			current = HelpStyle.Render(" (current)")
+		}
+		s += fmt.Sprintf("%s %s%s\n", cursor, style.Render(model), current)
+	}
+	if len(m.models) == 0 {
+		s += NoticeStyle.Render("No other models are available.") + "\n"
 	}
 
-	model := NewCmdSelector(commentedCmds)
+	return s + "\n\n" + Navigate + AskWith + Back + Exit
+}
+
+// pinned returns the suggestions to keep across a rerun
+func (m *CmdSelector) pinned() []CmdEntry {
+	pinned := []CmdEntry{}
+	for _, entry := range m.entries {
+		if entry.Pinned {
+			pinned = append(pinned, entry)
+		}
+	}
+	return pinned
+}
+
+func (m *CmdSelector) explanationView() string {
+	return PaneStyle.Render(m.explanationText())
+}
+
+func (m *CmdSelector) explanationText() string {
+	// The variables are local, so they show while the explanation loads
+	var env []string
+	if vars := ReferencedEnvVars(m.entries[m.cursor].Cmd); len(vars) > 0 {
+		env = append([]string{"", "Environment:"}, envVarLines(vars)...)
+	}
+
+	explanation, ok := m.explanations[m.entries[m.cursor].Cmd]
+	switch {
+	case ok:
+	case m.explainErr != nil:
+		return strings.Join(append([]string{"Could not explain this command."}, env...), "\n")
+	default:
+		return strings.Join(append([]string{"Explaining..."}, env...), "\n")
+	}
+
+	width := 0
+	for _, part := range explanation.Parts {
+		width = max(width, len(part.Part))
+	}
+
+	lines := []string{explanation.Summary, ""}
+	for _, part := range explanation.Parts {
+		lines = append(lines, fmt.Sprintf("%s  %s", KeyStyle.Render(fmt.Sprintf("%-*s", width, part.Part)), part.Description))
+	}
+	return strings.Join(append(lines, env...), "\n")
+}
+
+func (m *CmdSelector) previewView() string {
+	return PaneStyle.Render(m.previewText())
+}
+
+func (m *CmdSelector) previewText() string {
+	preview, ok := m.previews[m.entries[m.cursor].Cmd]
+	switch {
+	case !ok:
+		return "Previewing the package changes..."
+	case preview.Err != nil:
+		return fmt.Sprintf("No preview: %v.", preview.Err)
+	}
+	return preview.Output
+}
+
+// Whether this build includes the interactive Bubble Tea interface
+const tuiAvailable = true
+
+func SelectCmd(cmds []CmdEntry, opts SelectOptions) (string, error) {
+	useConfiguredTheme()
+	model := NewCmdSelector(cmds, opts)
 	p := tea.NewProgram(model)
 
 	_, err := p.Run()
@@ -162,21 +716,26 @@ func SelectCmd(cmds []CmdEntry) (string, error) {
 		return "", err
 	}
 
+	if model.streamErr != nil {
+		return "", model.streamErr
+	}
+
 	if model.quit {
 		return "", QuitError{}
 	}
 
 	if model.rerun {
-		return "", RerunError{}
+		return "", RerunError{Pinned: model.pinned(), Model: model.model, State: model.state()}
 	}
 
-	return cmds[model.cursor].Cmd, nil
+	return model.selected, nil
 }
 
 type Table struct {
-	table   table.Model
-	quit    bool
-	ogTotal float64
+	table    table.Model
+	quit     bool
+	ogTotal  float64
+	readonly bool
 }
 
 func (m Table) Init() tea.Cmd {
@@ -192,6 +751,10 @@ func (m Table) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.quit = true
 			return m, tea.Quit
 		case "backspace", "d":
+			if m.readonly {
+				return m, nil
+			}
+
 			selectedRow := m.table.SelectedRow()
 			if selectedRow[0] == "TOTAL" {
 				return m, nil
@@ -231,6 +794,11 @@ func (m Table) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m Table) View() string {
+	if m.readonly {
+		return m.table.View() +
+			strings.Repeat("\n", 3) +
+			Navigate + ReadOnly + Exit
+	}
 	return m.table.View() +
 		strings.Repeat("\n", 3) +
 		Navigate + Delete + Exit
@@ -279,8 +847,12 @@ func NewTableModel(costs Costs) Table {
 	return Table{table: t, quit: false, ogTotal: totalCost}
 }
 
-func CostTableModel(costs Costs) error {
+// CostTableModel displays the costs, allowing entries to be deleted unless
+// readonly
+func CostTableModel(costs Costs, readonly bool) error {
+	useConfiguredTheme()
 	model := NewTableModel(costs)
+	model.readonly = readonly
 	p := tea.NewProgram(model)
 
 	_, err := p.Run()
@@ -294,3 +866,146 @@ func CostTableModel(costs Costs) error {
 
 	return nil
 }
+
+type StatsDashboard struct {
+	stats HistoryStats
+	quit  bool
+}
+
+func (m StatsDashboard) Init() tea.Cmd {
+	return nil
+}
+
+func (m StatsDashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q", "esc", "enter":
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m StatsDashboard) View() string {
+	topics := []string{TableHeaderStyle.Render("Most-asked topics")}
+	for _, topic := range m.stats.Topics {
+		topics = append(topics, fmt.Sprintf("%-20s %s", topic.Topic, HelpStyle.Render(fmt.Sprint(topic.Count))))
+	}
+
+	hours := []string{
+		TableHeaderStyle.Render("Busiest hours"),
+		KeyStyle.Render(hoursSparkline(m.stats.Hours)),
+		HelpStyle.Render("0     6     12    18   23"),
+		"",
+		fmt.Sprintf("Busiest at %02d:00", busiestHour(m.stats.Hours)),
+	}
+
+	figures := []string{
+		TableHeaderStyle.Render("Usage"),
+		fmt.Sprintf("Questions asked      %d", m.stats.Questions),
+		fmt.Sprintf("Avg tokens/question  %.0f", m.stats.AverageTokens),
+		fmt.Sprintf("Cache hit rate       %.0f%%", m.stats.CacheHitRate*100),
+		fmt.Sprintf("Acceptance rate      %.0f%%", m.stats.AcceptanceRate*100),
+	}
+
+	panes := lipgloss.JoinHorizontal(lipgloss.Top,
+		PaneStyle.Render(strings.Join(figures, "\n")),
+		PaneStyle.Render(strings.Join(hours, "\n")),
+		PaneStyle.Render(strings.Join(topics, "\n")),
+	)
+	return panes + strings.Repeat("\n", 3) + Exit
+}
+
+func StatsDashboardModel(stats HistoryStats) error {
+	useConfiguredTheme()
+	_, err := tea.NewProgram(StatsDashboard{stats: stats}).Run()
+	return err
+}
+
+// GuideForm asks the clarifying questions for a tool, one text input each
+type GuideForm struct {
+	tool      string
+	questions []GuideQuestion
+	inputs    []textinput.Model
+	focus     int
+	quit      bool
+}
+
+func NewGuideForm(tool string, questions []GuideQuestion) GuideForm {
+	inputs := make([]textinput.Model, len(questions))
+	for i, question := range questions {
+		inputs[i] = textinput.New()
+		inputs[i].Placeholder = question.Placeholder
+		inputs[i].Prompt = "> "
+	}
+	inputs[0].Focus()
+	return GuideForm{tool: tool, questions: questions, inputs: inputs}
+}
+
+func (m GuideForm) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m GuideForm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			m.quit = true
+			return m, tea.Quit
+		case "enter":
+			if m.focus == len(m.inputs)-1 {
+				return m, tea.Quit
+			}
+			return m.moveFocus(1), textinput.Blink
+		case "tab", "down":
+			return m.moveFocus(1), textinput.Blink
+		case "shift+tab", "up":
+			return m.moveFocus(-1), textinput.Blink
+		}
+	}
+
+	var cmd tea.Cmd
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	return m, cmd
+}
+
+func (m GuideForm) moveFocus(delta int) GuideForm {
+	m.inputs[m.focus].Blur()
+	m.focus = (m.focus + delta + len(m.inputs)) % len(m.inputs)
+	m.inputs[m.focus].Focus()
+	return m
+}
+
+func (m GuideForm) View() string {
+	s := TitleStyle.Render(fmt.Sprintf("A few questions about the %s command:", m.tool)) + "\n\n"
+	for i, question := range m.questions {
+		label := ItemStyle.Render(question.Label)
+		if i == m.focus {
+			label = SelectedItemStyle.Render(question.Label)
+		}
+		s += label + "\n" + m.inputs[i].View() + "\n\n"
+	}
+	return s + Answer + Next + fmt.Sprintf("  %s %s %s\n", Press, ExitKey1, ToExit)
+}
+
+// AskGuideQuestions runs the form and returns the answers in question order
+func AskGuideQuestions(tool string, questions []GuideQuestion) ([]string, error) {
+	useConfiguredTheme()
+	m, err := tea.NewProgram(NewGuideForm(tool, questions)).Run()
+	if err != nil {
+		return nil, err
+	}
+
+	form := m.(GuideForm)
+	if form.quit {
+		return nil, QuitError{}
+	}
+
+	answers := make([]string, len(form.inputs))
+	for i, input := range form.inputs {
+		answers[i] = input.Value()
+	}
+	return answers, nil
+}
@@ -0,0 +1,24 @@
+//go:build notui
+
+package main
+
+// Whether this build includes the interactive Bubble Tea interface
+const tuiAvailable = false
+
+func SelectCmd(cmds []CmdEntry, opts SelectOptions) (string, error) {
+	return "", TUIUnavailableError{}
+}
+
+func CostTableModel(costs Costs, readonly bool) error {
+	PrintCosts(costs)
+	return nil
+}
+
+func StatsDashboardModel(stats HistoryStats) error {
+	PrintStats(stats)
+	return nil
+}
+
+func AskGuideQuestions(tool string, questions []GuideQuestion) ([]string, error) {
+	return nil, TUIUnavailableError{}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	costsAPIURL = "https://api.openai.com/v1/organization/costs"
+	// The costs API returns at most this many daily buckets per page
+	costsAPIPageSize = 180
+)
+
+// The costs API needs an admin key, which is separate from the API key used
+// for requests
+func adminKey() (string, error) {
+	key := os.Getenv("CFOR_OPENAI_ADMIN_KEY")
+	if key == "" {
+		key = os.Getenv("OPENAI_ADMIN_KEY")
+	}
+	return apiKeyOrCommand(keyCommandOpenAIAdmin, key)
+}
+
+type costsPage struct {
+	Data []struct {
+		StartTime int64 `json:"start_time"`
+		Results   []struct {
+			Amount struct {
+				Value float64 `json:"value"`
+			} `json:"amount"`
+		} `json:"results"`
+	} `json:"data"`
+	HasMore  bool   `json:"has_more"`
+	NextPage string `json:"next_page"`
+}
+
+// GetProviderCosts fetches the daily costs billed by OpenAI since the given
+// day, optionally for a single project. Without a project, the costs cover
+// all usage of the organization, not only cfor's.
+func GetProviderCosts(since time.Time, project string) (Costs, error) {
+	if err := checkOnline("Fetching the billed costs"); err != nil {
+		return nil, err
+	}
+	key, err := adminKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, &AdminKeyMissingError{}
+	}
+
+	query := url.Values{}
+	query.Set("start_time", strconv.FormatInt(since.Unix(), 10))
+	query.Set("bucket_width", "1d")
+	query.Set("limit", strconv.Itoa(costsAPIPageSize))
+	if project != "" {
+		query.Set("project_ids", project)
+	}
+
+	costs := Costs{}
+	for {
+		page, err := fetchCostsPage(key, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, bucket := range page.Data {
+			day := Today(time.Unix(bucket.StartTime, 0).UTC().Format("2006-01-02"))
+			for _, result := range bucket.Results {
+				costs[day] += Cost(result.Amount.Value)
+			}
+		}
+
+		if !page.HasMore || page.NextPage == "" {
+			return costs, nil
+		}
+		query.Set("page", page.NextPage)
+	}
+}
+
+func fetchCostsPage(key string, query url.Values) (costsPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, costsAPIURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return costsPage{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := newHTTPClient().Do(req)
+	if err != nil {
+		return costsPage{}, &OpenAIRequestError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return costsPage{}, &OpenAIRequestError{
+			Err:       fmt.Errorf("costs API returned %s", resp.Status),
+			RequestID: requestID(resp),
+		}
+	}
+
+	var page costsPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return costsPage{}, &JSONParseError{Err: err, RequestID: requestID(resp)}
+	}
+	return page, nil
+}
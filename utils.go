@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -20,6 +22,33 @@ func costFilepath() string {
 	return filepath.Join(dir, "cfor", "cost.json")
 }
 
+// cacheDir returns the directory for data that can be safely regenerated
+func cacheDir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(homeDir, ".cache")
+	}
+	return filepath.Join(dir, "cfor")
+}
+
+// stateDir returns the directory for state that should persist across runs
+// but isn't worth backing up, such as logs
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(dir, "cfor")
+}
+
 type Today string
 type Cost float64
 type Costs map[Today]Cost
@@ -29,7 +58,11 @@ func GetCosts() (Costs, error) {
 	if costFilePath == "" {
 		return nil, fmt.Errorf("could not determine cost file path")
 	}
+	return ReadCosts(costFilePath)
+}
 
+// ReadCosts reads a cost file, such as one copied from another machine
+func ReadCosts(costFilePath string) (Costs, error) {
 	if _, err := os.Stat(costFilePath); os.IsNotExist(err) {
 		return nil, CostFileNotFoundError{}
 	}
@@ -47,11 +80,29 @@ func GetCosts() (Costs, error) {
 	return costs, nil
 }
 
-func UpdateCost(cost float64) error {
+// RecordUsage tracks the cost and tokens of a request, if one was made, and
+// its latency or cache hit in the local metrics
+func RecordUsage[T any](result ChatResult[T]) {
+	if result.Cost > 0 || result.Tokens > 0 {
+		UpdateUsage(result.Model, result.RequestID, result.Tokens, float64(result.Cost))
+	}
+	if result.Cached || result.Latency > 0 {
+		AppendMetric(MetricEvent{Kind: MetricRequest, Latency: result.Latency, Cached: result.Cached})
+	}
+}
+
+// UpdateUsage adds the cost to today's total and logs the request's tokens,
+// which are kept even when the model's pricing is unknown. A request already
+// logged, e.g. recorded again after a crash, isn't counted twice.
+func UpdateUsage(model, requestID string, tokens int64, cost float64) error {
 	costFilePath := costFilepath()
 	if costFilePath == "" {
 		return fmt.Errorf("could not determine cost file path")
 	}
+	if requestID != "" && recordedRequest(costLogFilepath(), requestID) {
+		logVerbose("request %s already recorded in the cost log", requestID)
+		return nil
+	}
 
 	err := os.MkdirAll(filepath.Dir(costFilePath), 0755)
 	if err != nil {
@@ -67,7 +118,126 @@ func UpdateCost(cost float64) error {
 		}
 	}
 	costs[Today(today)] += Cost(cost)
-	return writeCosts(costs)
+	if err := writeCosts(costs); err != nil {
+		return err
+	}
+
+	return appendCostEntry(CostEntry{
+		Time:      time.Now(),
+		Cost:      Cost(cost),
+		Model:     model,
+		RequestID: requestID,
+		Tokens:    tokens,
+		Profile:   currentProfile(),
+		Project:   currentProject(),
+	})
+}
+
+// CostEntry is a single request's cost along with who incurred it, kept so
+// that costs can be attributed (and billed) per profile and per project
+type CostEntry struct {
+	Time    time.Time `json:"time"`
+	Cost    Cost      `json:"cost"`
+	Model   string    `json:"model,omitempty"`
+	Tokens  int64     `json:"tokens,omitempty"`
+	Profile string    `json:"profile,omitempty"`
+	Project string    `json:"project,omitempty"`
+	// The provider's ID of the request, so that it's only counted once
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func costLogFilepath() string {
+	costFilePath := costFilepath()
+	if costFilePath == "" {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(costFilePath), "cost_log.jsonl")
+}
+
+// currentProfile returns the profile costs are attributed to
+func currentProfile() string {
+	if profile := os.Getenv("CFOR_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// currentProject returns the root of the git repository containing the
+// working directory, or the working directory itself outside of one
+func currentProject() string {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err == nil {
+		return strings.TrimSpace(string(out))
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+func appendCostEntry(entry CostEntry) error {
+	costLogPath := costLogFilepath()
+	if costLogPath == "" {
+		return fmt.Errorf("could not determine cost log path")
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cost entry: %w", err)
+	}
+
+	file, err := os.OpenFile(costLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open cost log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write cost log: %w", err)
+	}
+
+	return nil
+}
+
+// GetCostEntries returns every request recorded in the cost log
+func GetCostEntries() ([]CostEntry, error) {
+	costLogPath := costLogFilepath()
+	if costLogPath == "" {
+		return nil, fmt.Errorf("could not determine cost log path")
+	}
+
+	data, err := os.ReadFile(costLogPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, CostFileNotFoundError{}
+		}
+		return nil, err
+	}
+
+	entries := []CostEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var entry CostEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cost entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// GroupCosts sums the cost entries by the key returned for each entry
+func GroupCosts(entries []CostEntry, key func(CostEntry) string) map[string]Cost {
+	totals := map[string]Cost{}
+	for _, entry := range entries {
+		totals[key(entry)] += entry.Cost
+	}
+	return totals
 }
 
 func DeleteCostEntry(date Today) error {
@@ -85,6 +255,16 @@ func DeleteCostEntry(date Today) error {
 	return writeCosts(costs)
 }
 
+// costFileWritable reports whether the cost file can be modified
+func costFileWritable(costFilePath string) bool {
+	f, err := os.OpenFile(costFilePath, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
 func writeCosts(costs Costs) error {
 	costFilePath := costFilepath()
 	if costFilePath == "" {
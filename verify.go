@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reading man pages is local but not free, so verification gives up on tools
+// it couldn't check in time rather than delaying the selector
+const flagVerificationTimeout = 2 * time.Second
+
+// Wrappers whose first argument is the actual command being run
+var commandWrappers = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "time": true, "nohup": true,
+	"nice": true, "exec": true, "command": true, "xargs": true, "watch": true,
+}
+
+// Separators of the commands in pipelines and command lists
+var commandSeparatorPattern = regexp.MustCompile(`\|\||&&|[|;&]`)
+
+// Variable assignments prefixing a command, e.g. FOO=1 cmd
+var envAssignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// commandFlags maps each binary invoked by a command line to the flags passed
+// to it
+func commandFlags(cmd string) map[string][]string {
+	flags := map[string][]string{}
+	for _, segment := range commandSeparatorPattern.Split(cmd, -1) {
+		fields := shellFields(segment)
+		for len(fields) > 0 && (envAssignmentPattern.MatchString(fields[0]) || commandWrappers[fields[0]]) {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		binary := fields[0]
+		if _, ok := flags[binary]; !ok {
+			flags[binary] = []string{}
+		}
+		for _, field := range fields[1:] {
+			if field == "--" {
+				break
+			}
+			if isFlag(field) {
+				flags[binary] = append(flags[binary], strings.SplitN(field, "=", 2)[0])
+			}
+		}
+	}
+	return flags
+}
+
+func isFlag(field string) bool {
+	if len(field) < 2 || field[0] != '-' {
+		return false
+	}
+	// Negative numbers such as `head -5` or `nice -n -10`
+	if '0' <= field[1] && field[1] <= '9' {
+		return false
+	}
+	return true
+}
+
+// shellFields splits a command into words, keeping quoted strings together
+func shellFields(s string) []string {
+	fields := []string{}
+	var current strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// flagDocumented reports whether the flag appears in the manual. Bundled
+// short flags (-la) are accepted when each letter is documented on its own.
+func flagDocumented(manual, flag string) bool {
+	if containsFlag(manual, flag) {
+		return true
+	}
+	if strings.HasPrefix(flag, "--") {
+		return false
+	}
+	for _, letter := range flag[1:] {
+		if !containsFlag(manual, "-"+string(letter)) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFlag looks for the flag as a whole word, so that -r doesn't match
+// inside -rf or --recursive
+func containsFlag(manual, flag string) bool {
+	pattern := `(^|[^\w-])` + regexp.QuoteMeta(flag) + `($|[^\w-])`
+	matched, _ := regexp.MatchString(pattern, manual)
+	return matched
+}
+
+// VerifyFlags cross-checks the flags of each suggestion against the local man
+// page (or --help output) of the installed binaries and warns about flags
+// that don't exist on this system, the most common class of hallucination
+func VerifyFlags(cmds []CmdEntry) []CmdEntry {
+	ctx, cancel := context.WithTimeout(context.Background(), flagVerificationTimeout)
+	defer cancel()
+
+	binaries := map[string]bool{}
+	for _, entry := range cmds {
+		for binary := range commandFlags(entry.Cmd) {
+			if _, err := exec.LookPath(binary); err == nil {
+				binaries[binary] = true
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	manuals := map[string]string{}
+	for binary := range binaries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manual, err := toolManual(ctx, binary)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			manuals[binary] = manual
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	verified := make([]CmdEntry, len(cmds))
+	for i, entry := range cmds {
+		unknown := []string{}
+		for binary, flags := range commandFlags(entry.Cmd) {
+			manual, ok := manuals[binary]
+			if !ok {
+				continue
+			}
+			for _, flag := range flags {
+				if !flagDocumented(manual, flag) {
+					unknown = append(unknown, flag)
+				}
+			}
+		}
+		if len(unknown) > 0 {
+			entry.Warnings = append(entry.Warnings, fmt.Sprintf("unknown flag: %s", strings.Join(unknown, " ")))
+		}
+		verified[i] = entry
+	}
+	return verified
+}
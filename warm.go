@@ -0,0 +1,69 @@
+package main
+
+import "slices"
+
+// The model answering for cfor warm unless configured
+const defaultWarmModel = "gpt-4o-mini"
+
+// WarmQuestions returns the configured questions followed by the most asked
+// ones from the history, without duplicates
+func WarmQuestions(config WarmConfig) ([]string, error) {
+	questions := []string{}
+	for _, question := range config.Questions {
+		if !slices.Contains(questions, question) {
+			questions = append(questions, question)
+		}
+	}
+
+	if config.MostAsked > 0 {
+		history, err := GetHistory()
+		if err != nil {
+			return nil, err
+		}
+		for _, question := range MostAskedQuestions(history, config.MostAsked) {
+			if !slices.Contains(questions, question) {
+				questions = append(questions, question)
+			}
+		}
+	}
+
+	return questions, nil
+}
+
+// WarmCache answers the questions through the batch API with the given model
+// and caches the answers as if the interactive model had given them, so the
+// next lookups of these questions are served from the cache
+func WarmCache(questions []string, model string, progress func(status string)) ([]BatchResult, error) {
+	warmModel, err := checkModel(model)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := DefaultGenerateOptions()
+	prompts := make([]Prompt, len(questions))
+	cachePaths := make([]string, len(questions))
+	for i, question := range questions {
+		prompt, err := BuildCmdsPrompt(question, opts)
+		if err != nil {
+			return nil, err
+		}
+		cachePaths[i] = answerCacheFilepath(answerCacheKey(prompt, question))
+		prompt.Model = warmModel
+		prompts[i] = prompt
+	}
+
+	results, err := sendBatch(questions, prompts, opts, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if result.Error != "" || cachePaths[i] == "" {
+			continue
+		}
+		if err := writeAnswerCache(cachePaths[i], Cmds{Cmds: result.Cmds}); err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// The page of the web interface, which fetches everything it shows from the
+// API below
+//
+//go:embed web.html
+var webPage []byte
+
+// Address `cfor serve --web` listens on unless given
+const defaultWebAddr = "127.0.0.1:8788"
+
+// Most recent history entries the web interface lists
+const webHistoryLimit = 200
+
+// webCost is a total of the costs page, for a day, a model or a project
+type webCost struct {
+	Name string `json:"name"`
+	Cost Cost   `json:"cost"`
+}
+
+// webCosts is what the costs page charts
+type webCosts struct {
+	Days     []webCost `json:"days"`
+	Models   []webCost `json:"models"`
+	Projects []webCost `json:"projects"`
+}
+
+// webFavorite is a saved snippet as the favorites page lists it. Only local
+// ones can be removed, by their index among them.
+type webFavorite struct {
+	Snippet
+	Shared bool `json:"shared"`
+	Index  int  `json:"index"`
+}
+
+// checkLoopbackAddr refuses to listen where other machines could connect, as
+// the web interface spends from the user's API key without asking who's there
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("the web interface only listens on localhost, not %q", host)
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// WebHandler serves the web interface: history, costs, favorites (the local
+// and shared snippets) and a box to ask questions in
+func WebHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", handleWebPage)
+	mux.HandleFunc("GET /api/history", handleWebHistory)
+	mux.HandleFunc("GET /api/costs", handleWebCosts)
+	mux.HandleFunc("GET /api/favorites", handleWebFavorites)
+	mux.HandleFunc("POST /api/favorites", handleWebAddFavorite)
+	mux.HandleFunc("DELETE /api/favorites/{index}", handleWebRemoveFavorite)
+	mux.HandleFunc("POST /api/ask", handleWebAsk)
+	return localOnly(mux)
+}
+
+// localOnly turns away what other sites could have the browser send: requests
+// naming another host, e.g. by rebinding their domain to 127.0.0.1, and
+// changes from another origin or as a form
+func localOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isLoopbackHost(host) {
+			writeServerError(w, http.StatusForbidden, "not_local", "only requests to localhost are answered")
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+					writeServerError(w, http.StatusForbidden, "cross_origin", "changes are only accepted from the web interface")
+					return
+				}
+			}
+			if r.Method == http.MethodPost {
+				if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+					writeServerError(w, http.StatusUnsupportedMediaType, "invalid_request", "expected a JSON body")
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleWebPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	w.Write(webPage)
+}
+
+func handleWebHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := GetHistory()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	entries := SearchHistory(history, r.URL.Query().Get("q"), webHistoryLimit)
+	// The prompts sent are long and only replaying needs them
+	for i := range entries {
+		entries[i].Prompt = ""
+		entries[i].Options = nil
+	}
+	writeJSON(w, map[string]any{"entries": entries})
+}
+
+func handleWebCosts(w http.ResponseWriter, r *http.Request) {
+	costs, err := GetCosts()
+	if err != nil && !errors.Is(err, CostFileNotFoundError{}) {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	entries, err := GetCostEntries()
+	if err != nil && !errors.Is(err, CostFileNotFoundError{}) {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	result := webCosts{Days: []webCost{}}
+	for day, cost := range costs {
+		result.Days = append(result.Days, webCost{Name: string(day), Cost: cost})
+	}
+	sort.Slice(result.Days, func(i, j int) bool { return result.Days[i].Name < result.Days[j].Name })
+	result.Models = sortedWebCosts(GroupCosts(entries, func(e CostEntry) string { return e.Model }))
+	result.Projects = sortedWebCosts(GroupCosts(entries, func(e CostEntry) string { return e.Project }))
+	writeJSON(w, result)
+}
+
+// sortedWebCosts lists the totals, most expensive first
+func sortedWebCosts(totals map[string]Cost) []webCost {
+	costs := []webCost{}
+	for name, cost := range totals {
+		costs = append(costs, webCost{Name: name, Cost: cost})
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].Cost != costs[j].Cost {
+			return costs[i].Cost > costs[j].Cost
+		}
+		return costs[i].Name < costs[j].Name
+	})
+	return costs
+}
+
+func handleWebFavorites(w http.ResponseWriter, r *http.Request) {
+	snippets, err := GetSnippets()
+	if err != nil {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	favorites := []webFavorite{}
+	for i, snippet := range snippets {
+		favorite := webFavorite{Snippet: snippet, Shared: snippet.Shared, Index: -1}
+		if !snippet.Shared {
+			// Local snippets come first
+			favorite.Index = i
+		}
+		favorites = append(favorites, favorite)
+	}
+	writeJSON(w, map[string]any{"favorites": favorites})
+}
+
+func handleWebAddFavorite(w http.ResponseWriter, r *http.Request) {
+	var snippet Snippet
+	if err := json.NewDecoder(r.Body).Decode(&snippet); err != nil || snippet.Cmd == "" {
+		writeServerError(w, http.StatusBadRequest, "invalid_request", "cmd is required")
+		return
+	}
+	if err := AddSnippet(snippet, false); err != nil {
+		writeServerError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleWebRemoveFavorite(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		writeServerError(w, http.StatusBadRequest, "invalid_request", "expected the favorite's index")
+		return
+	}
+	if err := RemoveLocalSnippet(index); err != nil {
+		writeServerError(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleWebAsk(w http.ResponseWriter, r *http.Request) {
+	var params getSuggestionsParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Question == "" {
+		writeServerError(w, http.StatusBadRequest, "invalid_request", "question is required")
+		return
+	}
+	result, cmds, err := askForSuggestions(params)
+	if err != nil {
+		writeServerError(w, http.StatusBadGateway, DescribeError(err).Code, err.Error())
+		return
+	}
+	writeJSON(w, map[string]any{"cmds": cmds, "model": result.Model, "cached": result.Cached})
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+const whichPrompt = `On the **%s** operating system (package manager: **%s**), which command line
+tools can be used for %s?
+
+List up to 8 candidate tools, preferring ones that ship with the operating
+system. For each, give the binary name, an example command doing the task with
+a very short inline comment, and the command to install it with the package
+manager.`
+
+type ToolCandidate struct {
+	Tool    string `json:"tool"`
+	Cmd     string `json:"cmd"`
+	Comment string `json:"comment"`
+	Install string `json:"install"`
+}
+
+type ToolCandidates struct {
+	Tools []ToolCandidate `json:"tools"`
+}
+
+var StructuredToolCandidatesSchema = sync.OnceValue(GenerateSchema[ToolCandidates])
+
+func toolCandidatesSchemaParam() openai.ResponseFormatJSONSchemaJSONSchemaParam {
+	return openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("tools"),
+		Description: openai.F("A list of tools able to do a task, with example and install commands."),
+		Schema:      openai.F(StructuredToolCandidatesSchema()),
+		Strict:      openai.Bool(true),
+	}
+}
+
+// Package managers in order of preference when several are installed
+var packageManagers = []string{"brew", "apt", "dnf", "yum", "pacman", "zypper", "apk", "nix-env"}
+
+// packageManager returns the first known package manager found on PATH
+func packageManager() string {
+	for _, manager := range packageManagers {
+		if _, err := exec.LookPath(manager); err == nil {
+			return manager
+		}
+	}
+	return "unknown"
+}
+
+// FindTools asks the model which tools can do a task and checks which of them
+// are installed. Installed tools come first with an example command, the
+// others with the command to install them.
+func FindTools(task string) (ChatResult[Cmds], error) {
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[Cmds]{}, err
+	}
+
+	prompt := fmt.Sprintf(whichPrompt, runtime.GOOS, packageManager(), task)
+	result, err := chatStructured[ToolCandidates](context.Background(), model, prompt, toolCandidatesSchemaParam(), DefaultGenerateOptions())
+	if err != nil {
+		return ChatResult[Cmds]{Cost: result.Cost, Model: result.Model, Tokens: result.Tokens}, err
+	}
+
+	installed := []CmdEntry{}
+	missing := []CmdEntry{}
+	for _, candidate := range result.Message.Tools {
+		if _, err := exec.LookPath(candidate.Tool); err == nil {
+			installed = append(installed, CmdEntry{
+				Cmd:     candidate.Cmd,
+				Comment: candidate.Comment,
+				Source:  "[installed]",
+			})
+		} else if candidate.Install != "" {
+			missing = append(missing, CmdEntry{
+				Cmd:     candidate.Install,
+				Comment: fmt.Sprintf("install %s", candidate.Tool),
+				Source:  "[not installed]",
+			})
+		}
+	}
+
+	return ChatResult[Cmds]{
+		Message: Cmds{Cmds: append(installed, missing...)},
+		Cost:    result.Cost,
+		Model:   result.Model,
+		Tokens:  result.Tokens,
+	}, nil
+}
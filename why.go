@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+const whyPrompt = `The user already ran the following command on the **%s** operating system,
+possibly pasted from the internet without reading it. Give a one-sentence
+summary of what it did, then break the command down into its parts (binaries,
+flags, arguments, pipes) with a short description of each. List the side effects
+it likely had, such as files created, overwritten or deleted, software
+installed, settings changed or data sent over the network, and how to undo them
+where possible.
+
+%s`
+
+type WhyExplanation struct {
+	Summary     string            `json:"summary"`
+	Parts       []ExplanationPart `json:"parts"`
+	SideEffects []string          `json:"side_effects"`
+	Undo        string            `json:"undo"`
+}
+
+var StructuredWhySchema = sync.OnceValue(GenerateSchema[WhyExplanation])
+
+func whySchemaParam() openai.ResponseFormatJSONSchemaJSONSchemaParam {
+	return openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("why"),
+		Description: openai.F("What a command that was run did, and its likely side effects."),
+		Schema:      openai.F(StructuredWhySchema()),
+		Strict:      openai.Bool(true),
+	}
+}
+
+// ExplainRanCmd asks the model what a command that was already run did
+func ExplainRanCmd(cmd string) (ChatResult[WhyExplanation], error) {
+	model, err := openAIModel()
+	if err != nil {
+		return ChatResult[WhyExplanation]{}, err
+	}
+
+	prompt := fmt.Sprintf(whyPrompt, runtime.GOOS, cmd)
+	return chatStructured[WhyExplanation](context.Background(), model, prompt, whySchemaParam(), DefaultGenerateOptions())
+}
+
+// Shell functions passing the previous command to cfor why, since cfor can't
+// see the history of the shell it runs in
+var shellIntegrations = map[string]string{
+	"bash": `why() {
+  cfor why -- "$(HISTTIMEFORMAT= builtin history 2 | head -n 1 | sed 's/^ *[0-9]*\*\{0,1\} *//')"
+}
+`,
+	"zsh": `why() {
+  cfor why -- "${history[$((HISTCMD - 1))]}"
+}
+`,
+	"fish": `function why
+    cfor why -- $history[1]
+end
+`,
+}
+
+// ShellIntegration returns the integration script for the shell
+func ShellIntegration(shell string) (string, bool) {
+	script, ok := shellIntegrations[shell]
+	return script, ok
+}
+
+// Timestamp prefix of zsh's extended history format
+var zshHistoryPrefix = regexp.MustCompile(`^: \d+:\d+;`)
+
+// LastShellCommand reads the last command from the shell's history file,
+// skipping invocations of cfor why itself. Shells that only write their
+// history on exit won't have the latest command yet; the integration from
+// cfor init passes it directly instead.
+func LastShellCommand() (string, error) {
+	shell := currentShell()
+	path := historyFileOf(shell)
+	if path == "" {
+		return "", fmt.Errorf("could not find the history file of %s", shell)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch shell {
+		case "fish":
+			cmd, ok := strings.CutPrefix(line, "- cmd: ")
+			if !ok {
+				continue
+			}
+			line = strings.NewReplacer(`\n`, "\n", `\\`, `\`).Replace(cmd)
+		case "zsh":
+			line = zshHistoryPrefix.ReplaceAllString(line, "")
+		case "bash":
+			// Timestamps written with HISTTIMEFORMAT set
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" || line == "why" || strings.HasPrefix(line, "cfor why") {
+			continue
+		}
+		last = line
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if last == "" {
+		return "", fmt.Errorf("no commands in %s", path)
+	}
+	return last, nil
+}
+
+func historyFileOf(shell string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	switch shell {
+	case "bash", "zsh":
+		if path := os.Getenv("HISTFILE"); path != "" {
+			return path
+		}
+		return filepath.Join(homeDir, "."+shell+"_history")
+	case "fish":
+		dir := os.Getenv("XDG_DATA_HOME")
+		if dir == "" {
+			dir = filepath.Join(homeDir, ".local", "share")
+		}
+		return filepath.Join(dir, "fish", "fish_history")
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The root of the current git repository, which the model is asked to call
+// "repository root", or however else it puts it, e.g. "the project root"
+var repoRootPattern = regexp.MustCompile(`(?i)^(the )?(git |project )?(repo|repository|project)( root)?( directory)?$`)
+
+// safeShellWordPattern matches paths that need no quoting
+var safeShellWordPattern = regexp.MustCompile(`^[A-Za-z0-9_./+-]+$`)
+
+// ResolveWorkdir returns the directory a suggestion has to be run from, or
+// false when it names none, or one that doesn't exist here
+func ResolveWorkdir(workdir string) (string, bool) {
+	workdir = strings.TrimSpace(workdir)
+	if workdir == "" {
+		return "", false
+	}
+
+	if repoRootPattern.MatchString(workdir) {
+		root, err := git("rev-parse", "--show-toplevel")
+		if err != nil || root == "" {
+			return "", false
+		}
+		return root, true
+	}
+
+	if workdir == "~" || strings.HasPrefix(workdir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", false
+		}
+		workdir = filepath.Join(home, strings.TrimPrefix(workdir, "~"))
+	}
+	dir, err := filepath.Abs(workdir)
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", false
+	}
+	return dir, true
+}
+
+// workdirFor returns the directory the selected command has to be run from,
+// when it isn't the current one
+func workdirFor(cmds []CmdEntry, selected string) (string, bool) {
+	for _, entry := range cmds {
+		if entry.Cmd != selected {
+			continue
+		}
+		dir, ok := ResolveWorkdir(entry.Workdir)
+		if !ok {
+			return "", false
+		}
+		if cwd, err := os.Getwd(); err == nil && filepath.Clean(cwd) == filepath.Clean(dir) {
+			return "", false
+		}
+		return dir, true
+	}
+	return "", false
+}
+
+// chdirCmd prefixes the command with a cd to the directory, so that what's
+// echoed and kept in the transcript is what ran
+func chdirCmd(dir, cmd string) string {
+	return fmt.Sprintf("cd %s && %s", shellQuote(dir), cmd)
+}
+
+// shellQuote single-quotes the word unless it's safe as is
+func shellQuote(word string) string {
+	if safeShellWordPattern.MatchString(word) {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// cmdInWorkdir returns the command to execute: when the suggestion has to be
+// run from another directory, cfor offers to cd there first, which --yes
+// accepts. Without a terminal to ask on, it runs where it is.
+func cmdInWorkdir(cmds []CmdEntry, selected string, yes bool) string {
+	dir, ok := workdirFor(cmds, selected)
+	if !ok {
+		return selected
+	}
+	if yes {
+		return chdirCmd(dir, selected)
+	}
+	if !interactiveTerminal() {
+		fmt.Fprintf(os.Stderr, "Note: this command is meant to be run from %s\n", dir)
+		return selected
+	}
+	if confirm(fmt.Sprintf("This command is meant to be run from %s. Change to it first?", dir)) {
+		return chdirCmd(dir, selected)
+	}
+	return selected
+}